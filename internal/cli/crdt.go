@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/eislab-cps/synctree/pkg/crdt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -14,22 +16,33 @@ func init() {
 	rootCmd.AddCommand(mergeCmd)
 	rootCmd.AddCommand(printCmd)
 	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(convertCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(queryCmd)
+	rootCmd.AddCommand(changesCmd)
+	rootCmd.AddCommand(clockCmd)
 
 	importCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
 	importCmd.MarkFlagRequired("prvkey")
-	importCmd.Flags().StringVarP(&JSONFile, "json", "", "", "JSON file to import")
+	importCmd.Flags().StringVarP(&JSONFile, "json", "", "", "JSON, YAML or TOML file to import")
+	importCmd.Flags().StringVarP(&Format, "format", "", "", "Format of the imported file, json, yaml or toml, detected from the file extension if not set")
 	importCmd.MarkFlagRequired("json")
 	importCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "File to store imported data")
 	importCmd.MarkFlagRequired("crdt")
 	importCmd.Flags().BoolVarP(&PrintJSON, "print", "p", false, "Print JSON to stdout")
+	importCmd.Flags().StringVarP(&Encoding, "encoding", "", "json", "Output encoding, json or binary")
+	importCmd.Flags().BoolVarP(&DeterministicIDs, "deterministic-ids", "", false, "Derive node IDs from their path, so identical imports on other replicas merge without duplicates")
+	importCmd.Flags().StringVarP(&Into, "into", "", "", "Reconcile the JSON into this path of the existing CRDT file, only changed values are updated")
 
 	exportCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
 	exportCmd.MarkFlagRequired("prvkey")
-	exportCmd.Flags().StringVarP(&JSONFile, "json", "", "", "JSON file to import")
+	exportCmd.Flags().StringVarP(&JSONFile, "json", "", "", "JSON, YAML or TOML file to export to")
 	exportCmd.MarkFlagRequired("json")
 	exportCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "File to store imported data")
 	exportCmd.MarkFlagRequired("crdt")
 	exportCmd.Flags().BoolVarP(&PrintJSON, "print", "p", false, "Print JSON to stdout")
+	exportCmd.Flags().BoolVarP(&Annotated, "annotated", "", false, "Wrap every value with its owner, clock, signature, node ID and verification status")
+	exportCmd.Flags().StringVarP(&Format, "format", "", "", "Format of the exported file, json, yaml or toml, detected from the file extension if not set")
 
 	setLiteralCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
 	setLiteralCmd.MarkFlagRequired("prvkey")
@@ -40,6 +53,7 @@ func init() {
 	setLiteralCmd.Flags().StringVarP(&LiteralValue, "value", "", "", "String literal value to set")
 	setLiteralCmd.MarkFlagRequired("value")
 	setLiteralCmd.Flags().BoolVarP(&PrintJSON, "print", "p", false, "Print JSON to stdout")
+	setLiteralCmd.Flags().StringVarP(&Encoding, "encoding", "", "json", "Output encoding, json or binary")
 
 	mergeCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
 	mergeCmd.MarkFlagRequired("prvkey")
@@ -50,6 +64,7 @@ func init() {
 	mergeCmd.Flags().StringVarP(&CRDTFileOut, "crdtout", "", "", "Output CRDT file after merge")
 	mergeCmd.MarkFlagRequired("crdtout")
 	mergeCmd.Flags().BoolVarP(&PrintJSON, "print", "p", false, "Print JSON to stdout")
+	mergeCmd.Flags().StringVarP(&Encoding, "encoding", "", "json", "Output encoding, json or binary")
 
 	printCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
 	printCmd.MarkFlagRequired("prvkey")
@@ -60,6 +75,42 @@ func init() {
 	verifyCmd.MarkFlagRequired("prvkey")
 	verifyCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "File to verify integrity of the CRDT SyncTree")
 	verifyCmd.MarkFlagRequired("crdt")
+
+	convertCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
+	convertCmd.MarkFlagRequired("prvkey")
+	convertCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "CRDT file to convert")
+	convertCmd.MarkFlagRequired("crdt")
+	convertCmd.Flags().StringVarP(&CRDTFileOut, "crdtout", "", "", "Output CRDT file")
+	convertCmd.MarkFlagRequired("crdtout")
+	convertCmd.Flags().StringVarP(&Encoding, "encoding", "", "json", "Output encoding, json or binary")
+	convertCmd.MarkFlagRequired("encoding")
+
+	migrateCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key, used to re-sign owned nodes")
+	migrateCmd.MarkFlagRequired("prvkey")
+	migrateCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "CRDT file to migrate")
+	migrateCmd.MarkFlagRequired("crdt")
+	migrateCmd.Flags().StringVarP(&CRDTFileOut, "crdtout", "", "", "Output CRDT file")
+	migrateCmd.MarkFlagRequired("crdtout")
+	migrateCmd.Flags().StringVarP(&Encoding, "encoding", "", "json", "Output encoding, json or binary")
+
+	queryCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key, only values the identity may read are returned")
+	queryCmd.MarkFlagRequired("prvkey")
+	queryCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "CRDT file to query")
+	queryCmd.MarkFlagRequired("crdt")
+	queryCmd.Flags().StringVarP(&QueryExpr, "query", "q", "", "JSONPath query, e.g. $.devices[?@.type=='hvac'].setpoint")
+	queryCmd.MarkFlagRequired("query")
+
+	changesCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
+	changesCmd.MarkFlagRequired("prvkey")
+	changesCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "CRDT file to inspect")
+	changesCmd.MarkFlagRequired("crdt")
+	changesCmd.Flags().StringVarP(&Author, "author", "", "", "List the values changed by this identity, given as an owner Id or did:key DID")
+	changesCmd.Flags().StringVarP(&Since, "since", "", "", "List the values with changes not seen by this vector clock, e.g. '{\"<id>\": 3}', see the clock command")
+
+	clockCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
+	clockCmd.MarkFlagRequired("prvkey")
+	clockCmd.Flags().StringVarP(&CRDTFile, "crdt", "", "", "CRDT file to inspect")
+	clockCmd.MarkFlagRequired("crdt")
 }
 
 var importCmd = &cobra.Command{
@@ -75,20 +126,40 @@ var importCmd = &cobra.Command{
 		c, err := crdt.NewSecureTree(PrvKey)
 		CheckError(err)
 
-		jsonData, err := os.ReadFile(JSONFile)
+		docData, err := os.ReadFile(JSONFile)
 		CheckError(err)
 
-		_, err = c.ImportJSON(jsonData, PrvKey)
+		jsonData, err := DocumentToJSON(docData, DocumentFormat(Format, JSONFile))
 		CheckError(err)
 
-		savedData, err := c.Save()
+		if DeterministicIDs {
+			c.SetIDScheme(crdt.IDSchemePath)
+		}
+
+		if cmd.Flags().Changed("into") {
+			crdtData, err := os.ReadFile(CRDTFile)
+			CheckError(err)
+
+			err = c.Load(crdtData)
+			CheckError(err)
+
+			ops, err := c.ReconcileJSON(Into, jsonData, PrvKey)
+			CheckError(err)
+
+			log.WithFields(log.Fields{"path": Into, "operations": len(ops)}).Info("Reconciled JSON into CRDT SyncTree")
+		} else {
+			_, err = c.ImportJSON(jsonData, PrvKey)
+			CheckError(err)
+		}
+
+		savedData, err := c.SaveAs(crdt.Format(Encoding))
 		CheckError(err)
 
 		err = os.WriteFile(CRDTFile, savedData, 0644)
 		CheckError(err)
 
 		if PrintJSON {
-			log.Info(string(jsonData))
+			log.Info(string(docData))
 		}
 	},
 }
@@ -112,13 +183,22 @@ var exportCmd = &cobra.Command{
 		err = c.Load(crdtData)
 		CheckError(err)
 
-		jsonData, err := c.ExportJSON()
+		format := DocumentFormat(Format, JSONFile)
+		var docData []byte
+		if Annotated {
+			if format != "json" {
+				CheckError(fmt.Errorf("annotated export is only supported as json, not %s", format))
+			}
+			docData, err = c.ExportAnnotatedJSON()
+		} else {
+			docData, err = ExportDocument(c, format)
+		}
 		CheckError(err)
-		err = os.WriteFile(JSONFile, jsonData, 0644)
+		err = os.WriteFile(JSONFile, docData, 0644)
 		CheckError(err)
 
 		if PrintJSON {
-			log.Info(string(jsonData))
+			log.Info(string(docData))
 		}
 	},
 }
@@ -150,7 +230,7 @@ var setLiteralCmd = &cobra.Command{
 		err = node.SetLiteral(LiteralValue, PrvKey)
 		CheckError(err)
 
-		savedData, err := c.Save()
+		savedData, err := c.SaveAs(crdt.Format(Encoding))
 		CheckError(err)
 		err = os.WriteFile(CRDTFile, savedData, 0644)
 		CheckError(err)
@@ -195,7 +275,7 @@ var mergeCmd = &cobra.Command{
 		err = c1.Merge(c2, PrvKey)
 		CheckError(err)
 
-		savedData, err := c1.Save()
+		savedData, err := c1.SaveAs(crdt.Format(Encoding))
 		CheckError(err)
 
 		err = os.WriteFile(CRDTFileOut, savedData, 0644)
@@ -257,3 +337,170 @@ var verifyCmd = &cobra.Command{
 		log.Info("CRDT SyncTree integrity verified successfully")
 	},
 }
+
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert a CRDT SyncTree file between the JSON and binary formats",
+	Long:  "Convert a CRDT SyncTree file between the JSON and binary formats",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := crdt.NewSecureTree(PrvKey)
+		CheckError(err)
+
+		crdtData, err := os.ReadFile(CRDTFile)
+		CheckError(err)
+
+		err = c.Load(crdtData)
+		CheckError(err)
+
+		savedData, err := c.SaveAs(crdt.Format(Encoding))
+		CheckError(err)
+
+		err = os.WriteFile(CRDTFileOut, savedData, 0644)
+		CheckError(err)
+
+		log.WithFields(log.Fields{
+			"crdt":     CRDTFile,
+			"crdtout":  CRDTFileOut,
+			"encoding": Encoding,
+			"size":     len(crdtData),
+			"newsize":  len(savedData),
+		}).Info("Converted CRDT SyncTree")
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade a CRDT SyncTree file to the current file format version",
+	Long:  "Upgrade a CRDT SyncTree file to the current file format version, nodes owned by the private key are re-signed where needed",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := crdt.NewSecureTree(PrvKey)
+		CheckError(err)
+
+		crdtData, err := os.ReadFile(CRDTFile)
+		CheckError(err)
+
+		report, err := c.Migrate(crdtData, PrvKey)
+		CheckError(err)
+
+		savedData, err := c.SaveAs(crdt.Format(Encoding))
+		CheckError(err)
+
+		err = os.WriteFile(CRDTFileOut, savedData, 0644)
+		CheckError(err)
+
+		log.WithFields(log.Fields{
+			"crdt":        CRDTFile,
+			"crdtout":     CRDTFileOut,
+			"fromVersion": report.FromVersion,
+			"toVersion":   report.ToVersion,
+			"applied":     report.Applied,
+			"resigned":    report.Resigned,
+			"unresolved":  report.Unresolved,
+		}).Info("Migrated CRDT SyncTree")
+
+		if len(report.Unresolved) > 0 {
+			log.WithFields(log.Fields{"nodes": report.Unresolved}).Warn("Some nodes must be re-signed by their owners")
+		}
+	},
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query CRDT SyncTree with JSONPath",
+	Long:  "Query the CRDT SyncTree with a JSONPath (RFC 9535) expression, prints the JSON pointer, node ID and value of every match",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.WithFields(log.Fields{
+			"crdt":  CRDTFile,
+			"query": QueryExpr,
+		}).Info("Querying CRDT SyncTree")
+
+		c, err := crdt.NewSecureTree(PrvKey)
+		CheckError(err)
+
+		crdtData, err := os.ReadFile(CRDTFile)
+		CheckError(err)
+
+		err = c.Load(crdtData)
+		CheckError(err)
+
+		results, err := c.Query(QueryExpr, PrvKey)
+		CheckError(err)
+
+		resultsJSON, err := json.MarshalIndent(results, "", "  ")
+		CheckError(err)
+		fmt.Println(string(resultsJSON))
+	},
+}
+
+var changesCmd = &cobra.Command{
+	Use:   "changes",
+	Short: "List changes in CRDT SyncTree",
+	Long:  "List the values changed by an identity (--author) or not seen by a vector clock (--since), with both only the values matching both are listed. Prints the JSON pointer, node ID, value, owner and clock of every value",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.WithFields(log.Fields{
+			"crdt":   CRDTFile,
+			"author": Author,
+			"since":  Since,
+		}).Info("Listing changes in CRDT SyncTree")
+
+		if Author == "" && Since == "" {
+			CheckError(fmt.Errorf("--author or --since must be set"))
+		}
+
+		c, err := crdt.NewSecureTree(PrvKey)
+		CheckError(err)
+
+		crdtData, err := os.ReadFile(CRDTFile)
+		CheckError(err)
+
+		err = c.Load(crdtData)
+		CheckError(err)
+
+		var changes []*crdt.NodeChange
+		if Since != "" {
+			var clock crdt.VectorClock
+			err = json.Unmarshal([]byte(Since), &clock)
+			CheckError(err)
+
+			changes, err = c.ChangedSince(clock)
+			CheckError(err)
+		}
+		if Author != "" {
+			touched, err := c.PathsTouchedBy(ResolveOwnerID(Author))
+			CheckError(err)
+			if Since == "" {
+				changes = touched
+			} else {
+				changes = IntersectChanges(changes, touched)
+			}
+		}
+
+		changesJSON, err := json.MarshalIndent(changes, "", "  ")
+		CheckError(err)
+		fmt.Println(string(changesJSON))
+	},
+}
+
+var clockCmd = &cobra.Command{
+	Use:   "clock",
+	Short: "Print the vector clock of CRDT SyncTree",
+	Long:  "Print the merged vector clock of all nodes, pass it to changes --since later to list what changed in between",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.WithFields(log.Fields{
+			"crdt": CRDTFile,
+		}).Info("Printing vector clock of CRDT SyncTree")
+
+		c, err := crdt.NewSecureTree(PrvKey)
+		CheckError(err)
+
+		crdtData, err := os.ReadFile(CRDTFile)
+		CheckError(err)
+
+		err = c.Load(crdtData)
+		CheckError(err)
+
+		clockJSON, err := json.Marshal(c.Clock())
+		CheckError(err)
+		fmt.Println(string(clockJSON))
+	},
+}
@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"fmt"
+
 	icrypto "github.com/eislab-cps/synctree/internal/crypto"
 	"github.com/eislab-cps/synctree/pkg/security/crypto"
 	log "github.com/sirupsen/logrus"
@@ -10,9 +12,22 @@ import (
 func init() {
 	keychainCmd.AddCommand(genPrivateKeyCmd)
 	keychainCmd.AddCommand(idCmd)
+	keychainCmd.AddCommand(didCmd)
+	keychainCmd.AddCommand(mnemonicCmd)
+	keychainCmd.AddCommand(deriveCmd)
 	rootCmd.AddCommand(keychainCmd)
 
 	idCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
+	idCmd.Flags().StringVarP(&DID, "did", "", "", "DID to resolve to an Id")
+
+	didCmd.Flags().StringVarP(&PrvKey, "prvkey", "", "", "Private key")
+	didCmd.Flags().StringVarP(&DID, "did", "", "", "DID to resolve")
+	didCmd.Flags().BoolVarP(&PrintDocument, "document", "d", false, "Print the DID Document to stdout")
+
+	deriveCmd.Flags().StringVarP(&Mnemonic, "mnemonic", "", "", "BIP39 mnemonic of the master seed")
+	deriveCmd.Flags().StringVarP(&Passphrase, "passphrase", "", "", "Optional BIP39 passphrase")
+	deriveCmd.Flags().Uint32VarP(&DeviceIndex, "index", "", 0, "Device index")
+	deriveCmd.MarkFlagRequired("mnemonic")
 }
 
 var keychainCmd = &cobra.Command{
@@ -42,8 +57,85 @@ var idCmd = &cobra.Command{
 	Short: "Show the Id for a given private key",
 	Long:  "Show the Id for a given private key",
 	Run: func(cmd *cobra.Command, args []string) {
+		if DID != "" {
+			id, err := icrypto.IDFromDID(DID)
+			CheckError(err)
+			log.WithFields(log.Fields{"Id": id}).Info("Corresponding Id for the given DID")
+			return
+		}
+
 		id, err := icrypto.GenerateID(PrvKey)
 		CheckError(err)
 		log.WithFields(log.Fields{"Id": id}).Info("Corresponding Id for the given private key")
 	},
 }
+
+var didCmd = &cobra.Command{
+	Use:   "did",
+	Short: "Show the did:key DID for a given private key, or resolve a DID",
+	Long:  "Show the did:key DID for a given private key, or resolve a DID",
+	Run: func(cmd *cobra.Command, args []string) {
+		crypto := crypto.CreateCrypto()
+
+		did := DID
+		if did == "" {
+			var err error
+			did, err = crypto.GenerateDID(PrvKey)
+			CheckError(err)
+		}
+
+		id, err := crypto.DIDToID(did)
+		CheckError(err)
+
+		doc, err := crypto.ResolveDID(did)
+		CheckError(err)
+
+		log.WithFields(log.Fields{"DID": did, "Id": id}).Info("Corresponding DID and Id")
+
+		if PrintDocument {
+			fmt.Println(doc)
+		}
+	},
+}
+
+var mnemonicCmd = &cobra.Command{
+	Use:   "mnemonic",
+	Short: "Generate a BIP39 mnemonic for a master seed",
+	Long:  "Generate a BIP39 mnemonic for a master seed, device keys are derived from it with key derive",
+	Run: func(cmd *cobra.Command, args []string) {
+		crypto := crypto.CreateCrypto()
+		mnemonic, err := crypto.GenerateMnemonic()
+		CheckError(err)
+
+		masterPrvKey, err := crypto.MasterPrivateKey(mnemonic, "")
+		CheckError(err)
+
+		id, err := crypto.GenerateID(masterPrvKey)
+		CheckError(err)
+
+		log.WithFields(log.Fields{"MasterId": id}).Info("Generated new mnemonic, store it in a safe place")
+		fmt.Println(mnemonic)
+	},
+}
+
+var deriveCmd = &cobra.Command{
+	Use:   "derive",
+	Short: "Derive the private key of a device from a mnemonic",
+	Long:  "Derive the private key of a device from a mnemonic",
+	Run: func(cmd *cobra.Command, args []string) {
+		crypto := crypto.CreateCrypto()
+		masterPrvKey, err := crypto.MasterPrivateKey(Mnemonic, Passphrase)
+		CheckError(err)
+
+		masterID, err := crypto.GenerateID(masterPrvKey)
+		CheckError(err)
+
+		prvKey, err := crypto.DeriveDevicePrivateKey(Mnemonic, Passphrase, DeviceIndex)
+		CheckError(err)
+
+		id, err := crypto.GenerateID(prvKey)
+		CheckError(err)
+
+		log.WithFields(log.Fields{"MasterId": masterID, "Index": DeviceIndex, "Id": id, "PrvKey": prvKey}).Info("Derived device private key")
+	},
+}
@@ -19,6 +19,19 @@ var LiteralValue string
 var CRDTFileIn1 string
 var CRDTFileIn2 string
 var CRDTFileOut string
+var DID string
+var PrintDocument bool
+var Mnemonic string
+var Passphrase string
+var DeviceIndex uint32
+var Format string
+var Encoding string
+var Annotated bool
+var QueryExpr string
+var Author string
+var Since string
+var Into string
+var DeterministicIDs bool
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
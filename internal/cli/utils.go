@@ -1,9 +1,15 @@
 package cli
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	icrypto "github.com/eislab-cps/synctree/internal/crypto"
 	"github.com/eislab-cps/synctree/pkg/build"
+	"github.com/eislab-cps/synctree/pkg/crdt"
 	log "github.com/sirupsen/logrus"
-	"os"
 )
 
 func CheckError(err error) {
@@ -12,3 +18,65 @@ func CheckError(err error) {
 		os.Exit(-1)
 	}
 }
+
+// ResolveOwnerID accepts either an owner Id or a did:key DID and returns the owner Id
+func ResolveOwnerID(idOrDID string) string {
+	id, err := icrypto.ResolveID(idOrDID)
+	CheckError(err)
+	return id
+}
+
+// DocumentFormat returns the document format, detected from the file extension if format is empty
+func DocumentFormat(format string, filename string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// DocumentToJSON converts a JSON, YAML or TOML document to JSON
+func DocumentToJSON(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return data, nil
+	case "yaml", "yml":
+		return crdt.YAMLToJSON(data)
+	case "toml":
+		return crdt.TOMLToJSON(data)
+	}
+	return nil, fmt.Errorf("unsupported document format %s, use json, yaml or toml", format)
+}
+
+// ExportDocument exports the tree as a JSON, YAML or TOML document
+func ExportDocument(c crdt.SecureTree, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return c.ExportJSON()
+	case "yaml", "yml":
+		return c.ExportYAML()
+	case "toml":
+		return c.ExportTOML()
+	}
+	return nil, fmt.Errorf("unsupported document format %s, use json, yaml or toml", format)
+}
+
+// IntersectChanges returns the changes of a that are also in b
+func IntersectChanges(a, b []*crdt.NodeChange) []*crdt.NodeChange {
+	inB := make(map[crdt.NodeID]bool, len(b))
+	for _, change := range b {
+		inB[change.NodeID] = true
+	}
+	changes := make([]*crdt.NodeChange, 0)
+	for _, change := range a {
+		if inB[change.NodeID] {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
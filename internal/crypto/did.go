@@ -0,0 +1,213 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// Identities are rendered as did:key identifiers, see https://w3c-ccg.github.io/did-method-key/.
+// The method specific identifier is the base58btc multibase encoding of the multicodec
+// secp256k1-pub prefix followed by the compressed public key.
+
+const DIDKeyPrefix = "did:key:"
+
+var secp256k1PubMulticodec = []byte{0xe7, 0x01}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+type DIDDocument struct {
+	Context              []string             `json:"@context"`
+	ID                   string               `json:"id"`
+	VerificationMethod   []VerificationMethod `json:"verificationMethod"`
+	Authentication       []string             `json:"authentication"`
+	AssertionMethod      []string             `json:"assertionMethod"`
+	CapabilityDelegation []string             `json:"capabilityDelegation"`
+	CapabilityInvocation []string             `json:"capabilityInvocation"`
+}
+
+func (idendity *Idendity) DID() string {
+	did, _ := DIDFromPublicKey(idendity.PublicKey()) // The public key of an identity is always valid
+	return did
+}
+
+func DIDFromPublicKey(publicKey []byte) (string, error) {
+	pub, err := btcec.ParsePubKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("Invalid public key: %w", err)
+	}
+
+	buf := append(append([]byte{}, secp256k1PubMulticodec...), pub.SerializeCompressed()...)
+
+	return DIDKeyPrefix + "z" + base58Encode(buf), nil
+}
+
+// PublicKeyFromDID returns the uncompressed public key of a did:key identifier
+func PublicKeyFromDID(did string) ([]byte, error) {
+	did = strings.SplitN(did, "#", 2)[0]
+
+	if !strings.HasPrefix(did, DIDKeyPrefix) {
+		return nil, fmt.Errorf("Unsupported DID %s, only did:key is supported", did)
+	}
+
+	multibase := strings.TrimPrefix(did, DIDKeyPrefix)
+	if !strings.HasPrefix(multibase, "z") {
+		return nil, fmt.Errorf("Unsupported multibase encoding in DID %s, only base58btc is supported", did)
+	}
+
+	buf, err := base58Decode(multibase[1:])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode DID %s: %w", did, err)
+	}
+
+	if len(buf) < len(secp256k1PubMulticodec) || buf[0] != secp256k1PubMulticodec[0] || buf[1] != secp256k1PubMulticodec[1] {
+		return nil, fmt.Errorf("Unsupported key type in DID %s, only secp256k1 is supported", did)
+	}
+
+	pub, err := btcec.ParsePubKey(buf[len(secp256k1PubMulticodec):])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key in DID %s: %w", did, err)
+	}
+
+	return pub.SerializeUncompressed(), nil
+}
+
+// IDFromDID returns the ID used as owner in SyncTree for the identity behind the DID
+func IDFromDID(did string) (string, error) {
+	publicKey, err := PublicKeyFromDID(did)
+	if err != nil {
+		return "", err
+	}
+
+	return IDFromPublicKey(publicKey), nil
+}
+
+// PublicKeyFromString accepts a did:key DID or a hex encoded public key and returns the uncompressed public key
+func PublicKeyFromString(str string) ([]byte, error) {
+	if IsDID(str) {
+		return PublicKeyFromDID(str)
+	}
+
+	buf, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key %s: %w", str, err)
+	}
+	pub, err := btcec.ParsePubKey(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key %s: %w", str, err)
+	}
+
+	return pub.SerializeUncompressed(), nil
+}
+
+func IDFromPublicKey(publicKey []byte) string {
+	return GenerateHashFromString(hex.EncodeToString(publicKey)).String()
+}
+
+func IsDID(str string) bool {
+	return strings.HasPrefix(str, "did:")
+}
+
+// ResolveID accepts either an ID or a DID and returns the ID
+func ResolveID(idOrDID string) (string, error) {
+	if IsDID(idOrDID) {
+		return IDFromDID(idOrDID)
+	}
+	return idOrDID, nil
+}
+
+func ResolveDID(did string) (*DIDDocument, error) {
+	did = strings.SplitN(did, "#", 2)[0]
+
+	publicKey, err := PublicKeyFromDID(did)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := DIDFromPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if normalized != did {
+		return nil, fmt.Errorf("DID %s is not in canonical form, expected %s", did, normalized)
+	}
+
+	multibase := strings.TrimPrefix(did, DIDKeyPrefix)
+	keyID := did + "#" + multibase
+
+	return &DIDDocument{
+		Context: []string{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/multikey/v1"},
+		ID:      did,
+		VerificationMethod: []VerificationMethod{
+			{
+				ID:                 keyID,
+				Type:               "Multikey",
+				Controller:         did,
+				PublicKeyMultibase: multibase,
+			},
+		},
+		Authentication:       []string{keyID},
+		AssertionMethod:      []string{keyID},
+		CapabilityDelegation: []string{keyID},
+		CapabilityInvocation: []string{keyID},
+	}, nil
+}
+
+func base58Encode(buf []byte) string {
+	x := new(big.Int).SetBytes(buf)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range buf {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return string(encoded)
+}
+
+func base58Decode(str string) ([]byte, error) {
+	if len(str) == 0 {
+		return nil, errors.New("Empty base58 string")
+	}
+
+	x := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range str {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("Invalid base58 character %q", r)
+		}
+		x.Mul(x, radix)
+		x.Add(x, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(str) && str[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+
+	return append(make([]byte, leadingZeros), x.Bytes()...), nil
+}
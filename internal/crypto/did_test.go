@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDID(t *testing.T) {
+	idendity, err := CreateIdendityFromString("6d2fb6f546bacfd98c68769e61e0b44a697a30596c018a50e28200aa59b01c0a")
+	assert.Nil(t, err)
+
+	did := idendity.DID()
+	assert.True(t, strings.HasPrefix(did, "did:key:zQ3s"), "secp256k1 did:key identifiers should start with zQ3s")
+
+	id, err := IDFromDID(did)
+	assert.Nil(t, err)
+	assert.Equal(t, idendity.ID(), id)
+
+	id, err = ResolveID(did)
+	assert.Nil(t, err)
+	assert.Equal(t, idendity.ID(), id)
+
+	id, err = ResolveID(idendity.ID())
+	assert.Nil(t, err)
+	assert.Equal(t, idendity.ID(), id, "ResolveID should return plain IDs unchanged")
+
+	publicKey, err := PublicKeyFromDID(did)
+	assert.Nil(t, err)
+	assert.Equal(t, idendity.PublicKey(), publicKey)
+
+	doc, err := ResolveDID(did)
+	assert.Nil(t, err)
+	assert.Equal(t, did, doc.ID)
+	assert.Len(t, doc.VerificationMethod, 1)
+	assert.Equal(t, did, doc.VerificationMethod[0].Controller)
+	assert.Equal(t, "Multikey", doc.VerificationMethod[0].Type)
+	assert.Equal(t, did+"#"+doc.VerificationMethod[0].PublicKeyMultibase, doc.VerificationMethod[0].ID)
+	assert.Equal(t, []string{doc.VerificationMethod[0].ID}, doc.Authentication)
+
+	_, err = IDFromDID(doc.VerificationMethod[0].ID)
+	assert.Nil(t, err, "DID URLs with a fragment should be accepted")
+
+	_, err = IDFromDID("did:web:example.com")
+	assert.NotNil(t, err)
+
+	_, err = IDFromDID("did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK")
+	assert.NotNil(t, err, "Ed25519 keys should be rejected")
+
+	_, err = IDFromDID("did:key:z0OIl")
+	assert.NotNil(t, err, "Invalid base58 should be rejected")
+}
+
+func TestBase58(t *testing.T) {
+	assert.Equal(t, "StV1DL6CwTryKyV", base58Encode([]byte("hello world")))
+	assert.Equal(t, "11", base58Encode([]byte{0, 0}))
+
+	buf, err := base58Decode("1StV1DL6CwTryKyV")
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{0}, []byte("hello world")...), buf)
+}
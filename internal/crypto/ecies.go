@@ -0,0 +1,85 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// ECIES over secp256k1: an ephemeral key pair is generated for every message, the AES-256-GCM key is
+// derived from the ECDH shared secret and the ephemeral public key. The output is the compressed
+// ephemeral public key, followed by the GCM nonce and the ciphertext.
+
+const compressedPubKeyLength = 33
+
+func ECIESEncrypt(publicKey []byte, plaintext []byte) ([]byte, error) {
+	pub, err := btcec.ParsePubKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key: %w", err)
+	}
+
+	ephemeral, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PubKey().SerializeCompressed()
+
+	gcm, err := eciesCipher(btcec.GenerateSharedSecret(ephemeral, pub), ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(ephemeralPub, nonce...)
+	return gcm.Seal(out, nonce, plaintext, ephemeralPub), nil
+}
+
+func ECIESDecrypt(idendity *Idendity, data []byte) ([]byte, error) {
+	if len(data) < compressedPubKeyLength {
+		return nil, errors.New("Invalid ECIES ciphertext, too short")
+	}
+
+	ephemeralPub := data[:compressedPubKeyLength]
+	pub, err := btcec.ParsePubKey(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ephemeral public key: %w", err)
+	}
+
+	prv, _ := btcec.PrivKeyFromBytes(idendity.prv.D.FillBytes(make([]byte, 32)))
+
+	gcm, err := eciesCipher(btcec.GenerateSharedSecret(prv, pub), ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := data[compressedPubKeyLength:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("Invalid ECIES ciphertext, too short")
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func eciesCipher(sharedSecret []byte, ephemeralPub []byte) (cipher.AEAD, error) {
+	key := GenerateHash(append(append([]byte("ecies:"), sharedSecret...), ephemeralPub...)).Bytes()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestECIES(t *testing.T) {
+	idendity, err := CreateIdendity()
+	assert.Nil(t, err)
+	other, err := CreateIdendity()
+	assert.Nil(t, err)
+
+	ciphertext, err := ECIESEncrypt(idendity.PublicKey(), []byte("secret"))
+	assert.Nil(t, err)
+
+	plaintext, err := ECIESDecrypt(idendity, ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	_, err = ECIESDecrypt(other, ciphertext)
+	assert.NotNil(t, err, "Only the recipient should be able to decrypt")
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = ECIESDecrypt(idendity, ciphertext)
+	assert.NotNil(t, err, "Modified ciphertext should be rejected")
+
+	_, err = ECIESDecrypt(idendity, []byte{1, 2, 3})
+	assert.NotNil(t, err)
+}
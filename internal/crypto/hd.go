@@ -0,0 +1,235 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Hierarchical deterministic keys following BIP32, with BIP39 mnemonics for backing up the master seed.
+// Only hardened derivation is used for device keys, so a leaked device key reveals nothing about the
+// master key or the other devices.
+
+const HardenedKeyStart = 0x80000000
+
+// DevicePath is the derivation path of device keys, %d is the device index
+const DevicePath = "m/7470'/0'/%d'"
+
+//go:embed bip39_english.txt
+var bip39English string
+
+var bip39Words = strings.Split(strings.TrimSpace(bip39English), "\n")
+var bip39Index = func() map[string]int {
+	index := make(map[string]int, len(bip39Words))
+	for i, word := range bip39Words {
+		index[word] = i
+	}
+	return index
+}()
+
+type ExtendedKey struct {
+	key       []byte
+	chainCode []byte
+	depth     uint8
+	index     uint32
+}
+
+// NewMnemonic generates a mnemonic with the given entropy size in bits, 128 to 256 in steps of 32
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", fmt.Errorf("Invalid entropy size %d, must be 128 to 256 bits in steps of 32", bits)
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return MnemonicFromEntropy(entropy)
+}
+
+func MnemonicFromEntropy(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", fmt.Errorf("Invalid entropy size %d, must be 128 to 256 bits in steps of 32", bits)
+	}
+
+	checksumBits := bits / 32
+	checksum := sha256.Sum256(entropy)
+
+	// Entropy followed by the checksum bits, split into groups of 11 bits
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	count := (bits + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = bip39Words[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+func EntropyFromMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("Invalid mnemonic, must have 12 to 24 words in steps of 3, got %d", len(words))
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := bip39Index[word]
+		if !ok {
+			return nil, fmt.Errorf("Invalid mnemonic, unknown word %q", word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	checksum := new(big.Int).And(data, big.NewInt(int64(1<<checksumBits-1))).Int64()
+	data.Rsh(data, uint(checksumBits))
+
+	entropy := data.FillBytes(make([]byte, checksumBits*4))
+	expected := sha256.Sum256(entropy)
+	if int64(expected[0]>>(8-checksumBits)) != checksum {
+		return nil, errors.New("Invalid mnemonic, checksum mismatch")
+	}
+
+	return entropy, nil
+}
+
+// SeedFromMnemonic validates the mnemonic and returns the 64 byte BIP39 seed
+func SeedFromMnemonic(mnemonic string, passphrase string) ([]byte, error) {
+	if _, err := EntropyFromMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("Invalid seed length %d, must be 16 to 64 bytes", len(seed))
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	key := new(big.Int).SetBytes(sum[:32])
+	if key.Sign() == 0 || key.Cmp(secp256k1N) >= 0 {
+		return nil, errors.New("Invalid master key, use another seed")
+	}
+
+	return &ExtendedKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0}, k.key...)
+	} else {
+		prv, _ := btcec.PrivKeyFromBytes(k.key)
+		data = prv.PubKey().SerializeCompressed()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(secp256k1N) >= 0 {
+		return nil, fmt.Errorf("Invalid child key at index %d", index)
+	}
+	childKey := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+	childKey.Mod(childKey, secp256k1N)
+	if childKey.Sign() == 0 {
+		return nil, fmt.Errorf("Invalid child key at index %d", index)
+	}
+
+	return &ExtendedKey{
+		key:       childKey.FillBytes(make([]byte, 32)),
+		chainCode: sum[32:],
+		depth:     k.depth + 1,
+		index:     index,
+	}, nil
+}
+
+// Derive follows a path such as m/0'/1/2', where ' or h marks hardened indexes
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("Invalid derivation path %s, must start with m", path)
+	}
+
+	key := k
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		part = strings.TrimRight(part, "'h")
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= HardenedKeyStart {
+			return nil, fmt.Errorf("Invalid index %q in derivation path %s", part, path)
+		}
+		if hardened {
+			index += HardenedKeyStart
+		}
+
+		key, err = key.Child(uint32(index))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (k *ExtendedKey) PrivateKeyAsHex() string {
+	return hex.EncodeToString(k.key)
+}
+
+func (k *ExtendedKey) Identity() (*Idendity, error) {
+	return CreateIdendityFromString(k.PrivateKeyAsHex())
+}
+
+// DeriveDeviceIdentity derives the identity of a device from the master seed
+func DeriveDeviceIdentity(seed []byte, device uint32) (*Idendity, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := master.Derive(fmt.Sprintf(DevicePath, device))
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Identity()
+}
+
+// MasterIdentity returns the identity of the master key of the seed
+func MasterIdentity(seed []byte) (*Idendity, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return master.Identity()
+}
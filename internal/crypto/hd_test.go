@@ -0,0 +1,106 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonic(t *testing.T) {
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{
+			entropy:  "00000000000000000000000000000000",
+			mnemonic: "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			seed:     "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			entropy:  "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			mnemonic: "legal winner thank year wave sausage worth useful legal winner thank yellow",
+			seed:     "2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+	}
+
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := MnemonicFromEntropy(entropy)
+		assert.Nil(t, err)
+		assert.Equal(t, v.mnemonic, mnemonic)
+
+		decoded, err := EntropyFromMnemonic(mnemonic)
+		assert.Nil(t, err)
+		assert.Equal(t, entropy, decoded)
+
+		seed, err := SeedFromMnemonic(mnemonic, "TREZOR")
+		assert.Nil(t, err)
+		assert.Equal(t, v.seed, hex.EncodeToString(seed))
+	}
+
+	mnemonic, err := NewMnemonic(256)
+	assert.Nil(t, err)
+	assert.Len(t, strings.Fields(mnemonic), 24)
+	_, err = EntropyFromMnemonic(mnemonic)
+	assert.Nil(t, err)
+
+	_, err = NewMnemonic(100)
+	assert.NotNil(t, err)
+
+	_, err = SeedFromMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", "")
+	assert.NotNil(t, err, "A mnemonic with an invalid checksum should be rejected")
+
+	_, err = SeedFromMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon synctree", "")
+	assert.NotNil(t, err, "A mnemonic with an unknown word should be rejected")
+}
+
+func TestExtendedKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	assert.Nil(t, err)
+	assert.Equal(t, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", master.PrivateKeyAsHex())
+
+	vectors := map[string]string{
+		"m":         "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+		"m/0'":      "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+		"m/0h/1":    "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+		"m/0'/1/2'": "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+	}
+	for path, prvKey := range vectors {
+		key, err := master.Derive(path)
+		assert.Nil(t, err)
+		assert.Equal(t, prvKey, key.PrivateKeyAsHex(), path)
+	}
+
+	_, err = master.Derive("0/1")
+	assert.NotNil(t, err)
+	_, err = master.Derive("m/x")
+	assert.NotNil(t, err)
+}
+
+func TestDeriveDeviceIdentity(t *testing.T) {
+	mnemonic, err := NewMnemonic(128)
+	assert.Nil(t, err)
+	seed, err := SeedFromMnemonic(mnemonic, "")
+	assert.Nil(t, err)
+
+	master, err := MasterIdentity(seed)
+	assert.Nil(t, err)
+	device0, err := DeriveDeviceIdentity(seed, 0)
+	assert.Nil(t, err)
+	device1, err := DeriveDeviceIdentity(seed, 1)
+	assert.Nil(t, err)
+
+	assert.NotEqual(t, master.ID(), device0.ID())
+	assert.NotEqual(t, device0.ID(), device1.ID())
+
+	// Restoring from the mnemonic gives the same keys
+	seed2, err := SeedFromMnemonic(mnemonic, "")
+	assert.Nil(t, err)
+	restored, err := DeriveDeviceIdentity(seed2, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, device1.PrivateKeyAsHex(), restored.PrivateKeyAsHex())
+}
@@ -19,15 +19,26 @@ const (
 )
 
 type ABACRule struct {
-	Recursive bool `json:"recursive"`
+	Recursive bool     `json:"recursive"`
+	Devices   bool     `json:"devices,omitempty"` // Also applies to the devices linked to the client
+	Threshold int      `json:"threshold,omitempty"`
+	Approvers []string `json:"approvers,omitempty"`
 }
 
 type TreeChecker interface {
 	isDescendant(root NodeID, target NodeID) bool
+	keyChain(id string) []string
+	policySignerAllowed(ownerID string, signerID string, signature string) bool
+	deviceMaster(id string) string
+	dropUnprotectedProposals()
+	protectedValues() map[NodeID]interface{}
+	releaseValues(values map[NodeID]interface{}, identity *crypto.Idendity) error
 }
 
 type ABACPolicy struct {
 	Rules     map[string]map[ABACAction]map[NodeID]ABACRule `json:"rules"`
+	Approvals map[NodeID]ABACRule                           `json:"approvals,omitempty"`
+	Pinned    map[NodeID]interface{}                        `json:"pinned,omitempty"` // Values of protected literals when their rule was attached
 	OwnerID   string                                        `json:"ownerID"`
 	Clock     VectorClock                                   `json:"clock"`
 	Nounce    string                                        `json:"nounce"`
@@ -51,6 +62,20 @@ func (p *ABACPolicy) SetTree(tree TreeChecker) {
 }
 
 func (p *ABACPolicy) Allow(id string, action ABACAction, nodeID NodeID, recursive bool) error {
+	return p.allow(id, action, nodeID, ABACRule{Recursive: recursive})
+}
+
+// AllowWithDevices grants the rule to a master identity and to all devices linked to it
+func (p *ABACPolicy) AllowWithDevices(masterID string, action ABACAction, nodeID NodeID, recursive bool) error {
+	return p.allow(masterID, action, nodeID, ABACRule{Recursive: recursive, Devices: true})
+}
+
+func (p *ABACPolicy) allow(id string, action ABACAction, nodeID NodeID, rule ABACRule) error {
+	id, err := crypto.ResolveID(id)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve ID: %w", err)
+	}
+
 	clientID := ClientID(p.identity.ID())
 
 	// Step 1: Prepare new clock
@@ -78,7 +103,7 @@ func (p *ABACPolicy) Allow(id string, action ABACAction, nodeID NodeID, recursiv
 		if _, ok := p.Rules[id][action]; !ok {
 			p.Rules[id][action] = make(map[NodeID]ABACRule)
 		}
-		p.Rules[id][action][nodeID] = ABACRule{Recursive: recursive}
+		p.Rules[id][action][nodeID] = rule
 
 		// Step 4: Sign
 		err := p.Sign()
@@ -109,6 +134,11 @@ func (p *ABACPolicy) UpdateRule(id string, action ABACAction, nodeID NodeID, rec
 }
 
 func (p *ABACPolicy) RemoveRule(id string, action ABACAction, nodeID NodeID) error {
+	id, err := crypto.ResolveID(id)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve ID: %w", err)
+	}
+
 	clientID := ClientID(p.identity.ID())
 
 	// Step 1: Prepare new clock
@@ -165,6 +195,146 @@ func (p *ABACPolicy) RemoveRule(id string, action ABACAction, nodeID NodeID) err
 	return nil
 }
 
+// RequireApproval protects a node, and optionally its subtree, so that literal changes only take effect
+// once threshold of the given approvers have signed them
+func (p *ABACPolicy) RequireApproval(nodeID NodeID, approvers []string, threshold int, recursive bool) error {
+	if threshold < 1 || threshold > len(approvers) {
+		return fmt.Errorf("Invalid approval threshold %d for %d approvers", threshold, len(approvers))
+	}
+
+	resolved := make([]string, len(approvers))
+	for i, approver := range approvers {
+		id, err := crypto.ResolveID(approver)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve approver: %w", err)
+		}
+		resolved[i] = id
+	}
+
+	return p.updateApprovals("RequireApproval", func() {
+		if p.Approvals == nil {
+			p.Approvals = make(map[NodeID]ABACRule)
+		}
+		p.Approvals[nodeID] = ABACRule{
+			Recursive: recursive,
+			Threshold: threshold,
+			Approvers: resolved,
+		}
+		p.pinProtected()
+	})
+}
+
+// pinProtected records the values of newly protected literals, they are served until a change is approved
+// and direct changes of them are rejected by VerifyTree
+func (p *ABACPolicy) pinProtected() {
+	if p.tree == nil {
+		return
+	}
+	for id, value := range p.tree.protectedValues() {
+		if _, ok := p.Pinned[id]; ok {
+			continue
+		}
+		if p.Pinned == nil {
+			p.Pinned = make(map[NodeID]interface{})
+		}
+		p.Pinned[id] = value
+	}
+}
+
+// RemoveApprovalRule removes the rule of a node. Literals that are no longer protected keep the value they
+// were served, it is written to the literal by the owner of the policy.
+func (p *ABACPolicy) RemoveApprovalRule(nodeID NodeID) error {
+	var served map[NodeID]interface{}
+	if p.tree != nil {
+		served = p.tree.protectedValues()
+	}
+	err := p.updateApprovals("RemoveApprovalRule", func() {
+		delete(p.Approvals, nodeID)
+		for id := range p.Pinned {
+			if p.tree == nil {
+				break
+			}
+			if _, ok := p.approvalRule(id, p.tree.isDescendant); !ok {
+				delete(p.Pinned, id)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if p.tree != nil {
+		p.tree.dropUnprotectedProposals()
+		return p.tree.releaseValues(served, p.identity)
+	}
+	return nil
+}
+
+func (p *ABACPolicy) updateApprovals(opName string, update func()) error {
+	clientID := ClientID(p.identity.ID())
+
+	newClock := copyClock(p.Clock)
+
+	maxVersion := 0
+	for _, v := range newClock {
+		if v > maxVersion {
+			maxVersion = v
+		}
+	}
+	newClock[clientID] = maxVersion + 1
+
+	winningClock, winningOwner := resolveConflict(p.Clock, newClock, ClientID(p.OwnerID), clientID, false)
+
+	if clocksEqual(winningClock, newClock) && winningOwner == clientID {
+		p.Clock = newClock
+		update()
+
+		err := p.Sign()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"OwnerID": p.OwnerID,
+				"Error":   err,
+			}).Error("Failed to sign ABACPolicy after " + opName)
+			return fmt.Errorf("Failed to sign ABACPolicy after %s: %w", opName, err)
+		}
+	} else {
+		log.WithFields(log.Fields{
+			"OwnerID":      p.OwnerID,
+			"ClientID":     clientID,
+			"WinningOwner": winningOwner,
+			"WinningClock": winningClock,
+			"NewClock":     newClock,
+		}).Debug("ABACPolicy " + opName + " ignored due to conflict resolution")
+	}
+
+	return nil
+}
+
+// approvalRule returns the approval rule protecting the target. If several rules apply, the strictest one is used.
+func (p *ABACPolicy) approvalRule(target NodeID, isDescendant func(root NodeID, target NodeID) bool) (ABACRule, bool) {
+	var found ABACRule
+	var foundNodeID NodeID
+	ok := false
+	for nodeID, rule := range p.Approvals {
+		if nodeID != target && !(rule.Recursive && isDescendant(nodeID, target)) {
+			continue
+		}
+		if !ok || rule.Threshold > found.Threshold || (rule.Threshold == found.Threshold && nodeID < foundNodeID) {
+			found = rule
+			foundNodeID = nodeID
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+func (p *ABACPolicy) RequiresApproval(target NodeID) bool {
+	if p.tree == nil {
+		panic("ABACPolicy.tree is not set")
+	}
+	_, ok := p.approvalRule(target, p.tree.isDescendant)
+	return ok
+}
+
 func (p *ABACPolicy) IsAllowed(id string, action ABACAction, target NodeID) bool {
 	if p.tree == nil {
 		panic("ABACPolicy.tree is not set")
@@ -180,24 +350,64 @@ func (p *ABACPolicy) IsAllowed(id string, action ABACAction, target NodeID) bool
 		return false
 	}
 
+	resolvedID, err := crypto.ResolveID(id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"ID":    id,
+			"Error": err,
+		}).Error("Failed to resolve ID")
+		return false
+	}
+
+	// A rotated key is replaced by its new key and may not make any further changes
+	if chain := p.tree.keyChain(resolvedID); chain[len(chain)-1] != resolvedID {
+		log.WithFields(log.Fields{
+			"ID":    resolvedID,
+			"NewID": chain[len(chain)-1],
+		}).Error("Key has been rotated")
+		return false
+	}
+
+	return p.isAllowed(resolvedID, action, target, p.tree.isDescendant)
+}
+
+// isAllowed evaluates the rules without verifying the policy signature, callers must verify the policy first
+func (p *ABACPolicy) isAllowed(id string, action ABACAction, target NodeID, isDescendant func(root NodeID, target NodeID) bool) bool {
 	clients := []string{id, "*"}
+	var masters []string
+	if p.tree != nil {
+		clients = append(p.tree.keyChain(id), "*") // Rules granted to any key of a rotated identity apply
+		if master := p.tree.deviceMaster(id); master != "" {
+			masters = p.tree.keyChain(master)
+		}
+	}
 	for _, c := range clients {
-		if actions, ok := p.Rules[c]; ok {
-			// Check exact action
-			if rules, ok := actions[action]; ok {
-				for nodeID, rule := range rules {
-					if nodeID == "*" || nodeID == target || (rule.Recursive && p.tree.isDescendant(nodeID, target)) {
-						return true
-					}
-				}
+		if p.matchRules(c, action, target, false, isDescendant) {
+			return true
+		}
+	}
+	// Rules granted to the master of a linked device with devices enabled
+	for _, c := range masters {
+		if p.matchRules(c, action, target, true, isDescendant) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ABACPolicy) matchRules(clientID string, action ABACAction, target NodeID, devicesOnly bool, isDescendant func(root NodeID, target NodeID) bool) bool {
+	actions, ok := p.Rules[clientID]
+	if !ok {
+		return false
+	}
+	// Check exact action, then wildcard action
+	for _, a := range []ABACAction{action, "*"} {
+		for nodeID, rule := range actions[a] {
+			if devicesOnly && !rule.Devices {
+				continue
 			}
-			// Check wildcard action
-			if rules, ok := actions["*"]; ok {
-				for nodeID, rule := range rules {
-					if nodeID == "*" || nodeID == target || (rule.Recursive && p.tree.isDescendant(nodeID, target)) {
-						return true
-					}
-				}
+			if nodeID == "*" || nodeID == target || (rule.Recursive && isDescendant(nodeID, target)) {
+				return true
 			}
 		}
 	}
@@ -205,6 +415,11 @@ func (p *ABACPolicy) IsAllowed(id string, action ABACAction, target NodeID) bool
 }
 
 func (p *ABACPolicy) Merge(remote *ABACPolicy) error {
+	// Equal clocks have the same history, a policy pruned by ExtractSubtree must not replace the full policy
+	if compareClocks(p.Clock, remote.Clock) == ClockEqual {
+		return nil
+	}
+
 	// Step 1: Resolve policy-level conflict (LWW on full policy)
 	winningClock, winningOwner := resolveConflict(
 		p.Clock,
@@ -218,6 +433,8 @@ func (p *ABACPolicy) Merge(remote *ABACPolicy) error {
 		// Remote wins → replace entire ABACPolicy
 		p.Clock = copyClock(remote.Clock)
 		p.Rules = deepCopyRules(remote.Rules)
+		p.Approvals = deepCopyApprovals(remote.Approvals)
+		p.Pinned = copyPinned(remote.Pinned)
 		p.OwnerID = remote.OwnerID
 		p.Nounce = remote.Nounce
 		p.Signature = remote.Signature
@@ -255,6 +472,29 @@ func deepCopyRules(rules map[string]map[ABACAction]map[NodeID]ABACRule) map[stri
 	return newRules
 }
 
+func deepCopyApprovals(approvals map[NodeID]ABACRule) map[NodeID]ABACRule {
+	if approvals == nil {
+		return nil
+	}
+	newApprovals := make(map[NodeID]ABACRule)
+	for nodeID, rule := range approvals {
+		rule.Approvers = append([]string{}, rule.Approvers...)
+		newApprovals[nodeID] = rule
+	}
+	return newApprovals
+}
+
+func copyPinned(pinned map[NodeID]interface{}) map[NodeID]interface{} {
+	if pinned == nil {
+		return nil
+	}
+	newPinned := make(map[NodeID]interface{}, len(pinned))
+	for nodeID, value := range pinned {
+		newPinned[nodeID] = value
+	}
+	return newPinned
+}
+
 func (p *ABACPolicy) MarshalJSON() ([]byte, error) {
 	type Alias ABACPolicy // create an alias to avoid recursion
 	return json.Marshal(&struct {
@@ -282,6 +522,7 @@ func (p *ABACPolicy) ComputeDigest() (*crypto.Hash, error) {
 		Action    string
 		NodeID    string
 		Recursive bool
+		Devices   bool `json:",omitempty"` // Omitted when false, so older policies keep their signatures
 	}, 0)
 
 	// Sort client IDs
@@ -319,26 +560,67 @@ func (p *ABACPolicy) ComputeDigest() (*crypto.Hash, error) {
 					Action    string
 					NodeID    string
 					Recursive bool
+					Devices   bool `json:",omitempty"`
 				}{
 					ClientID:  clientID,
 					Action:    actionStr,
 					NodeID:    nodeID,
 					Recursive: rule.Recursive,
+					Devices:   rule.Devices,
 				})
 			}
 		}
 	}
 
+	// Approval rules are only part of the digest if there are any, so older policies keep their signatures
+	orderedApprovals := make([]struct {
+		NodeID    string
+		Recursive bool
+		Threshold int
+		Approvers []string
+	}, 0)
+
+	approvalNodeIDs := make([]string, 0, len(p.Approvals))
+	for nodeID := range p.Approvals {
+		approvalNodeIDs = append(approvalNodeIDs, string(nodeID))
+	}
+	sort.Strings(approvalNodeIDs)
+
+	for _, nodeID := range approvalNodeIDs {
+		rule := p.Approvals[NodeID(nodeID)]
+		approvers := append([]string{}, rule.Approvers...)
+		sort.Strings(approvers)
+		orderedApprovals = append(orderedApprovals, struct {
+			NodeID    string
+			Recursive bool
+			Threshold int
+			Approvers []string
+		}{
+			NodeID:    nodeID,
+			Recursive: rule.Recursive,
+			Threshold: rule.Threshold,
+			Approvers: approvers,
+		})
+	}
+
 	// Now build the full digest input struct
 	digestInput := struct {
-		Rules   interface{} `json:"rules"`
-		OwnerID string      `json:"ownerID"`
-		Nounce  string      `json:"nounce"`
+		Rules     interface{} `json:"rules"`
+		Approvals interface{} `json:"approvals,omitempty"`
+		Pinned    interface{} `json:"pinned,omitempty"`
+		OwnerID   string      `json:"ownerID"`
+		Nounce    string      `json:"nounce"`
 	}{
 		Rules:   orderedRules,
 		OwnerID: p.OwnerID,
 		Nounce:  p.Nounce,
 	}
+	if len(orderedApprovals) > 0 {
+		digestInput.Approvals = orderedApprovals
+	}
+	if len(p.Pinned) > 0 {
+		digestInput.Pinned = p.Pinned // Marshalled with sorted keys
+	}
 
 	// Marshal the entire digest input
 	buf, err := json.Marshal(digestInput)
@@ -356,7 +638,7 @@ func (p *ABACPolicy) PrintPolicy() {
 	fmt.Println("ABAC Policy:")
 	fmt.Println("============")
 
-	if p.Rules == nil || len(p.Rules) == 0 {
+	if (p.Rules == nil || len(p.Rules) == 0) && len(p.Approvals) == 0 {
 		fmt.Println("(empty)")
 		return
 	}
@@ -393,11 +675,25 @@ func (p *ABACPolicy) PrintPolicy() {
 
 			for _, nodeID := range nodeIDs {
 				rule := rules[NodeID(nodeID)]
-				fmt.Printf("    Node: %s (Recursive: %v)\n", nodeID, rule.Recursive)
+				fmt.Printf("    Node: %s (Recursive: %v, Devices: %v)\n", nodeID, rule.Recursive, rule.Devices)
 			}
 		}
 	}
 
+	approvalNodeIDs := make([]string, 0, len(p.Approvals))
+	for nodeID := range p.Approvals {
+		approvalNodeIDs = append(approvalNodeIDs, string(nodeID))
+	}
+	sort.Strings(approvalNodeIDs)
+
+	for _, nodeID := range approvalNodeIDs {
+		rule := p.Approvals[NodeID(nodeID)]
+		fmt.Printf("Approval: %s (Recursive: %v, Threshold: %d of %d)\n", nodeID, rule.Recursive, rule.Threshold, len(rule.Approvers))
+		for _, approver := range rule.Approvers {
+			fmt.Printf("  Approver: %s\n", approver)
+		}
+	}
+
 	fmt.Println()
 }
 
@@ -482,7 +778,7 @@ func (p *ABACPolicy) Verify() (string, error) {
 		return "", fmt.Errorf("Failed to recover ID from signature: %w", err)
 	}
 
-	if recoveredID != p.OwnerID {
+	if recoveredID != p.OwnerID && (p.tree == nil || !p.tree.policySignerAllowed(p.OwnerID, recoveredID, p.Signature)) {
 		log.WithFields(log.Fields{
 			"OwnerID":     p.OwnerID,
 			"RecoveredID": recoveredID,
@@ -13,6 +13,28 @@ func (m *mockTree) isDescendant(root NodeID, target NodeID) bool {
 	return root == "parent" && target == "child"
 }
 
+func (m *mockTree) keyChain(id string) []string {
+	return []string{id}
+}
+
+func (m *mockTree) dropUnprotectedProposals() {}
+
+func (m *mockTree) protectedValues() map[NodeID]interface{} {
+	return nil
+}
+
+func (m *mockTree) releaseValues(values map[NodeID]interface{}, identity *crypto.Idendity) error {
+	return nil
+}
+
+func (m *mockTree) policySignerAllowed(ownerID string, signerID string, signature string) bool {
+	return ownerID == signerID
+}
+
+func (m *mockTree) deviceMaster(id string) string {
+	return ""
+}
+
 func TestABACPolicyWithModifyOnly(t *testing.T) {
 	tree := &mockTree{}
 
@@ -98,3 +120,34 @@ func TestABACPolicyUpdateAndRemove(t *testing.T) {
 		t.Errorf("Expected not allowed after rule is removed")
 	}
 }
+
+func TestABACPolicyWithDID(t *testing.T) {
+	tree := &mockTree{}
+
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	identity1, err := crypto.CreateIdendityFromString(prvKey1)
+	assert.NoError(t, err)
+
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.NoError(t, err)
+
+	policy := NewABACPolicy(tree, identity1.ID(), identity1)
+
+	err = policy.Allow(identity2.DID(), ActionModify, "parent", true)
+	assert.NoError(t, err)
+
+	_, ok := policy.Rules[identity2.ID()]
+	assert.True(t, ok, "Rules should be stored under the ID of the DID")
+
+	assert.True(t, policy.IsAllowed(identity2.ID(), ActionModify, "child"))
+	assert.True(t, policy.IsAllowed(identity2.DID(), ActionModify, "child"))
+	assert.False(t, policy.IsAllowed("did:key:zInvalid", ActionModify, "child"))
+
+	err = policy.Allow("did:web:example.com", ActionModify, "parent", true)
+	assert.Error(t, err, "Unsupported DID methods should be rejected")
+
+	err = policy.RemoveRule(identity2.DID(), ActionModify, "parent")
+	assert.NoError(t, err)
+	assert.False(t, policy.IsAllowed(identity2.ID(), ActionModify, "child"))
+}
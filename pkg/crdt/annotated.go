@@ -0,0 +1,137 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/iancoleman/orderedmap"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportAnnotatedJSON exports the document with the provenance of every value, for audits. Each value,
+// including maps and arrays, is wrapped as {value, owner, clock, signature, nodeID, verified} in the same
+// shape as ExportJSON. Verified is true if the signature is valid and the signer is allowed to modify the
+// node by the ABAC policy.
+func (c *TreeCRDT) ExportAnnotatedJSON() ([]byte, error) {
+	if len(c.Root.Edges) == 0 {
+		return nil, fmt.Errorf("Root node has no edges")
+	}
+
+	a := &annotator{tree: c, visited: make(map[NodeID]bool)}
+	if c.ABACPolicy != nil {
+		if _, err := c.ABACPolicy.Verify(); err == nil {
+			a.policy = c.ABACPolicy
+			parents := c.parentMap()
+			a.isDescendant = func(root NodeID, target NodeID) bool {
+				return isDescendantInParentMap(parents, root, target)
+			}
+		} else {
+			log.WithFields(log.Fields{"Error": err}).Warning("ABAC policy is invalid, no values will be marked as verified")
+		}
+	}
+
+	var exported interface{}
+	var err error
+	if len(c.Root.Edges) == 1 {
+		exported, err = a.node(c.Root.Edges[0].To)
+	} else {
+		exported, err = a.annotate(c.Root) // Several root edges are exported as the root value
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(exported, "", "  ")
+}
+
+type annotator struct {
+	tree         *TreeCRDT
+	policy       *ABACPolicy
+	isDescendant func(root NodeID, target NodeID) bool
+	visited      map[NodeID]bool
+}
+
+func (a *annotator) node(id NodeID) (interface{}, error) {
+	if a.visited[id] {
+		return nil, fmt.Errorf("cycle detected at node %s", id)
+	}
+	a.visited[id] = true
+
+	node, ok := a.tree.Nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %s not found", id)
+	}
+	return a.annotate(node)
+}
+
+func (a *annotator) annotate(node *NodeCRDT) (*orderedmap.OrderedMap, error) {
+	var value interface{}
+	switch {
+	case node.IsLiteral:
+		value = node.effectiveValue()
+	case node.IsArray || (node.IsRoot && a.rootIsArray()):
+		sortEdgesByLSEQ(node.Edges)
+		items := make([]interface{}, 0)
+		for _, edge := range node.Edges {
+			if child := a.tree.Nodes[edge.To]; child == nil || child.IsDeleted {
+				continue
+			}
+			item, err := a.node(edge.To)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		value = items
+	case node.IsMap || node.IsRoot:
+		members := orderedmap.New()
+		for _, edge := range node.Edges {
+			if child := a.tree.Nodes[edge.To]; child == nil || child.IsDeleted {
+				continue
+			}
+			member, err := a.node(edge.To)
+			if err != nil {
+				return nil, err
+			}
+			members.Set(edge.Label, member)
+		}
+		value = members
+	default:
+		return nil, fmt.Errorf("node %s is neither literal, array, nor map", node.ID)
+	}
+
+	clock := node.Clock
+	if clock == nil {
+		clock = make(VectorClock)
+	}
+
+	result := orderedmap.New()
+	result.Set("value", value)
+	result.Set("owner", node.Owner)
+	result.Set("clock", clock)
+	result.Set("signature", node.Signature)
+	result.Set("nodeID", node.ID)
+	result.Set("verified", a.verified(node))
+	return result, nil
+}
+
+// rootIsArray reports if the root edges point to array items, like export does
+func (a *annotator) rootIsArray() bool {
+	for _, e := range a.tree.Root.Edges {
+		if node := a.tree.Nodes[e.To]; node != nil && node.IsArray {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *annotator) verified(node *NodeCRDT) bool {
+	if node.Signature == "" || a.policy == nil {
+		return false
+	}
+	recoveredID, err := node.verifyCached(a.tree.sigCache)
+	if err != nil {
+		return false
+	}
+	return a.policy.isAllowed(recoveredID, ActionModify, node.ID, a.isDescendant)
+}
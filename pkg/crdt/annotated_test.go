@@ -0,0 +1,74 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportAnnotatedJSON(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"name": "sensor", "readings": [1, 2]}`), prvKey)
+	assert.Nil(t, err)
+
+	nameNode, err := c.GetNodeByPath("/name")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportAnnotatedJSON()
+	assert.Nil(t, err)
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(exported, &doc))
+	assert.Equal(t, identity.ID(), doc["owner"])
+	assert.Equal(t, true, doc["verified"])
+
+	members := doc["value"].(map[string]interface{})
+	name := members["name"].(map[string]interface{})
+	assert.Equal(t, "sensor", name["value"])
+	assert.Equal(t, string(nameNode.ID()), name["nodeID"])
+	assert.Equal(t, true, name["verified"])
+	assert.NotEmpty(t, name["signature"])
+	assert.Contains(t, name["clock"], identity.ID())
+
+	readings := members["readings"].(map[string]interface{})["value"].([]interface{})
+	assert.Len(t, readings, 2)
+	assert.Equal(t, 2.0, readings[1].(map[string]interface{})["value"])
+
+	// Values changed without a new signature are not verified
+	tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+	tree.Nodes[nameNode.ID()].LiteralValue = "tampered"
+	tree.Nodes[nameNode.ID()].invalidateHash()
+
+	exported, err = c.ExportAnnotatedJSON()
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(exported, &doc))
+	name = doc["value"].(map[string]interface{})["name"].(map[string]interface{})
+	assert.Equal(t, "tampered", name["value"])
+	assert.Equal(t, false, name["verified"])
+}
+
+func TestExportAnnotatedJSONUnsigned(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`["a", "b"]`), "client1")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportAnnotatedJSON()
+	assert.Nil(t, err)
+
+	var doc map[string]interface{}
+	assert.Nil(t, json.Unmarshal(exported, &doc))
+	items := doc["value"].([]interface{})
+	assert.Len(t, items, 2)
+	first := items[0].(map[string]interface{})
+	assert.Equal(t, "a", first["value"])
+	assert.Equal(t, "client1", first["owner"])
+	assert.Equal(t, "", first["signature"])
+	assert.Equal(t, false, first["verified"])
+}
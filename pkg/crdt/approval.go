@@ -0,0 +1,429 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/eislab-cps/synctree/pkg/random"
+	log "github.com/sirupsen/logrus"
+)
+
+// Literals protected by an approval rule are not changed directly. A change is proposed and stored as
+// pending on the node together with the signatures of the approvers. Approvals from other replicas are
+// merged in as a grow-only set, and once the threshold of the rule is reached the pending change becomes
+// the approved change, whose value is then returned by GetValueByPath and ExportJSON. The values of the
+// literals are pinned in the signed policy when the rule is attached, so a direct write by a peer is
+// neither served nor accepted by VerifyTree.
+
+type ProposedChange struct {
+	ID        string              `json:"id"`
+	Sequence  int                 `json:"sequence"`
+	Value     interface{}         `json:"value"`
+	Proposer  ClientID            `json:"proposer"`
+	Approvals map[ClientID]string `json:"approvals"`
+}
+
+func (pc *ProposedChange) digest(nodeID NodeID) (*crypto.Hash, error) {
+	value, err := json.Marshal(pc.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal proposed value: %w", err)
+	}
+	return crypto.GenerateHashFromString(fmt.Sprintf("approval:%s:%s:%d:%s", nodeID, pc.ID, pc.Sequence, value)), nil
+}
+
+func (pc *ProposedChange) approve(nodeID NodeID, identity *crypto.Idendity) error {
+	digest, err := pc.digest(nodeID)
+	if err != nil {
+		return err
+	}
+
+	signature, err := crypto.Sign(digest, identity.PrivateKey())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"NodeID": nodeID,
+			"Error":  err,
+		}).Error("Failed to sign proposed change")
+		return fmt.Errorf("Failed to sign proposed change: %w", err)
+	}
+
+	pc.Approvals[ClientID(identity.ID())] = hex.EncodeToString(signature)
+
+	return nil
+}
+
+// countApprovals verifies all approval signatures and returns how many there are, every approval must come
+// from one of the approvers of the rule
+func (pc *ProposedChange) countApprovals(nodeID NodeID, rule ABACRule) (int, error) {
+	digest, err := pc.digest(nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	approvers := make(map[string]bool, len(rule.Approvers))
+	for _, approver := range rule.Approvers {
+		approvers[approver] = true
+	}
+
+	count := 0
+	for approver, signature := range pc.Approvals {
+		if !approvers[string(approver)] {
+			return 0, fmt.Errorf("Proposed change %s on node %s is approved by %s who is not an approver", pc.ID, nodeID, approver)
+		}
+
+		signatureBytes, err := hex.DecodeString(signature)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to decode approval signature of %s: %w", approver, err)
+		}
+
+		recoveredID, err := crypto.RecoveredID(digest, signatureBytes)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to recover ID from approval signature of %s: %w", approver, err)
+		}
+		if recoveredID != string(approver) {
+			return 0, fmt.Errorf("Recovered ID %s does not match approver %s of proposed change %s on node %s", recoveredID, approver, pc.ID, nodeID)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func (pc *ProposedChange) clone() *ProposedChange {
+	cloned := *pc
+	cloned.Approvals = make(map[ClientID]string, len(pc.Approvals))
+	for approver, signature := range pc.Approvals {
+		cloned.Approvals[approver] = signature
+	}
+	return &cloned
+}
+
+// mergeProposedChanges merges two versions of a proposal. Approvals of the same proposal are unioned,
+// otherwise the proposal with the highest sequence wins, then the one with the most approvals, then the highest ID.
+func mergeProposedChanges(local, remote *ProposedChange) *ProposedChange {
+	if remote == nil {
+		return local
+	}
+	if local == nil {
+		return remote.clone()
+	}
+
+	if local.ID == remote.ID {
+		merged := local.clone()
+		for approver, signature := range remote.Approvals {
+			if _, ok := merged.Approvals[approver]; !ok {
+				merged.Approvals[approver] = signature
+			}
+		}
+		return merged
+	}
+
+	if remote.Sequence != local.Sequence {
+		if remote.Sequence > local.Sequence {
+			return remote.clone()
+		}
+		return local
+	}
+	if len(remote.Approvals) != len(local.Approvals) {
+		if len(remote.Approvals) > len(local.Approvals) {
+			return remote.clone()
+		}
+		return local
+	}
+	if remote.ID > local.ID {
+		return remote.clone()
+	}
+	return local
+}
+
+// effectiveValue returns the approved value of a protected literal, or the value pinned when its rule was
+// attached, otherwise the literal value. Proposed changes on nodes that are not protected are never used.
+func (n *NodeCRDT) effectiveValue() interface{} {
+	if n.tree == nil || !n.tree.isProtected(n.ID) {
+		return n.LiteralValue
+	}
+	if n.Approved != nil {
+		return n.Approved.Value
+	}
+	if value, ok := n.tree.ABACPolicy.Pinned[n.ID]; ok {
+		return value
+	}
+	return n.LiteralValue
+}
+
+// isProtected returns true if the node is covered by an approval rule
+func (c *TreeCRDT) isProtected(id NodeID) bool {
+	if c.ABACPolicy == nil {
+		return false
+	}
+	_, ok := c.ABACPolicy.approvalRule(id, c.isDescendant)
+	return ok
+}
+
+// protectedSubtree returns a node at or below id that is covered by an approval rule, replacing or removing
+// id would change it without approval
+func (c *TreeCRDT) protectedSubtree(id NodeID) (NodeID, bool) {
+	if c.ABACPolicy == nil {
+		return "", false
+	}
+	if c.isProtected(id) {
+		return id, true
+	}
+	for ruleNodeID := range c.ABACPolicy.Approvals {
+		if c.isDescendant(id, ruleNodeID) {
+			return ruleNodeID, true
+		}
+	}
+	return "", false
+}
+
+// protectedValues returns the served values of the literals covered by an approval rule
+func (c *TreeCRDT) protectedValues() map[NodeID]interface{} {
+	values := make(map[NodeID]interface{})
+	if c.ABACPolicy == nil || len(c.ABACPolicy.Approvals) == 0 {
+		return values
+	}
+	for id, node := range c.Nodes {
+		if node.IsLiteral && !node.IsDeleted && c.isProtected(id) {
+			values[id] = node.effectiveValue()
+		}
+	}
+	return values
+}
+
+// releaseValues writes the served values of literals that are no longer protected, so removing an approval
+// rule does not revert them to the value they had before their approved changes
+func (c *TreeCRDT) releaseValues(values map[NodeID]interface{}, identity *crypto.Idendity) error {
+	clientID := ClientID(identity.ID())
+	for id, value := range values {
+		node, ok := c.Nodes[id]
+		if !ok || c.isProtected(id) || sameJSON(node.LiteralValue, value) {
+			continue
+		}
+		if err := node.SetLiteral(value, clientID); err != nil {
+			return fmt.Errorf("Failed to keep the approved value of %s: %w", id, err)
+		}
+		if err := node.Sign(identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameJSON(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	return err == nil && bytes.Equal(x, y)
+}
+
+// mergeProposals merges the proposed changes of a remote node, they are ignored if the node is not protected
+func (c *TreeCRDT) mergeProposals(local *NodeCRDT, remote *NodeCRDT) {
+	if local == nil || remote == nil {
+		return
+	}
+	if !c.isProtected(local.ID) {
+		log.WithFields(log.Fields{"NodeID": local.ID}).Warning("Ignored proposed changes on a node without approval rule")
+		return
+	}
+	local.Approved = mergeProposedChanges(local.Approved, remote.Approved)
+	local.Pending = mergeProposedChanges(local.Pending, remote.Pending)
+	local.invalidateHash()
+	c.promoteApproved(local)
+}
+
+// dropUnprotectedProposals removes the proposed changes of nodes no longer covered by an approval rule
+func (c *TreeCRDT) dropUnprotectedProposals() {
+	for _, node := range c.Nodes {
+		if (node.Approved != nil || node.Pending != nil) && !c.isProtected(node.ID) {
+			node.Approved = nil
+			node.Pending = nil
+			node.invalidateHash()
+		}
+	}
+}
+
+func (c *TreeCRDT) protectedLiteral(nodeID NodeID, identity *crypto.Idendity) (*NodeCRDT, error) {
+	node, ok := c.Nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("Node %s not found", nodeID)
+	}
+	if !node.IsLiteral {
+		return nil, fmt.Errorf("Node %s is not a literal", nodeID)
+	}
+	if c.ABACPolicy == nil {
+		return nil, fmt.Errorf("Node %s is not protected, ABACPolicy is not set", nodeID)
+	}
+
+	rule, ok := c.ABACPolicy.approvalRule(nodeID, c.isDescendant)
+	if !ok {
+		return nil, fmt.Errorf("Node %s is not protected by an approval rule", nodeID)
+	}
+
+	for _, approver := range rule.Approvers {
+		if approver == identity.ID() {
+			return node, nil
+		}
+	}
+
+	return nil, fmt.Errorf("identity %s is not an approver of node %s", identity.ID(), nodeID)
+}
+
+func (c *TreeCRDT) ProposeLiteral(nodeID NodeID, value interface{}, identity *crypto.Idendity) error {
+	node, err := c.protectedLiteral(nodeID, identity)
+	if err != nil {
+		return err
+	}
+
+	sequence := 0
+	if node.Approved != nil && node.Approved.Sequence > sequence {
+		sequence = node.Approved.Sequence
+	}
+	if node.Pending != nil && node.Pending.Sequence > sequence {
+		sequence = node.Pending.Sequence
+	}
+
+	change := &ProposedChange{
+		ID:        random.GenerateRandomID(),
+		Sequence:  sequence + 1,
+		Value:     normalizeNumber(value),
+		Proposer:  ClientID(identity.ID()),
+		Approvals: make(map[ClientID]string),
+	}
+	if err := change.approve(nodeID, identity); err != nil {
+		return err
+	}
+
+	node.Pending = change
+	node.invalidateHash()
+	c.promoteApproved(node)
+
+	log.WithFields(log.Fields{
+		"NodeID":   nodeID,
+		"ChangeID": change.ID,
+		"Sequence": change.Sequence,
+		"Proposer": change.Proposer,
+	}).Debug("Proposed literal change")
+
+	return nil
+}
+
+func (c *TreeCRDT) ApproveLiteral(nodeID NodeID, identity *crypto.Idendity) error {
+	node, err := c.protectedLiteral(nodeID, identity)
+	if err != nil {
+		return err
+	}
+	if node.Pending == nil {
+		return fmt.Errorf("Node %s has no pending change to approve", nodeID)
+	}
+
+	if err := node.Pending.approve(nodeID, identity); err != nil {
+		return err
+	}
+	node.invalidateHash()
+	c.promoteApproved(node)
+
+	return nil
+}
+
+// promoteApproved makes the pending change the approved change once it has enough valid approvals
+func (c *TreeCRDT) promoteApproved(node *NodeCRDT) {
+	if node.Pending == nil || c.ABACPolicy == nil {
+		return
+	}
+
+	if node.Approved != nil && node.Pending.Sequence <= node.Approved.Sequence {
+		node.Pending = nil
+		node.invalidateHash()
+		return
+	}
+
+	rule, ok := c.ABACPolicy.approvalRule(node.ID, c.isDescendant)
+	if !ok {
+		return
+	}
+
+	count, err := node.Pending.countApprovals(node.ID, rule)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"NodeID":   node.ID,
+			"ChangeID": node.Pending.ID,
+			"Error":    err,
+		}).Warning("Pending change has invalid approvals")
+		return
+	}
+	if count < rule.Threshold {
+		return
+	}
+
+	oldValue := node.effectiveValue()
+	node.Approved = node.Pending
+	node.Pending = nil
+	node.invalidateHash()
+
+	log.WithFields(log.Fields{
+		"NodeID":    node.ID,
+		"ChangeID":  node.Approved.ID,
+		"Approvals": count,
+		"Threshold": rule.Threshold,
+	}).Debug("Pending change approved")
+
+	if node.ParentID != "" {
+		c.notify(NodeEvent{NodeID: node.ID, Type: EventUpdated, OldValue: oldValue, NewValue: node.Approved.Value, Owner: node.Approved.Proposer})
+	}
+}
+
+// verifyProposedChanges checks the approval signatures of a node, that an approved change reached the threshold,
+// and that a protected literal still has the value pinned when its rule was attached
+func (c *TreeCRDT) verifyProposedChanges(node *NodeCRDT, isDescendant func(root NodeID, target NodeID) bool) error {
+	if node.IsLiteral && c.ABACPolicy != nil {
+		if _, protected := c.ABACPolicy.approvalRule(node.ID, isDescendant); protected {
+			pinned, ok := c.ABACPolicy.Pinned[node.ID]
+			switch {
+			case !ok:
+				return fmt.Errorf("Protected literal %s was added without approval", node.ID)
+			case node.IsDeleted:
+				return fmt.Errorf("Protected literal %s was deleted without approval", node.ID)
+			case !sameJSON(node.LiteralValue, pinned):
+				return fmt.Errorf("Protected literal %s was changed without approval", node.ID)
+			}
+		}
+	}
+	if node.Approved == nil && node.Pending == nil {
+		return nil
+	}
+	if !node.IsLiteral {
+		return fmt.Errorf("Node %s has proposed changes but is not a literal", node.ID)
+	}
+
+	if c.ABACPolicy == nil {
+		return fmt.Errorf("Node %s has proposed changes but the tree has no ABAC policy", node.ID)
+	}
+	rule, protected := c.ABACPolicy.approvalRule(node.ID, isDescendant)
+	if !protected {
+		return fmt.Errorf("Node %s has proposed changes but is not protected by an approval rule", node.ID)
+	}
+
+	if node.Approved != nil {
+		count, err := node.Approved.countApprovals(node.ID, rule)
+		if err != nil {
+			return err
+		}
+		if count < rule.Threshold {
+			return fmt.Errorf("Approved change %s on node %s has %d of %d required approvals", node.Approved.ID, node.ID, count, rule.Threshold)
+		}
+	}
+
+	if node.Pending != nil {
+		if _, err := node.Pending.countApprovals(node.ID, rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeApproval(t *testing.T) {
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	prvKey3 := "4d2c8a3a3e08e4a0f5a1b3d0f8fd8e7c0d8a5e2a9c6b1e4f7a0d3c6b9e2f5a81"
+	prvKey4 := "0b7ce0a8f4b0e1c6d1a7e9b2c3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2"
+
+	identity1, err := crypto.CreateIdendityFromString(prvKey1)
+	assert.Nil(t, err)
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+	identity3, err := crypto.CreateIdendityFromString(prvKey3)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(prvKey1)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"config": {"limit": 10}, "name": "test"}`), prvKey1)
+	assert.Nil(t, err)
+
+	configNode, err := c1.GetNodeByPath("/config")
+	assert.Nil(t, err)
+	approvers := []string{identity1.ID(), identity2.ID(), identity3.ID()}
+	err = c1.ABAC().RequireApproval(configNode.ID(), approvers, 2, true)
+	assert.Nil(t, err, "RequireApproval should not return an error")
+	for _, approver := range approvers[1:] {
+		err = c1.ABAC().Allow(approver, ActionModify, configNode.ID(), true)
+		assert.Nil(t, err)
+	}
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	t.Run("Reject direct change of protected literal", func(t *testing.T) {
+		limitNode, err := c1.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		err = limitNode.SetLiteral(20, prvKey1)
+		assert.NotNil(t, err, "SetLiteral on a protected literal should return an error")
+
+		value, err := c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(10), value)
+	})
+
+	t.Run("Pending change is not visible until threshold is reached", func(t *testing.T) {
+		limitNode, err := c1.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		err = limitNode.ProposeLiteral(20, prvKey1)
+		assert.Nil(t, err, "ProposeLiteral should not return an error")
+
+		value, err := c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(10), value, "A pending change should not be visible")
+
+		err = c1.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should accept a pending change")
+	})
+
+	t.Run("Co-signature from another replica", func(t *testing.T) {
+		err := c2.Merge(c1, prvKey2)
+		assert.Nil(t, err, "Merge should not return an error")
+
+		limitNode, err := c2.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		err = limitNode.ApproveLiteral(prvKey2)
+		assert.Nil(t, err, "ApproveLiteral should not return an error")
+
+		value, err := c2.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(20), value, "The change should be visible once approved")
+
+		value, err = c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(10), value, "The change should not be visible before the approval is merged")
+
+		err = c1.Merge(c2, prvKey1)
+		assert.Nil(t, err, "Merge should not return an error")
+
+		value, err = c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(20), value, "The change should be visible after the approval is merged")
+
+		json, err := c1.ExportJSON()
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"config": {"limit": 20}, "name": "test"}`, string(json))
+
+		err = c1.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should accept an approved change")
+	})
+
+	t.Run("Non-approver cannot approve", func(t *testing.T) {
+		limitNode, err := c1.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		err = limitNode.ProposeLiteral(30, prvKey1)
+		assert.Nil(t, err)
+
+		err = limitNode.ApproveLiteral(prvKey4)
+		assert.NotNil(t, err, "ApproveLiteral from a non-approver should return an error")
+	})
+
+	t.Run("Save and Load keep proposed changes", func(t *testing.T) {
+		data, err := c1.Save()
+		assert.Nil(t, err)
+
+		c3, err := NewSecureTree(prvKey1)
+		assert.Nil(t, err)
+		err = c3.Load(data)
+		assert.Nil(t, err)
+
+		value, err := c3.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(20), value)
+
+		err = c3.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should succeed after Load")
+	})
+
+	tree := c1.(*AdapterSecureTreeCRDT).treeCrdt
+	limitNode, err := tree.GetNodeByPath("/config/limit")
+	assert.Nil(t, err)
+
+	t.Run("Detect approved change below threshold", func(t *testing.T) {
+		original := limitNode.Approved
+		limitNode.Approved = original.clone()
+		delete(limitNode.Approved.Approvals, ClientID(identity2.ID()))
+		limitNode.invalidateHash()
+
+		err := c1.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should reject an approved change without enough approvals")
+
+		limitNode.Approved = original
+		limitNode.invalidateHash()
+	})
+
+	t.Run("Detect forged approval", func(t *testing.T) {
+		original := limitNode.Approved
+		limitNode.Approved = original.clone()
+		limitNode.Approved.Value = float64(1000)
+		limitNode.invalidateHash()
+
+		err := c1.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should reject approvals that do not sign the value")
+
+		limitNode.Approved = original
+		limitNode.invalidateHash()
+		err = c1.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should succeed after restoring the approved change")
+	})
+}
+
+func TestSecureTreeApprovalHostileMerge(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	attacker, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"config": {"limit": 10}, "name": "test"}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	// The attacker attaches a self-approved change to a literal that is not protected
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	hostile := c2.(*AdapterSecureTreeCRDT).treeCrdt
+	nameNode, err := hostile.GetNodeByPath("/name")
+	assert.Nil(t, err)
+	change := &ProposedChange{ID: "evil", Sequence: 1, Value: "owned", Proposer: ClientID(attacker.ID()), Approvals: make(map[ClientID]string)}
+	assert.Nil(t, change.approve(nameNode.ID, attacker))
+	nameNode.Approved = change
+	nameNode.invalidateHash()
+
+	value, err := c2.GetValueByPath("/name")
+	assert.Nil(t, err)
+	assert.Equal(t, "test", value, "Approved changes on unprotected nodes should not be used")
+	assert.NotNil(t, c2.VerifyTree(), "VerifyTree should reject proposed changes on unprotected nodes")
+
+	assert.Nil(t, c1.Merge(c2, ownerPrvKey))
+	value, err = c1.GetValueByPath("/name")
+	assert.Nil(t, err)
+	assert.Equal(t, "test", value)
+	assert.Nil(t, c1.VerifyTree())
+	node, err := c1.(*AdapterSecureTreeCRDT).treeCrdt.GetNodeByPath("/name")
+	assert.Nil(t, err)
+	assert.Nil(t, node.Approved, "Merge should ignore proposed changes on unprotected nodes")
+
+	t.Run("Removing the rule drops the proposals and keeps the approved value", func(t *testing.T) {
+		owner, err := crypto.CreateIdendityFromString(ownerPrvKey)
+		assert.Nil(t, err)
+		configNode, err := c1.GetNodeByPath("/config")
+		assert.Nil(t, err)
+		err = c1.ABAC().RequireApproval(configNode.ID(), []string{owner.ID()}, 1, true)
+		assert.Nil(t, err)
+		limitNode, err := c1.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Nil(t, limitNode.ProposeLiteral(20, ownerPrvKey))
+		value, err := c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(20), value)
+
+		assert.Nil(t, c1.ABAC().RemoveApprovalRule(configNode.ID()))
+		assert.Nil(t, c1.VerifyTree(), "Proposals of formerly protected literals should be dropped")
+		value, err = c1.GetValueByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(20), value, "The approved value should be written to the literal")
+		node, err := c1.(*AdapterSecureTreeCRDT).treeCrdt.GetNodeByPath("/config/limit")
+		assert.Nil(t, err)
+		assert.Nil(t, node.Approved)
+	})
+}
+
+func TestSecureTreeApprovalThroughParent(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	owner, err := crypto.CreateIdendityFromString(ownerPrvKey)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"config": {"limit": 10, "name": "test"}}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	limitNode, err := c.GetNodeByPath("/config/limit")
+	assert.Nil(t, err)
+	err = c.ABAC().RequireApproval(limitNode.ID(), []string{owner.ID()}, 1, false)
+	assert.Nil(t, err)
+
+	configNode, err := c.GetNodeByPath("/config")
+	assert.Nil(t, err)
+	_, err = configNode.SetKeyValue("limit", 77, ownerPrvKey)
+	assert.NotNil(t, err, "SetKeyValue should not replace a protected literal")
+	assert.NotNil(t, configNode.RemoveKeyValue("limit", ownerPrvKey), "RemoveKeyValue should not remove a protected literal")
+
+	rootNode, err := c.GetNodeByPath("/")
+	assert.Nil(t, err)
+	assert.NotNil(t, rootNode.RemoveKeyValue("config", ownerPrvKey), "Maps containing protected literals should not be removed")
+
+	value, err := c.GetValueByPath("/config/limit")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), value)
+
+	_, err = configNode.SetKeyValue("name", "changed", ownerPrvKey)
+	assert.Nil(t, err, "Unprotected siblings can still be changed")
+	assert.Nil(t, c.VerifyTree())
+}
+
+func TestSecureTreeApprovalBypass(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	approverPrvKey := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	owner, err := crypto.CreateIdendityFromString(ownerPrvKey)
+	assert.Nil(t, err)
+	approver, err := crypto.CreateIdendityFromString(approverPrvKey)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"config": {"limit": 10}}`), ownerPrvKey)
+	assert.Nil(t, err)
+	configNode, err := c1.GetNodeByPath("/config")
+	assert.Nil(t, err)
+	assert.Nil(t, c1.ABAC().Allow(approver.ID(), ActionModify, configNode.ID(), true))
+	unprotected, err := c1.Clone()
+	assert.Nil(t, err)
+	assert.Nil(t, c1.ABAC().RequireApproval(configNode.ID(), []string{owner.ID(), approver.ID()}, 2, true))
+
+	// An approver with modify rights writes the literal directly, skipping the adapter checks
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	hostile := c2.(*AdapterSecureTreeCRDT).treeCrdt
+	limitNode, err := hostile.GetNodeByPath("/config/limit")
+	assert.Nil(t, err)
+	assert.Nil(t, limitNode.SetLiteral(999, ClientID(approver.ID())))
+	assert.Nil(t, limitNode.Sign(approver))
+
+	value, err := c2.GetValueByPath("/config/limit")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), value, "The pinned value should be served")
+	assert.NotNil(t, c2.VerifyTree(), "VerifyTree should reject a direct change of a protected literal")
+
+	assert.NotNil(t, c1.Merge(c2, ownerPrvKey), "Merge should reject a direct change of a protected literal")
+	value, err = c1.GetValueByPath("/config/limit")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(10), value)
+	assert.Nil(t, c1.VerifyTree())
+
+	t.Run("Pinned values are merged with the policy", func(t *testing.T) {
+		assert.Nil(t, unprotected.Merge(c1, ownerPrvKey))
+		tree := unprotected.(*AdapterSecureTreeCRDT).treeCrdt
+		assert.Len(t, tree.ABACPolicy.Pinned, 1)
+		assert.Nil(t, unprotected.VerifyTree())
+	})
+
+	t.Run("Literals added under a protected map", func(t *testing.T) {
+		c3, err := c1.Clone()
+		assert.Nil(t, err)
+		tree := c3.(*AdapterSecureTreeCRDT).treeCrdt
+		config, err := tree.GetNodeByPath("/config")
+		assert.Nil(t, err)
+		_, err = config.SetKeyValue("extra", 1, ClientID(approver.ID()))
+		assert.Nil(t, err)
+		assert.Nil(t, config.Sign(approver))
+		extra, err := tree.GetNodeByPath("/config/extra")
+		assert.Nil(t, err)
+		assert.Nil(t, extra.Sign(approver))
+		assert.NotNil(t, c3.VerifyTree(), "VerifyTree should reject protected literals without pinned value")
+	})
+}
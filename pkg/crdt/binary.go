@@ -0,0 +1,410 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The binary format is a magic header followed by a deterministic CBOR document. Node IDs, client IDs
+// and edge labels are stored once in a string table and referenced by index, clocks are stored as
+// index/version pairs and hex encoded signatures as raw bytes. Literal values, proposed changes and
+// the policy records are stored as compact JSON, so they decode to exactly the same values as the JSON
+// format and signatures stay verifiable. Load detects the format from the header.
+
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatBinary Format = "binary"
+)
+
+const binaryFormatVersion = 1
+
+var binaryMagic = []byte{'S', 'T', 'B'}
+
+const (
+	flagRoot = 1 << iota
+	flagDeleted
+	flagArray
+	flagPromoted
+	flagMap
+	flagLiteral
+)
+
+type binaryTree struct {
+	Strings             []string     `cbor:"1,keyasint"`
+	Root                uint32       `cbor:"2,keyasint"`
+	Secure              bool         `cbor:"3,keyasint,omitempty"`
+	Nodes               []binaryNode `cbor:"4,keyasint"`
+	ABAC                []byte       `cbor:"5,keyasint,omitempty"`
+	RecoveryCommitments []byte       `cbor:"6,keyasint,omitempty"`
+	KeyRotations        []byte       `cbor:"7,keyasint,omitempty"`
+	DeviceLinks         []byte       `cbor:"8,keyasint,omitempty"`
+	Schemas             []byte       `cbor:"9,keyasint,omitempty"`
+}
+
+type binaryNode struct {
+	ID        uint32       `cbor:"1,keyasint"`
+	Parent    uint32       `cbor:"2,keyasint,omitempty"`
+	Owner     uint32       `cbor:"3,keyasint,omitempty"`
+	Flags     uint8        `cbor:"4,keyasint,omitempty"`
+	Literal   []byte       `cbor:"5,keyasint,omitempty"`
+	Clock     []uint64     `cbor:"6,keyasint,omitempty"` // client index, version, client index, version, ...
+	Signature interface{}  `cbor:"7,keyasint,omitempty"` // raw bytes, or a string if not lowercase hex
+	Nounce    interface{}  `cbor:"8,keyasint,omitempty"`
+	Edges     []binaryEdge `cbor:"9,keyasint,omitempty"`
+	Approved  []byte       `cbor:"10,keyasint,omitempty"`
+	Pending   []byte       `cbor:"11,keyasint,omitempty"`
+}
+
+type binaryEdge struct {
+	_        struct{} `cbor:",toarray"`
+	To       uint32
+	Label    uint32
+	Position []int
+}
+
+type stringTable struct {
+	strings []string
+	index   map[string]uint32
+}
+
+func newStringTable() *stringTable {
+	t := &stringTable{index: make(map[string]uint32)}
+	t.intern("") // Index 0 is the empty string, so empty fields can be omitted
+	return t
+}
+
+func (t *stringTable) intern(s string) uint32 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint32(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.index[s] = i
+	return i
+}
+
+var binaryEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+// The size of the input is limited by MaxLoadSize, so the element limits only need to allow large trees
+var binaryDecMode, _ = cbor.DecOptions{
+	MaxArrayElements: 1 << 30,
+	MaxMapPairs:      1 << 30,
+}.DecMode()
+
+func (c *TreeCRDT) SaveAs(format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return c.Save()
+	case FormatBinary:
+		return c.saveBinary()
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+func (c *TreeCRDT) saveBinary() ([]byte, error) {
+	strings := newStringTable()
+	bt := &binaryTree{
+		Root:   strings.intern(string(c.Root.ID)),
+		Secure: c.Secure,
+		Nodes:  make([]binaryNode, 0, len(c.Nodes)),
+	}
+
+	ids := make([]string, 0, len(c.Nodes))
+	for id := range c.Nodes {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	var err error
+	for _, id := range ids {
+		node := c.Nodes[NodeID(id)]
+		bn := binaryNode{
+			ID:        strings.intern(id),
+			Parent:    strings.intern(string(node.ParentID)),
+			Owner:     strings.intern(string(node.Owner)),
+			Flags:     nodeFlags(node),
+			Signature: encodeHexString(node.Signature),
+			Nounce:    encodeHexString(node.Nounce),
+		}
+
+		if node.LiteralValue != nil {
+			if bn.Literal, err = json.Marshal(node.LiteralValue); err != nil {
+				return nil, fmt.Errorf("failed to serialize literal of node %s: %w", id, err)
+			}
+		}
+
+		clients := make([]string, 0, len(node.Clock))
+		for clientID := range node.Clock {
+			clients = append(clients, string(clientID))
+		}
+		sort.Strings(clients)
+		for _, clientID := range clients {
+			bn.Clock = append(bn.Clock, uint64(strings.intern(clientID)), uint64(node.Clock[ClientID(clientID)]))
+		}
+
+		for _, edge := range node.Edges {
+			bn.Edges = append(bn.Edges, binaryEdge{
+				To:       strings.intern(string(edge.To)),
+				Label:    strings.intern(edge.Label),
+				Position: edge.LSEQPosition,
+			})
+		}
+
+		if node.Approved != nil {
+			if bn.Approved, err = json.Marshal(node.Approved); err != nil {
+				return nil, fmt.Errorf("failed to serialize approved change of node %s: %w", id, err)
+			}
+		}
+		if node.Pending != nil {
+			if bn.Pending, err = json.Marshal(node.Pending); err != nil {
+				return nil, fmt.Errorf("failed to serialize pending change of node %s: %w", id, err)
+			}
+		}
+
+		bt.Nodes = append(bt.Nodes, bn)
+	}
+
+	if c.ABACPolicy != nil {
+		if bt.ABAC, err = c.ABACPolicy.MarshalJSON(); err != nil {
+			return nil, fmt.Errorf("failed to serialize ABAC policy: %w", err)
+		}
+	}
+	if len(c.RecoveryCommitments) > 0 {
+		if bt.RecoveryCommitments, err = json.Marshal(c.RecoveryCommitments); err != nil {
+			return nil, fmt.Errorf("failed to serialize recovery commitments: %w", err)
+		}
+	}
+	if len(c.KeyRotations) > 0 {
+		if bt.KeyRotations, err = json.Marshal(c.KeyRotations); err != nil {
+			return nil, fmt.Errorf("failed to serialize key rotations: %w", err)
+		}
+	}
+	if len(c.DeviceLinks) > 0 {
+		if bt.DeviceLinks, err = json.Marshal(c.DeviceLinks); err != nil {
+			return nil, fmt.Errorf("failed to serialize device links: %w", err)
+		}
+	}
+	if len(c.Schemas) > 0 {
+		if bt.Schemas, err = json.Marshal(c.Schemas); err != nil {
+			return nil, fmt.Errorf("failed to serialize schema attachments: %w", err)
+		}
+	}
+
+	bt.Strings = strings.strings
+
+	body, err := binaryEncMode.Marshal(bt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode binary tree: %w", err)
+	}
+
+	return append(append(append([]byte{}, binaryMagic...), binaryFormatVersion), body...), nil
+}
+
+func nodeFlags(node *NodeCRDT) uint8 {
+	var flags uint8
+	for flag, set := range map[uint8]bool{
+		flagRoot:     node.IsRoot,
+		flagDeleted:  node.IsDeleted,
+		flagArray:    node.IsArray,
+		flagPromoted: node.IsPromoted,
+		flagMap:      node.IsMap,
+		flagLiteral:  node.IsLiteral,
+	} {
+		if set {
+			flags |= flag
+		}
+	}
+	return flags
+}
+
+func encodeHexString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	if buf, err := hex.DecodeString(s); err == nil && hex.EncodeToString(buf) == s {
+		return buf
+	}
+	return s
+}
+
+func decodeHexString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("expected bytes or string, got %T", v)
+	}
+}
+
+func isBinary(data []byte) bool {
+	return bytes.HasPrefix(data, binaryMagic)
+}
+
+// decodeBinary decodes the binary format into the same generic structure as the JSON format
+func decodeBinary(data []byte) (map[string]interface{}, error) {
+	if len(data) < len(binaryMagic)+1 {
+		return nil, fmt.Errorf("binary tree is truncated")
+	}
+	if version := data[len(binaryMagic)]; version != binaryFormatVersion {
+		return nil, fmt.Errorf("unsupported binary format version %d", version)
+	}
+
+	bt := &binaryTree{}
+	if err := binaryDecMode.Unmarshal(data[len(binaryMagic)+1:], bt); err != nil {
+		return nil, fmt.Errorf("failed to decode binary tree: %w", err)
+	}
+
+	str := func(i uint32) (string, error) {
+		if int(i) >= len(bt.Strings) {
+			return "", fmt.Errorf("string index %d out of range", i)
+		}
+		return bt.Strings[i], nil
+	}
+
+	raw := make(map[string]interface{})
+	raw["formatVersion"] = float64(1) // Version 1 of the binary format has the layout of format version 1
+	root, err := str(bt.Root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root: %w", err)
+	}
+	raw["root"] = root
+	raw["secure"] = bt.Secure
+
+	nodes := make(map[string]interface{}, len(bt.Nodes))
+	for i, bn := range bt.Nodes {
+		nodeMap, err := decodeBinaryNode(&bn, str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node %d: %w", i, err)
+		}
+		nodes[nodeMap["id"].(string)] = nodeMap
+	}
+	raw["nodes"] = nodes
+
+	for key, buf := range map[string][]byte{
+		"abac":                bt.ABAC,
+		"recoverycommitments": bt.RecoveryCommitments,
+		"keyrotations":        bt.KeyRotations,
+		"devicelinks":         bt.DeviceLinks,
+		"schemas":             bt.Schemas,
+	} {
+		if buf == nil {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		raw[key] = v
+	}
+
+	return raw, nil
+}
+
+func decodeBinaryNode(bn *binaryNode, str func(uint32) (string, error)) (map[string]interface{}, error) {
+	id, err := str(bn.ID)
+	if err != nil {
+		return nil, err
+	}
+	parentID, err := str(bn.Parent)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := str(bn.Owner)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := decodeHexString(bn.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	nounce, err := decodeHexString(bn.Nounce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nounce: %w", err)
+	}
+
+	var literal interface{}
+	if bn.Literal != nil {
+		if err := json.Unmarshal(bn.Literal, &literal); err != nil {
+			return nil, fmt.Errorf("invalid literal: %w", err)
+		}
+	}
+
+	if len(bn.Clock)%2 != 0 {
+		return nil, fmt.Errorf("invalid clock, odd number of entries")
+	}
+	clock := make(map[string]interface{}, len(bn.Clock)/2)
+	for i := 0; i < len(bn.Clock); i += 2 {
+		if bn.Clock[i] > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("string index %d out of range", bn.Clock[i])
+		}
+		clientID, err := str(uint32(bn.Clock[i]))
+		if err != nil {
+			return nil, err
+		}
+		clock[clientID] = float64(bn.Clock[i+1])
+	}
+
+	edges := make([]interface{}, 0, len(bn.Edges))
+	for _, be := range bn.Edges {
+		to, err := str(be.To)
+		if err != nil {
+			return nil, err
+		}
+		label, err := str(be.Label)
+		if err != nil {
+			return nil, err
+		}
+		position := make([]interface{}, len(be.Position))
+		for i, p := range be.Position {
+			position[i] = float64(p)
+		}
+		edges = append(edges, map[string]interface{}{
+			"from":         id,
+			"to":           to,
+			"label":        label,
+			"lseqposition": position,
+		})
+	}
+
+	nodeMap := map[string]interface{}{
+		"id":           id,
+		"isroot":       bn.Flags&flagRoot != 0,
+		"deleted":      bn.Flags&flagDeleted != 0,
+		"parentid":     parentID,
+		"isarray":      bn.Flags&flagArray != 0,
+		"ispromoted":   bn.Flags&flagPromoted != 0,
+		"ismap":        bn.Flags&flagMap != 0,
+		"isliteral":    bn.Flags&flagLiteral != 0,
+		"literalValue": literal,
+		"owner":        owner,
+		"clock":        clock,
+		"signature":    signature,
+		"nounce":       nounce,
+		"edges":        edges,
+	}
+
+	for key, buf := range map[string][]byte{"approved": bn.Approved, "pending": bn.Pending} {
+		if buf == nil {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(buf, &v); err != nil {
+			return nil, fmt.Errorf("invalid %s change: %w", key, err)
+		}
+		nodeMap[key] = v
+	}
+
+	return nodeMap, nil
+}
@@ -0,0 +1,78 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeSaveBinary(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	newPrvKey := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{
+		"name": "Alice",
+		"age": 42,
+		"active": true,
+		"nothing": null,
+		"tags": ["a", "b", {"c": [1.5, 2]}],
+		"secret": ""
+	}`), prvKey)
+	assert.Nil(t, err)
+
+	node, err := c1.GetNodeByPath("/secret")
+	assert.Nil(t, err)
+	err = node.SetEncryptedLiteral("hidden", []string{identity.DID()}, prvKey)
+	assert.Nil(t, err)
+	err = c1.RotateKey(prvKey, newPrvKey)
+	assert.Nil(t, err)
+
+	jsonData, err := c1.Save()
+	assert.Nil(t, err)
+	binaryData, err := c1.SaveAs(FormatBinary)
+	assert.Nil(t, err, "SaveAs binary should not return an error")
+	assert.Less(t, len(binaryData), len(jsonData)/2, "The binary format should be much smaller than JSON")
+
+	c2, err := NewSecureTree(newPrvKey)
+	assert.Nil(t, err)
+	err = c2.Load(binaryData)
+	assert.Nil(t, err, "Load should detect the binary format")
+
+	err = c2.VerifyTree()
+	assert.Nil(t, err, "Signatures should be verifiable after loading the binary format")
+
+	binaryData2, err := c2.SaveAs(FormatBinary)
+	assert.Nil(t, err)
+	assert.Equal(t, binaryData, binaryData2, "The binary format should round-trip byte-identically")
+
+	jsonData2, err := c2.Save()
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonData), string(jsonData2), "Converting to binary and back should give the same JSON")
+
+	exported1, err := c1.ExportJSON()
+	assert.Nil(t, err)
+	exported2, err := c2.ExportJSON()
+	assert.Nil(t, err)
+	compareJSON(t, exported1, exported2)
+
+	t.Run("Reject invalid data", func(t *testing.T) {
+		c3, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+
+		_, err = c3.SaveAs("xml")
+		assert.NotNil(t, err)
+
+		err = c3.Load(binaryData[:len(binaryData)/2])
+		assert.NotNil(t, err, "Load should reject truncated binary data")
+
+		unsupported := append([]byte{}, binaryData...)
+		unsupported[len(binaryMagic)] = 99
+		err = c3.Load(unsupported)
+		assert.NotNil(t, err, "Load should reject unknown format versions")
+	})
+}
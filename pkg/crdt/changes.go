@@ -0,0 +1,157 @@
+package crdt
+
+import (
+	"strconv"
+)
+
+// NodeChange is a value of the document with the owner and clock of its last change
+type NodeChange struct {
+	Pointer string      `json:"pointer"`
+	NodeID  NodeID      `json:"nodeID"`
+	Kind    string      `json:"kind"`  // literal, map or array
+	Value   interface{} `json:"value"` // Only set for literals
+	Owner   ClientID    `json:"owner"`
+	Clock   VectorClock `json:"clock"`
+}
+
+// NodesByOwner returns the values whose last change was made by the identity or one of its rotated keys
+func (c *TreeCRDT) NodesByOwner(id string) ([]*NodeChange, error) {
+	keys := c.keySet(id)
+	return c.changes(func(node *NodeCRDT) bool {
+		return keys[node.Owner]
+	})
+}
+
+// PathsTouchedBy returns the maps and arrays whose items the identity or one of its rotated keys added or
+// removed, and the literals whose current value it wrote. A literal written later by another identity is
+// no longer listed, since its clock only keeps the last write
+func (c *TreeCRDT) PathsTouchedBy(id string) ([]*NodeChange, error) {
+	keys := c.keySet(id)
+	return c.changes(func(node *NodeCRDT) bool {
+		for clientID, version := range node.Clock {
+			if keys[clientID] && version > 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ChangedSince returns the values with changes the clock has not seen, e.g. the merged clock of a replica at
+// its last sync
+func (c *TreeCRDT) ChangedSince(clock VectorClock) ([]*NodeChange, error) {
+	return c.changes(func(node *NodeCRDT) bool {
+		for clientID, version := range node.Clock {
+			if version > clock[clientID] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Clock returns the merged clock of all nodes, i.e. the latest version of each client the tree has seen. Pass
+// it to ChangedSince on a later state of the tree to list what changed in between. Versions are counted per
+// node, so a write that stays at or below the clock, e.g. on a node created later, is not listed
+func (c *TreeCRDT) Clock() VectorClock {
+	clock := make(VectorClock)
+	for _, node := range c.Nodes {
+		for clientID, version := range node.Clock {
+			if version > clock[clientID] {
+				clock[clientID] = version
+			}
+		}
+	}
+	return clock
+}
+
+func (c *TreeCRDT) keySet(id string) map[ClientID]bool {
+	keys := make(map[ClientID]bool)
+	for _, key := range c.keyChain(id) {
+		keys[ClientID(key)] = true
+	}
+	return keys
+}
+
+// changes walks the visible values of the document and returns those matching the filter in document order
+func (c *TreeCRDT) changes(match func(node *NodeCRDT) bool) ([]*NodeChange, error) {
+	changes := make([]*NodeChange, 0)
+	err := c.walkDocument(func(tokens []string, node *NodeCRDT, parent NodeID) {
+		if !match(node) {
+			return
+		}
+		change := &NodeChange{Pointer: joinJSONPointer(tokens), NodeID: node.ID, Kind: nodeKind(node), Owner: node.Owner, Clock: copyClock(node.Clock)}
+		if node.IsLiteral {
+			change.Value = node.effectiveValue()
+		}
+		changes = append(changes, change)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func nodeKind(node *NodeCRDT) string {
+	switch {
+	case node.IsLiteral:
+		return "literal"
+	case node.IsArray:
+		return "array"
+	default:
+		return "map"
+	}
+}
+
+func joinJSONPointer(tokens []string) string {
+	pointer := ""
+	for _, token := range tokens {
+		pointer += "/" + escapeJSONPointer(token)
+	}
+	return pointer
+}
+
+// walkDocument visits the visible values of the document in document order with the unescaped tokens of
+// their pointer and the ID of their parent, the document itself has no tokens
+func (c *TreeCRDT) walkDocument(visit func(tokens []string, node *NodeCRDT, parent NodeID)) error {
+	p := &jsonPatcher{tree: c}
+	doc, err := p.document()
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return nil
+	}
+
+	type item struct {
+		tokens []string
+		node   *NodeCRDT
+		parent NodeID
+	}
+	stack := []item{{tokens: []string{}, node: doc, parent: c.Root.ID}}
+	visited := make(map[NodeID]bool)
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := current.node
+		if visited[node.ID] {
+			continue
+		}
+		visited[node.ID] = true
+
+		visit(current.tokens, node, current.parent)
+
+		// Pushed in reverse, so the values are visited in document order
+		children := p.children(node)
+		for i := len(children) - 1; i >= 0; i-- {
+			token := children[i].Label
+			if node.IsArray {
+				token = strconv.Itoa(i)
+			}
+			tokens := append(current.tokens[:len(current.tokens):len(current.tokens)], token)
+			stack = append(stack, item{tokens: tokens, node: c.Nodes[children[i].To], parent: node.ID})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func changePointers(t *testing.T, changes []*NodeChange, err error) []string {
+	assert.Nil(t, err)
+	pointers := make([]string, 0, len(changes))
+	for _, change := range changes {
+		pointers = append(pointers, change.Pointer)
+	}
+	return pointers
+}
+
+func TestChanges(t *testing.T) {
+	identity, err := crypto.CreateIdendityFromString("ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924")
+	assert.Nil(t, err)
+	clientID := ClientID(identity.ID())
+
+	c := newTreeCRDT()
+	_, err = c.ImportJSON([]byte(`{"b": {"c": 2, "d": 3}, "list": [1]}`), "client1")
+	assert.Nil(t, err)
+
+	changes, err := c.NodesByOwner(identity.ID())
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+
+	err = c.ApplyJSONPatch([]byte(`[
+		{"op": "replace", "path": "/b/c", "value": 5},
+		{"op": "add", "path": "/list/-", "value": 2},
+		{"op": "remove", "path": "/b/d"}
+	]`), identity)
+	assert.Nil(t, err)
+
+	changes, err = c.NodesByOwner(identity.ID())
+	assert.ElementsMatch(t, []string{"/b", "/b/c", "/list", "/list/1"}, changePointers(t, changes, err))
+	for _, change := range changes {
+		assert.Equal(t, clientID, change.Owner)
+		if change.Pointer == "/b/c" {
+			assert.Equal(t, "literal", change.Kind)
+			assert.Equal(t, 5.0, change.Value)
+			assert.Equal(t, VectorClock{clientID: 2}, change.Clock)
+		}
+	}
+
+	// The document map was only changed by client1, the list is now owned by identity but was touched by both
+	changes, err = c.PathsTouchedBy("client1")
+	assert.ElementsMatch(t, []string{"", "/b", "/list", "/list/0"}, changePointers(t, changes, err))
+
+	changes, err = c.ChangedSince(VectorClock{})
+	assert.ElementsMatch(t, []string{"", "/b", "/b/c", "/list", "/list/0", "/list/1"}, changePointers(t, changes, err))
+	changes, err = c.ChangedSince(VectorClock{"client1": 100})
+	assert.ElementsMatch(t, []string{"/b", "/b/c", "/list", "/list/1"}, changePointers(t, changes, err))
+	changes, err = c.ChangedSince(VectorClock{"client1": 100, clientID: 1})
+	assert.ElementsMatch(t, []string{"/b/c"}, changePointers(t, changes, err))
+}
+
+func TestChangesArrayOrder(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"list": [{"a/b": 1}, 2, 3]}`), "client1")
+	assert.Nil(t, err)
+
+	changes, err := c.PathsTouchedBy("client1")
+	assert.Equal(t, []string{"", "/list", "/list/0", "/list/0/a~1b", "/list/1", "/list/2"}, changePointers(t, changes, err))
+}
+
+func TestChangesClock(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": 1, "b": 2}`), "client1")
+	assert.Nil(t, err)
+
+	clock := c.Clock()
+	assert.Len(t, clock, 1)
+	assert.Greater(t, clock["client1"], 0)
+	changes, err := c.ChangedSince(clock)
+	assert.Empty(t, changePointers(t, changes, err))
+
+	node, err := c.GetNodeByPath("/b")
+	assert.Nil(t, err)
+	assert.Nil(t, node.SetLiteral(3, "client2"))
+
+	changes, err = c.ChangedSince(clock)
+	assert.Equal(t, []string{"/b"}, changePointers(t, changes, err))
+	assert.Equal(t, 2, c.Clock()["client2"])
+	changes, err = c.ChangedSince(c.Clock())
+	assert.Empty(t, changePointers(t, changes, err))
+}
@@ -0,0 +1,200 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// A DeviceLink records that a device key belongs to a master identity, typically a key derived from the
+// master seed with crypto.DeriveDeviceIdentity. The link is signed by the master and by the device, so any
+// replica can verify it offline. ABAC rules added with AllowWithDevices apply to the master and to all of
+// its linked devices. A revocation is signed by the master only and permanently unlinks the device.
+
+type DeviceLink struct {
+	MasterID        string `json:"masterid"`
+	DeviceID        string `json:"deviceid"`
+	Index           uint32 `json:"index"`
+	Revoked         bool   `json:"revoked"`
+	MasterSignature string `json:"mastersignature"`
+	DeviceSignature string `json:"devicesignature,omitempty"`
+}
+
+func (dl *DeviceLink) digest() (*crypto.Hash, error) {
+	buf, err := json.Marshal(struct {
+		MasterID string `json:"masterid"`
+		DeviceID string `json:"deviceid"`
+		Index    uint32 `json:"index"`
+		Revoked  bool   `json:"revoked"`
+	}{dl.MasterID, dl.DeviceID, dl.Index, dl.Revoked})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal device link: %w", err)
+	}
+	return crypto.GenerateHashFromString("devicelink:" + string(buf)), nil
+}
+
+func (dl *DeviceLink) verify() error {
+	if dl.MasterID == dl.DeviceID {
+		return fmt.Errorf("Invalid device link, master and device are the same key")
+	}
+
+	digest, err := dl.digest()
+	if err != nil {
+		return err
+	}
+
+	if err := verifySignedBy(digest, dl.MasterSignature, dl.MasterID); err != nil {
+		return fmt.Errorf("Invalid device link of %s, master signature: %w", dl.DeviceID, err)
+	}
+	if !dl.Revoked {
+		if err := verifySignedBy(digest, dl.DeviceSignature, dl.DeviceID); err != nil {
+			return fmt.Errorf("Invalid device link of %s, device signature: %w", dl.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *TreeCRDT) addDeviceLink(dl *DeviceLink) error {
+	if err := dl.verify(); err != nil {
+		return err
+	}
+	for _, existing := range c.DeviceLinks {
+		if existing.MasterSignature == dl.MasterSignature {
+			return nil
+		}
+	}
+	c.DeviceLinks = append(c.DeviceLinks, dl)
+	c.indexDevices()
+	return nil
+}
+
+// indexDevices maps every linked device to its master. Revocations win over links, and if a device has
+// been linked to several masters the lowest master ID wins.
+func (c *TreeCRDT) indexDevices() {
+	revoked := make(map[string]bool)
+	for _, dl := range c.DeviceLinks {
+		if dl.Revoked {
+			revoked[dl.MasterID+":"+dl.DeviceID] = true
+		}
+	}
+
+	masters := make(map[string]string)
+	for _, dl := range c.DeviceLinks {
+		if dl.Revoked || revoked[dl.MasterID+":"+dl.DeviceID] {
+			continue
+		}
+		if existing, ok := masters[dl.DeviceID]; !ok || dl.MasterID < existing {
+			masters[dl.DeviceID] = dl.MasterID
+		}
+	}
+
+	c.devices = masters
+}
+
+// deviceMaster returns the master of a linked device, or an empty string
+func (c *TreeCRDT) deviceMaster(id string) string {
+	return c.devices[id]
+}
+
+// Devices returns the IDs of the devices currently linked to a master, sorted
+func (c *TreeCRDT) Devices(masterID string) []string {
+	devices := make([]string, 0)
+	for deviceID, master := range c.devices {
+		if master == masterID {
+			devices = append(devices, deviceID)
+		}
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+func (c *TreeCRDT) LinkDevice(master *crypto.Idendity, device *crypto.Idendity, index uint32) (*DeviceLink, error) {
+	if existing := c.deviceMaster(device.ID()); existing != "" {
+		return nil, fmt.Errorf("Device %s is already linked to %s", device.ID(), existing)
+	}
+
+	dl := &DeviceLink{MasterID: master.ID(), DeviceID: device.ID(), Index: index}
+	digest, err := dl.digest()
+	if err != nil {
+		return nil, err
+	}
+	dl.MasterSignature, err = signDigest(digest, master)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign device link with master key: %w", err)
+	}
+	dl.DeviceSignature, err = signDigest(digest, device)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign device link with device key: %w", err)
+	}
+
+	if err := c.addDeviceLink(dl); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"MasterID": dl.MasterID,
+		"DeviceID": dl.DeviceID,
+		"Index":    dl.Index,
+	}).Debug("Device linked")
+
+	return dl, nil
+}
+
+func (c *TreeCRDT) UnlinkDevice(master *crypto.Idendity, deviceID string) (*DeviceLink, error) {
+	deviceID, err := crypto.ResolveID(deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve device ID: %w", err)
+	}
+	if c.deviceMaster(deviceID) != master.ID() {
+		return nil, fmt.Errorf("Device %s is not linked to %s", deviceID, master.ID())
+	}
+
+	dl := &DeviceLink{MasterID: master.ID(), DeviceID: deviceID, Revoked: true}
+	for _, existing := range c.DeviceLinks {
+		if existing.MasterID == dl.MasterID && existing.DeviceID == deviceID {
+			dl.Index = existing.Index
+		}
+	}
+
+	digest, err := dl.digest()
+	if err != nil {
+		return nil, err
+	}
+	dl.MasterSignature, err = signDigest(digest, master)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign device revocation: %w", err)
+	}
+
+	if err := c.addDeviceLink(dl); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"MasterID": dl.MasterID,
+		"DeviceID": dl.DeviceID,
+	}).Debug("Device unlinked")
+
+	return dl, nil
+}
+
+func (c *TreeCRDT) mergeDevices(c2 *TreeCRDT) error {
+	for _, dl := range c2.DeviceLinks {
+		if err := c.addDeviceLink(dl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *TreeCRDT) verifyDevices() error {
+	for _, dl := range c.DeviceLinks {
+		if err := dl.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
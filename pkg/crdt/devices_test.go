@@ -0,0 +1,110 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeDeviceLinks(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	mnemonic, err := crypto.NewMnemonic(128)
+	assert.Nil(t, err)
+	seed, err := crypto.SeedFromMnemonic(mnemonic, "")
+	assert.Nil(t, err)
+
+	master, err := crypto.MasterIdentity(seed)
+	assert.Nil(t, err)
+	laptop, err := crypto.DeriveDeviceIdentity(seed, 0)
+	assert.Nil(t, err)
+	phone, err := crypto.DeriveDeviceIdentity(seed, 1)
+	assert.Nil(t, err)
+	stranger, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"a": {"b": "1"}, "c": "2"}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	tree := c1.(*AdapterSecureTreeCRDT).treeCrdt
+	err = c1.ABAC().AllowWithDevices(master.DID(), ActionModify, tree.Root.ID, true)
+	assert.Nil(t, err, "AllowWithDevices should not return an error")
+
+	c3, err := c1.Clone()
+	assert.Nil(t, err)
+
+	err = c1.LinkDevice(master.PrivateKeyAsHex(), laptop.PrivateKeyAsHex(), 0)
+	assert.Nil(t, err, "LinkDevice should not return an error")
+	err = c1.LinkDevice(master.PrivateKeyAsHex(), phone.PrivateKeyAsHex(), 1)
+	assert.Nil(t, err)
+	err = c1.LinkDevice(stranger.PrivateKeyAsHex(), phone.PrivateKeyAsHex(), 0)
+	assert.NotNil(t, err, "A device can only be linked to one master")
+
+	assert.Equal(t, 2, len(c1.Devices(master.DID())))
+
+	assert.True(t, c1.ABAC().IsAllowed(master.ID(), ActionModify, tree.Root.ID))
+	assert.True(t, c1.ABAC().IsAllowed(laptop.ID(), ActionModify, tree.Root.ID), "Linked devices should inherit the rules of the master")
+	assert.False(t, c1.ABAC().IsAllowed(stranger.ID(), ActionModify, tree.Root.ID))
+
+	node, err := c1.GetNodeByPath("/a/b")
+	assert.Nil(t, err)
+	err = node.SetLiteral("3", phone.PrivateKeyAsHex())
+	assert.Nil(t, err, "A linked device should be able to modify the tree")
+
+	t.Run("Rules without devices do not apply to devices", func(t *testing.T) {
+		err := c1.ABAC().Allow(master.ID(), ActionRead, "c", false)
+		assert.Nil(t, err)
+		assert.True(t, c1.ABAC().IsAllowed(master.ID(), ActionRead, "c"))
+		assert.False(t, c1.ABAC().IsAllowed(laptop.ID(), ActionRead, "c"))
+	})
+
+	t.Run("Links are verified offline by other replicas", func(t *testing.T) {
+		c2, err := c1.Clone()
+		assert.Nil(t, err)
+		assert.Nil(t, c2.VerifyTree())
+		assert.True(t, c2.ABAC().IsAllowed(phone.ID(), ActionModify, tree.Root.ID))
+
+		err = c3.Merge(c1, ownerPrvKey)
+		assert.Nil(t, err, "A replica without the links should accept changes made by linked devices")
+		assert.Equal(t, c1.Devices(master.ID()), c3.Devices(master.ID()))
+	})
+
+	t.Run("Unlink device", func(t *testing.T) {
+		c2, err := c1.Clone()
+		assert.Nil(t, err)
+
+		err = c1.UnlinkDevice(stranger.PrivateKeyAsHex(), laptop.ID())
+		assert.NotNil(t, err, "Only the master can unlink a device")
+
+		err = c1.UnlinkDevice(master.PrivateKeyAsHex(), laptop.DID())
+		assert.Nil(t, err, "UnlinkDevice should not return an error")
+		assert.False(t, c1.ABAC().IsAllowed(laptop.ID(), ActionModify, tree.Root.ID))
+		assert.True(t, c1.ABAC().IsAllowed(phone.ID(), ActionModify, tree.Root.ID))
+
+		err = c1.LinkDevice(master.PrivateKeyAsHex(), laptop.PrivateKeyAsHex(), 0)
+		assert.Nil(t, err)
+		assert.False(t, c1.ABAC().IsAllowed(laptop.ID(), ActionModify, tree.Root.ID), "A revocation should be permanent")
+
+		// The revocation wins after merging with a replica that still has the link
+		err = c2.Merge(c1, ownerPrvKey)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{phone.ID()}, c2.Devices(master.ID()))
+	})
+
+	t.Run("Reject tampered link", func(t *testing.T) {
+		c2, err := c1.Clone()
+		assert.Nil(t, err)
+		tree2 := c2.(*AdapterSecureTreeCRDT).treeCrdt
+		tree2.DeviceLinks[0].DeviceID = stranger.ID()
+		assert.NotNil(t, c2.VerifyTree(), "VerifyTree should detect a modified device link")
+
+		data, err := c2.Save()
+		assert.Nil(t, err)
+		c3, err := NewSecureTree(ownerPrvKey)
+		assert.Nil(t, err)
+		assert.NotNil(t, c3.Load(data), "Load should reject a modified device link")
+	})
+}
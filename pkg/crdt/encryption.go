@@ -0,0 +1,221 @@
+package crdt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Encrypted literals store an envelope instead of the cleartext value. The value is encrypted once
+// with a random AES-256-GCM content key, and the content key is encrypted with ECIES to the public key
+// of every recipient. The envelope is stored as the literal value, so it is signed, merged and saved
+// like any other literal, and replicas that are not recipients only ever see the ciphertext.
+
+const encryptionScheme = "ecies-secp256k1-aes256gcm"
+
+type EncryptedValue struct {
+	Scheme     string                         `json:"encrypted"`
+	Ciphertext string                         `json:"ciphertext"`
+	Recipients map[string]EncryptionRecipient `json:"recipients"`
+}
+
+type EncryptionRecipient struct {
+	PublicKey string `json:"publickey"`
+	Key       string `json:"key"`
+}
+
+// encryptValue encrypts a value to recipients given as did:key DIDs or hex encoded public keys
+func encryptValue(value interface{}, recipients []string) (map[string]interface{}, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("Cannot encrypt value, no recipients")
+	}
+
+	plaintext, err := json.Marshal(normalizeNumber(value))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal value: %w", err)
+	}
+
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+
+	gcm, err := contentCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ev := &EncryptedValue{
+		Scheme:     encryptionScheme,
+		Ciphertext: hex.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)),
+		Recipients: make(map[string]EncryptionRecipient),
+	}
+
+	for _, recipient := range recipients {
+		publicKey, err := crypto.PublicKeyFromString(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrappedKey, err := crypto.ECIESEncrypt(publicKey, contentKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encrypt content key: %w", err)
+		}
+		ev.Recipients[crypto.IDFromPublicKey(publicKey)] = EncryptionRecipient{
+			PublicKey: hex.EncodeToString(publicKey),
+			Key:       hex.EncodeToString(wrappedKey),
+		}
+	}
+
+	// Store the envelope as generic JSON so it looks the same before and after Save and Load
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	var literal map[string]interface{}
+	if err := json.Unmarshal(buf, &literal); err != nil {
+		return nil, err
+	}
+
+	return literal, nil
+}
+
+func contentCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptedValue(value interface{}) (*EncryptedValue, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || m["encrypted"] != encryptionScheme {
+		return nil, false
+	}
+
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return nil, false
+	}
+	ev := &EncryptedValue{}
+	if err := json.Unmarshal(buf, ev); err != nil {
+		return nil, false
+	}
+
+	return ev, true
+}
+
+func (ev *EncryptedValue) decrypt(identity *crypto.Idendity) (interface{}, error) {
+	recipient, ok := ev.Recipients[identity.ID()]
+	if !ok {
+		return nil, fmt.Errorf("identity %s is not a recipient of the encrypted value", identity.ID())
+	}
+
+	wrappedKey, err := hex.DecodeString(recipient.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode content key: %w", err)
+	}
+	contentKey, err := crypto.ECIESDecrypt(identity, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt content key: %w", err)
+	}
+
+	gcm, err := contentCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := hex.DecodeString(ev.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode ciphertext: %w", err)
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("Invalid ciphertext, too short")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt value: %w", err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(plaintext, &value); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal decrypted value: %w", err)
+	}
+
+	return value, nil
+}
+
+// Readers returns the IDs of all recipients, sorted
+func (ev *EncryptedValue) Readers() []string {
+	readers := make([]string, 0, len(ev.Recipients))
+	for id := range ev.Recipients {
+		readers = append(readers, id)
+	}
+	sort.Strings(readers)
+	return readers
+}
+
+// decryptLiteral returns the cleartext of an encrypted literal using the identity of the tree. Values
+// that are not encrypted are returned as is.
+func (c *TreeCRDT) decryptLiteral(value interface{}) (interface{}, error) {
+	ev, ok := encryptedValue(value)
+	if !ok {
+		return value, nil
+	}
+
+	if c.ABACPolicy == nil || c.ABACPolicy.identity == nil {
+		return nil, fmt.Errorf("Cannot decrypt value, the tree has no identity")
+	}
+
+	return ev.decrypt(c.ABACPolicy.identity)
+}
+
+func (n *NodeCRDT) SetEncryptedLiteral(value interface{}, recipients []string, clientID ClientID) error {
+	literal, err := encryptValue(value, recipients)
+	if err != nil {
+		return err
+	}
+	return n.SetLiteral(literal, clientID)
+}
+
+func (n *NodeCRDT) IsEncrypted() bool {
+	_, ok := encryptedValue(n.LiteralValue)
+	return ok
+}
+
+// AddReaders re-encrypts the literal with a new content key for the current recipients and the new readers.
+// The identity must be a recipient.
+func (n *NodeCRDT) AddReaders(readers []string, identity *crypto.Idendity) error {
+	ev, ok := encryptedValue(n.LiteralValue)
+	if !ok {
+		return fmt.Errorf("Node %s is not an encrypted literal", n.ID)
+	}
+
+	value, err := ev.decrypt(identity)
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]string, 0, len(ev.Recipients)+len(readers))
+	for _, id := range ev.Readers() {
+		recipients = append(recipients, ev.Recipients[id].PublicKey)
+	}
+	recipients = append(recipients, readers...)
+
+	log.WithFields(log.Fields{
+		"NodeID":  n.ID,
+		"Readers": len(recipients),
+	}).Debug("Re-encrypting literal for new readers")
+
+	return n.SetEncryptedLiteral(value, recipients, ClientID(identity.ID()))
+}
@@ -0,0 +1,105 @@
+package crdt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeEncryptedLiteral(t *testing.T) {
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+	identity3, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	prvKey3 := identity3.PrivateKeyAsHex()
+
+	c1, err := NewSecureTree(prvKey1)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"patient": {"name": "Alice", "diagnosis": ""}}`), prvKey1)
+	assert.Nil(t, err)
+
+	node, err := c1.GetNodeByPath("/patient/diagnosis")
+	assert.Nil(t, err)
+	err = node.SetEncryptedLiteral(map[string]interface{}{"code": "J45", "severity": 2}, []string{identity2.DID()}, prvKey1)
+	assert.Nil(t, err, "SetEncryptedLiteral should not return an error")
+	assert.True(t, node.IsEncrypted())
+
+	err = c1.VerifyTree()
+	assert.Nil(t, err, "The encrypted literal should be signed like any literal")
+
+	value, err := c1.GetValueByPath("/patient/diagnosis")
+	assert.Nil(t, err, "The writer should be able to decrypt")
+	assert.Equal(t, map[string]interface{}{"code": "J45", "severity": float64(2)}, value)
+
+	data, err := c1.Save()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(data), "J45"), "Save should not contain the cleartext")
+
+	load := func(prvKey string) SecureTree {
+		c, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+		err = c.Load(data)
+		assert.Nil(t, err)
+		return c
+	}
+
+	t.Run("Recipient can decrypt", func(t *testing.T) {
+		c2 := load(prvKey2)
+		value, err := c2.GetValueByPath("/patient/diagnosis")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"code": "J45", "severity": float64(2)}, value)
+
+		node, err := c2.GetNodeByPath("/patient/diagnosis")
+		assert.Nil(t, err)
+		value, err = node.GetLiteral()
+		assert.Nil(t, err)
+		assert.Equal(t, "J45", value.(map[string]interface{})["code"])
+	})
+
+	t.Run("Relay cannot decrypt but can verify", func(t *testing.T) {
+		c3 := load(prvKey3)
+		_, err := c3.GetValueByPath("/patient/diagnosis")
+		assert.NotNil(t, err, "A relay should not be able to decrypt")
+
+		value, err := c3.GetValueByPath("/patient/name")
+		assert.Nil(t, err)
+		assert.Equal(t, "Alice", value)
+
+		err = c3.VerifyTree()
+		assert.Nil(t, err)
+	})
+
+	t.Run("Add readers", func(t *testing.T) {
+		err := node.AddReaders([]string{identity3.DID()}, prvKey3)
+		assert.NotNil(t, err, "Only recipients can add readers")
+
+		err = node.AddReaders([]string{identity3.PublicKeyAsHex()}, prvKey1)
+		assert.Nil(t, err, "AddReaders should not return an error")
+
+		data, err = c1.Save()
+		assert.Nil(t, err)
+
+		for _, prvKey := range []string{prvKey1, prvKey2, prvKey3} {
+			c := load(prvKey)
+			value, err := c.GetValueByPath("/patient/diagnosis")
+			assert.Nil(t, err, "All readers should be able to decrypt after re-encryption")
+			assert.Equal(t, "J45", value.(map[string]interface{})["code"])
+		}
+	})
+
+	t.Run("Reject tampered ciphertext", func(t *testing.T) {
+		tree := c1.(*AdapterSecureTreeCRDT).treeCrdt
+		n, err := tree.GetNodeByPath("/patient/diagnosis")
+		assert.Nil(t, err)
+		n.LiteralValue.(map[string]interface{})["ciphertext"] = "00"
+		n.invalidateHash()
+
+		err = c1.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should detect a modified ciphertext")
+	})
+}
@@ -0,0 +1,456 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/iancoleman/orderedmap"
+	"gopkg.in/yaml.v3"
+)
+
+// YAML and TOML documents are converted to JSON in document order and imported like JSON. Exports keep the key order of
+// ExportJSON, TOML moves tables after the plain keys of a table since the format requires it. A YAML stream
+// with several documents is imported as an array with one item per document.
+
+func (c *TreeCRDT) ImportYAML(rawYAML []byte, clientID ClientID) (NodeID, error) {
+	rawJSON, err := YAMLToJSON(rawYAML)
+	if err != nil {
+		return "", err
+	}
+	return c.ImportJSON(rawJSON, clientID)
+}
+
+func (c *TreeCRDT) SecureImportYAML(rawYAML []byte, identity *crypto.Idendity) (NodeID, error) {
+	rawJSON, err := YAMLToJSON(rawYAML)
+	if err != nil {
+		return "", err
+	}
+	return c.SecureImportJSON(rawJSON, identity)
+}
+
+func (c *TreeCRDT) ImportTOML(rawTOML []byte, clientID ClientID) (NodeID, error) {
+	rawJSON, err := TOMLToJSON(rawTOML)
+	if err != nil {
+		return "", err
+	}
+	return c.ImportJSON(rawJSON, clientID)
+}
+
+func (c *TreeCRDT) SecureImportTOML(rawTOML []byte, identity *crypto.Idendity) (NodeID, error) {
+	rawJSON, err := TOMLToJSON(rawTOML)
+	if err != nil {
+		return "", err
+	}
+	return c.SecureImportJSON(rawJSON, identity)
+}
+
+func (c *TreeCRDT) ExportYAML() ([]byte, error) {
+	exported, err := c.export()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(yamlNode(exported)); err != nil {
+		return nil, fmt.Errorf("Failed to encode YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to encode YAML: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *TreeCRDT) ExportTOML() ([]byte, error) {
+	exported, err := c.export()
+	if err != nil {
+		return nil, err
+	}
+
+	table, ok := exported.(*orderedmap.OrderedMap)
+	if !ok {
+		return nil, errors.New("Failed to encode TOML: the document must be an object")
+	}
+
+	var buf bytes.Buffer
+	if err := writeTOMLTable(&buf, "", table); err != nil {
+		return nil, fmt.Errorf("Failed to encode TOML: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// YAMLToJSON converts a YAML document, or a stream of documents, to JSON with the key order of the YAML
+func YAMLToJSON(rawYAML []byte) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(rawYAML))
+
+	docs := make([]interface{}, 0)
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse YAML: %w", err)
+		}
+		var doc interface{}
+		if err := node.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("Failed to parse YAML: %w", err)
+		}
+		order := make(keyOrder)
+		order.addYAML(&node, "")
+		value, err := jsonCompatible(doc, "", order)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert YAML: %w", err)
+		}
+		docs = append(docs, value)
+	}
+
+	switch len(docs) {
+	case 0:
+		return nil, errors.New("Failed to parse YAML: no documents")
+	case 1:
+		return json.Marshal(docs[0])
+	}
+	return json.Marshal(docs)
+}
+
+// TOMLToJSON converts a TOML document to JSON with the key order of the TOML, dates and times become strings
+func TOMLToJSON(rawTOML []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	meta, err := toml.Decode(string(rawTOML), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse TOML: %w", err)
+	}
+	order := make(keyOrder)
+	order.addTOML(meta.Keys(), doc)
+	value, err := jsonCompatible(doc, "", order)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to convert TOML: %w", err)
+	}
+	return json.Marshal(value)
+}
+
+// keyOrder maps the JSON pointer of every object to its keys in document order
+type keyOrder map[string][]string
+
+func (o keyOrder) add(path string, key string) {
+	for _, existing := range o[path] {
+		if existing == key {
+			return
+		}
+	}
+	o[path] = append(o[path], key)
+}
+
+func (o keyOrder) addYAML(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			o.addYAML(node.Content[0], path)
+		}
+	case yaml.AliasNode:
+		o.addYAML(node.Alias, path)
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			o.addYAML(item, path+"/"+strconv.Itoa(i))
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value // Merged keys are not listed and come last
+			o.add(path, key)
+			o.addYAML(node.Content[i+1], path+"/"+escapeJSONPointer(key))
+		}
+	}
+}
+
+// addTOML adds the keys in the order they are defined. Every definition of an array of tables starts
+// a new table in the array.
+func (o keyOrder) addTOML(keys []toml.Key, doc map[string]interface{}) {
+	items := make(map[string]int) // Array of tables -> index of the current table
+	for _, key := range keys {
+		path := ""
+		var value interface{} = doc
+		for i, part := range key {
+			table, ok := value.(map[string]interface{})
+			if !ok {
+				break
+			}
+			o.add(path, part)
+			path += "/" + escapeJSONPointer(part)
+			value = table[part]
+			if tables, ok := value.([]map[string]interface{}); ok {
+				if i == len(key)-1 {
+					items[path]++
+				}
+				index := items[path] - 1
+				if index < 0 || index >= len(tables) {
+					break
+				}
+				path += "/" + strconv.Itoa(index)
+				value = tables[index]
+			}
+		}
+	}
+}
+
+// jsonCompatible converts decoded YAML and TOML values to values that can be marshalled as JSON. Objects
+// become ordered maps with the keys in document order, followed by any keys the order does not list.
+func jsonCompatible(v interface{}, path string, order keyOrder) (interface{}, error) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return orderedJSONObject(val, path, order)
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(val))
+		for key, child := range val {
+			result[fmt.Sprint(key)] = child
+		}
+		return orderedJSONObject(result, path, order)
+	case []interface{}:
+		result := make([]interface{}, len(val))
+		for i, child := range val {
+			converted, err := jsonCompatible(child, path+"/"+strconv.Itoa(i), order)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	case []map[string]interface{}:
+		result := make([]interface{}, len(val))
+		for i, child := range val {
+			converted, err := jsonCompatible(child, path+"/"+strconv.Itoa(i), order)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return nil, fmt.Errorf("%s: %v cannot be represented in JSON", path, val)
+		}
+		return val, nil
+	case nil, string, bool, int, int64, uint64:
+		return val, nil
+	case time.Time:
+		return val.Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return val.String(), nil // Dates and times
+	}
+	return nil, fmt.Errorf("%s: unsupported value of type %T", path, v)
+}
+
+func orderedJSONObject(obj map[string]interface{}, path string, order keyOrder) (*orderedmap.OrderedMap, error) {
+	keys := make([]string, 0, len(obj))
+	for _, key := range order[path] {
+		if _, ok := obj[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	rest := make([]string, 0)
+	for key := range obj {
+		if !containsID(keys, key) {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+
+	result := orderedmap.New()
+	for _, key := range append(keys, rest...) {
+		converted, err := jsonCompatible(obj[key], path+"/"+escapeJSONPointer(key), order)
+		if err != nil {
+			return nil, err
+		}
+		result.Set(key, converted)
+	}
+	return result, nil
+}
+
+func yamlNode(v interface{}) *yaml.Node {
+	switch val := v.(type) {
+	case *orderedmap.OrderedMap:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range val.Keys() {
+			child, _ := val.Get(key)
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, yamlNode(child))
+		}
+		return node
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, child := range val {
+			node.Content = append(node.Content, yamlNode(child))
+		}
+		return node
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(val)}
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(int64(val), 10)}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(val, 'g', -1, 64)}
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: val}
+	case map[string]interface{}: // Literal objects, e.g. encrypted values
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range keys {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, yamlNode(val[key]))
+		}
+		return node
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v)}
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s) // JSON escapes are valid in TOML basic strings
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// isTOMLTableArray reports if the value is a non-empty array of tables, written as [[key]] sections
+func isTOMLTableArray(v interface{}) bool {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if _, ok := item.(*orderedmap.OrderedMap); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTOMLTable writes the plain keys of the table first, followed by its sub-tables and arrays of tables
+func writeTOMLTable(buf *bytes.Buffer, path string, table *orderedmap.OrderedMap) error {
+	for _, key := range table.Keys() {
+		value, _ := table.Get(key)
+		if _, ok := value.(*orderedmap.OrderedMap); ok || isTOMLTableArray(value) {
+			continue
+		}
+		inline, err := tomlValue(value, path+"."+tomlKey(key))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%s = %s\n", tomlKey(key), inline)
+	}
+
+	for _, key := range table.Keys() {
+		value, _ := table.Get(key)
+		childPath := tomlKey(key)
+		if path != "" {
+			childPath = path + "." + childPath
+		}
+
+		if child, ok := value.(*orderedmap.OrderedMap); ok {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(buf, "[%s]\n", childPath)
+			if err := writeTOMLTable(buf, childPath, child); err != nil {
+				return err
+			}
+		} else if isTOMLTableArray(value) {
+			for _, item := range value.([]interface{}) {
+				if buf.Len() > 0 {
+					buf.WriteString("\n")
+				}
+				fmt.Fprintf(buf, "[[%s]]\n", childPath)
+				if err := writeTOMLTable(buf, childPath, item.(*orderedmap.OrderedMap)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func tomlValue(v interface{}, path string) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", fmt.Errorf("%s: TOML cannot represent null", strings.TrimPrefix(path, "."))
+	case bool:
+		return strconv.FormatBool(val), nil
+	case string:
+		return tomlString(val), nil
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+			return strconv.FormatInt(int64(val), 10), nil
+		}
+		s := strconv.FormatFloat(val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return s, nil
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			s, err := tomlValue(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case *orderedmap.OrderedMap:
+		items := make([]string, 0, len(val.Keys()))
+		for _, key := range val.Keys() {
+			child, _ := val.Get(key)
+			s, err := tomlValue(child, path+"."+tomlKey(key))
+			if err != nil {
+				return "", err
+			}
+			items = append(items, tomlKey(key)+" = "+s)
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	case map[string]interface{}: // Literal objects, e.g. encrypted values
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			s, err := tomlValue(val[key], path+"."+tomlKey(key))
+			if err != nil {
+				return "", err
+			}
+			items[i] = tomlKey(key) + " = " + s
+		}
+		return "{" + strings.Join(items, ", ") + "}", nil
+	}
+
+	return "", fmt.Errorf("%s: unsupported value of type %T", strings.TrimPrefix(path, "."), v)
+}
@@ -0,0 +1,201 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestImportExportYAML(t *testing.T) {
+	doc := []byte(`name: sensor
+enabled: true
+version: "1.0"
+port: 8080
+ratio: 0.5
+labels:
+  zone: b
+  area: a
+tags:
+  - x
+  - "123"
+empty: []
+`)
+
+	rawJSON, err := YAMLToJSON(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"sensor","enabled":true,"version":"1.0","port":8080,"ratio":0.5,"labels":{"zone":"b","area":"a"},"tags":["x","123"],"empty":[]}`, string(rawJSON), "The keys should keep the YAML order")
+
+	c := newTreeCRDT()
+	c.SetIDScheme(IDSchemePath)
+	_, err = c.ImportYAML(doc, "client1")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(rawJSON), string(exported))
+
+	yamlDoc, err := c.ExportYAML()
+	assert.Nil(t, err)
+	assert.Contains(t, string(yamlDoc), `version: "1.0"`, "Strings that look like numbers should stay quoted")
+	assert.Contains(t, string(yamlDoc), `- "123"`)
+	assert.Equal(t, topLevelKeys(t, exported), yamlTopLevelKeys(t, yamlDoc), "Key order should follow ExportJSON")
+
+	c2 := newTreeCRDT()
+	c2.SetIDScheme(IDSchemePath)
+	_, err = c2.ImportYAML(yamlDoc, "client1")
+	assert.Nil(t, err)
+	exported2, err := c2.ExportJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, string(exported), string(exported2), "YAML export should round trip with the same key order")
+}
+
+func TestYAMLToJSONKeyOrder(t *testing.T) {
+	doc := []byte(`base: &base
+  zone: b
+  area: a
+device:
+  <<: *base
+  name: sensor
+list:
+  - zone: b
+    area: a
+  - *base
+`)
+
+	rawJSON, err := YAMLToJSON(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"base":{"zone":"b","area":"a"},"device":{"name":"sensor","area":"a","zone":"b"},"list":[{"zone":"b","area":"a"},{"zone":"b","area":"a"}]}`, string(rawJSON), "Merged keys should follow the keys of the mapping")
+}
+
+func topLevelKeys(t *testing.T, rawJSON []byte) []string {
+	obj := orderedmap.New()
+	assert.Nil(t, json.Unmarshal(rawJSON, obj))
+	return obj.Keys()
+}
+
+func yamlTopLevelKeys(t *testing.T, rawYAML []byte) []string {
+	var doc yaml.Node
+	assert.Nil(t, yaml.Unmarshal(rawYAML, &doc))
+	keys := make([]string, 0)
+	for i := 0; i < len(doc.Content[0].Content); i += 2 {
+		keys = append(keys, doc.Content[0].Content[i].Value)
+	}
+	return keys
+}
+
+func TestImportYAMLMultiDocument(t *testing.T) {
+	doc := []byte("kind: Service\n---\nkind: Deployment\n")
+
+	c := newTreeCRDT()
+	_, err := c.ImportYAML(doc, "client1")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"kind":"Service"},{"kind":"Deployment"}]`, string(exported))
+
+	rawJSON, err := YAMLToJSON([]byte("kind: Service\nname: a\n---\nname: b\nkind: Deployment\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"kind":"Service","name":"a"},{"name":"b","kind":"Deployment"}]`, string(rawJSON))
+
+	_, err = newTreeCRDT().ImportYAML([]byte(""), "client1")
+	assert.NotNil(t, err, "An empty stream has no document to import")
+}
+
+func TestImportExportTOML(t *testing.T) {
+	doc := []byte(`title = "fleet"
+interval = 10
+
+[owner]
+name = "ops"
+started = 2024-01-02T03:04:05Z
+
+[[devices]]
+id = 1
+tags = ["a", "b"]
+
+[[devices]]
+id = 2
+`)
+
+	rawJSON, err := TOMLToJSON(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"title":"fleet","interval":10,"owner":{"name":"ops","started":"2024-01-02T03:04:05Z"},"devices":[{"id":1,"tags":["a","b"]},{"id":2}]}`, string(rawJSON), "The keys should keep the TOML order")
+
+	c := newTreeCRDT()
+	c.SetIDScheme(IDSchemePath)
+	_, err = c.ImportTOML(doc, "client1")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(rawJSON), string(exported))
+
+	tomlDoc, err := c.ExportTOML()
+	assert.Nil(t, err)
+
+	c2 := newTreeCRDT()
+	c2.SetIDScheme(IDSchemePath)
+	_, err = c2.ImportTOML(tomlDoc, "client1")
+	assert.Nil(t, err, string(tomlDoc))
+
+	exported2, err := c2.ExportJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, string(exported), string(exported2), "TOML export should round trip with the same key order")
+}
+
+func TestTOMLToJSONKeyOrder(t *testing.T) {
+	doc := []byte(`zone = "b"
+area = "a"
+limits = {max = 10, min = 1}
+
+[[devices]]
+name = "first"
+id = 1
+
+[[devices]]
+id = 2
+name = "second"
+
+[site.room]
+floor = 2
+`)
+
+	rawJSON, err := TOMLToJSON(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"zone":"b","area":"a","limits":{"max":10,"min":1},"devices":[{"name":"first","id":1},{"id":2,"name":"second"}],"site":{"room":{"floor":2}}}`, string(rawJSON), "Every table of an array should keep its own key order")
+}
+
+func TestExportTOMLErrors(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": {"b": null}}`), "client1")
+	assert.Nil(t, err)
+	_, err = c.ExportTOML()
+	assert.NotNil(t, err, "TOML cannot represent null")
+	assert.Contains(t, err.Error(), "a.b")
+
+	c = newTreeCRDT()
+	_, err = c.ImportJSON([]byte(`[1, 2]`), "client1")
+	assert.Nil(t, err)
+	_, err = c.ExportTOML()
+	assert.NotNil(t, err, "A TOML document must be a table")
+}
+
+func TestSecureImportYAML(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+
+	_, err = c.ImportYAML([]byte("a: 1\nb: [x, y]\n"), prvKey)
+	assert.Nil(t, err)
+
+	tomlDoc, err := c.ExportTOML()
+	assert.Nil(t, err)
+	assert.Contains(t, string(tomlDoc), "a = 1\n")
+	assert.Contains(t, string(tomlDoc), "b = [\"x\", \"y\"]\n")
+
+	assert.Nil(t, c.VerifyTree())
+}
@@ -0,0 +1,100 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nodeIDs(c *TreeCRDT) []NodeID {
+	ids := make([]NodeID, 0, len(c.Nodes))
+	for id := range c.Nodes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestIDSchemePath(t *testing.T) {
+	doc := []byte(`{"name": "fleet", "devices": [{"id": 1, "tags": ["a", "b"]}, {"id": 2}], "config": {"interval": 10}}`)
+
+	c1 := newTreeCRDT()
+	c1.SetIDScheme(IDSchemePath)
+	_, err := c1.ImportJSON(doc, "client1")
+	assert.Nil(t, err)
+
+	c2 := newTreeCRDT()
+	c2.SetIDScheme(IDSchemePath)
+	_, err = c2.ImportJSON(doc, "client2")
+	assert.Nil(t, err)
+
+	assert.ElementsMatch(t, nodeIDs(c1), nodeIDs(c2), "Identical imports should create the same nodes")
+
+	err = c1.Merge(c2)
+	assert.Nil(t, err)
+	assert.Len(t, c1.Nodes, len(c2.Nodes), "Merging identical imports should not add nodes")
+
+	exported, err := c1.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(doc), string(exported), "Merging identical imports should not duplicate the document")
+
+	_, err = c1.ImportJSON(doc, "client1")
+	assert.NotNil(t, err, "Importing over existing nodes should fail")
+	assert.Contains(t, err.Error(), "ReconcileJSON")
+}
+
+func TestIDSchemeCreatorPath(t *testing.T) {
+	doc := []byte(`{"a": [1, 2]}`)
+
+	c1 := newTreeCRDT()
+	c1.SetIDScheme(IDSchemeCreatorPath)
+	_, err := c1.ImportJSON(doc, "client1")
+	assert.Nil(t, err)
+
+	c2 := newTreeCRDT()
+	c2.SetIDScheme(IDSchemeCreatorPath)
+	_, err = c2.ImportJSON(doc, "client1")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, nodeIDs(c1), nodeIDs(c2), "Imports by the same creator should create the same nodes")
+
+	c3 := newTreeCRDT()
+	c3.SetIDScheme(IDSchemeCreatorPath)
+	_, err = c3.ImportJSON(doc, "client2")
+	assert.Nil(t, err)
+	assert.Len(t, c3.Nodes, len(c1.Nodes))
+	for id := range c3.Nodes {
+		if id != c3.Root.ID {
+			assert.NotContains(t, c1.Nodes, id, "Imports by different creators should create different nodes")
+		}
+	}
+
+	c4 := newTreeCRDT()
+	_, err = c4.ImportJSON(doc, "client1")
+	assert.Nil(t, err)
+	for id := range c4.Nodes {
+		if id != c4.Root.ID {
+			assert.NotContains(t, c1.Nodes, id, "Random IDs should be used by default")
+		}
+	}
+}
+
+func TestSecureTreeIDSchemeMerge(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	doc := []byte(`{"template": {"version": 1, "sensors": ["temp", "humidity"]}}`)
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	c1.SetIDScheme(IDSchemePath)
+	_, err = c1.ImportJSON(doc, prvKey)
+	assert.Nil(t, err)
+
+	c2, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	c2.SetIDScheme(IDSchemePath)
+	_, err = c2.ImportJSON(doc, prvKey)
+	assert.Nil(t, err)
+
+	err = c1.Merge(c2, prvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.VerifyTree())
+	assert.Equal(t, jsonValue(t, string(doc)), exportedValue(t, c1))
+}
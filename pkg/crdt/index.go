@@ -0,0 +1,89 @@
+package crdt
+
+// edgeIndex maps every node to the edge from its parent and every (parent, label) pair to the edges with that
+// label, so looking up the parent of a node or a map member does not scan the tree. The edge operations keep
+// it up to date, bulk changes like Load and Tidy drop it and it is rebuilt on the next lookup.
+type edgeIndex struct {
+	parents map[NodeID]*EdgeCRDT
+	labels  map[NodeID]map[string][]*EdgeCRDT // Edges with the same label in edge order
+}
+
+// index returns the edge index of the tree, building it if needed
+func (c *TreeCRDT) index() *edgeIndex {
+	if c.edgeIndex == nil {
+		ix := &edgeIndex{
+			parents: make(map[NodeID]*EdgeCRDT, len(c.Nodes)),
+			labels:  make(map[NodeID]map[string][]*EdgeCRDT),
+		}
+		for _, node := range c.Nodes {
+			for _, edge := range node.Edges {
+				ix.add(edge)
+			}
+		}
+		c.edgeIndex = ix
+	}
+	return c.edgeIndex
+}
+
+// invalidateIndex drops the edge index after edges were changed without the edge operations, the secondary
+// indexes are rebuilt too
+func (c *TreeCRDT) invalidateIndex() {
+	c.edgeIndex = nil
+	c.invalidateValueIndexes()
+}
+
+// indexEdge records an edge that was added to the tree
+func (c *TreeCRDT) indexEdge(edge *EdgeCRDT) {
+	if c.edgeIndex != nil {
+		c.edgeIndex.add(edge)
+	}
+	c.valueIndexEdge(edge, true)
+}
+
+// unindexEdge forgets an edge that was removed from the tree
+func (c *TreeCRDT) unindexEdge(edge *EdgeCRDT) {
+	if c.edgeIndex != nil {
+		c.edgeIndex.remove(edge)
+	}
+	c.valueIndexEdge(edge, false)
+}
+
+func (ix *edgeIndex) add(edge *EdgeCRDT) {
+	ix.parents[edge.To] = edge
+	labels, ok := ix.labels[edge.From]
+	if !ok {
+		labels = make(map[string][]*EdgeCRDT)
+		ix.labels[edge.From] = labels
+	}
+	labels[edge.Label] = append(labels[edge.Label], edge)
+}
+
+func (ix *edgeIndex) remove(edge *EdgeCRDT) {
+	if ix.parents[edge.To] == edge {
+		delete(ix.parents, edge.To)
+	}
+	labels := ix.labels[edge.From]
+	edges := labels[edge.Label]
+	for i, e := range edges {
+		if e == edge {
+			edges = append(edges[:i:i], edges[i+1:]...)
+			break
+		}
+	}
+	if len(edges) == 0 {
+		delete(labels, edge.Label)
+	} else {
+		labels[edge.Label] = edges
+	}
+}
+
+// parentEdge returns the edge from the parent of a node
+func (c *TreeCRDT) parentEdge(id NodeID) (*EdgeCRDT, bool) {
+	edge, ok := c.index().parents[id]
+	return edge, ok
+}
+
+// labelEdges returns the edges of a node with the given label in edge order
+func (c *TreeCRDT) labelEdges(id NodeID, label string) []*EdgeCRDT {
+	return c.index().labels[id][label]
+}
@@ -0,0 +1,151 @@
+package crdt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertIndexConsistent checks that the maintained edge index equals an index rebuilt from the edges
+func assertIndexConsistent(t *testing.T, c *TreeCRDT) {
+	maintained := c.index()
+	c.invalidateIndex()
+	rebuilt := c.index()
+	assert.Equal(t, rebuilt.parents, maintained.parents)
+	assert.Equal(t, rebuilt.labels, maintained.labels)
+}
+
+func TestEdgeIndex(t *testing.T) {
+	identity, err := crypto.CreateIdendityFromString("d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6")
+	assert.Nil(t, err)
+
+	c := newTreeCRDT()
+	c.index() // Maintained from the start
+	_, err = c.ImportJSON([]byte(`{"a": {"b": [1, 2, 3]}, "c": {"d": "x"}}`), "client1")
+	assert.Nil(t, err)
+	assertIndexConsistent(t, c)
+
+	err = c.ApplyJSONPatch([]byte(`[
+		{"op": "add", "path": "/a/b/1", "value": 9},
+		{"op": "move", "from": "/c/d", "path": "/a/e"},
+		{"op": "remove", "path": "/a/b/0"}
+	]`), identity)
+	assert.Nil(t, err)
+	assertIndexConsistent(t, c)
+
+	e, err := c.GetNodeByPath("/a/e")
+	assert.Nil(t, err)
+	a, err := c.GetNodeByPath("/a")
+	assert.Nil(t, err)
+	edge, ok := c.parentEdge(e.ID)
+	assert.True(t, ok)
+	assert.Equal(t, a.ID, edge.From)
+	assert.Equal(t, "e", edge.Label)
+
+	// Attaching a node that has a parent or attaching a node below itself is rejected
+	b, err := c.GetNodeByPath("/a/b")
+	assert.Nil(t, err)
+	assert.NotNil(t, c.validAttachment(c.Root.ID, b.ID))
+	assert.NotNil(t, c.validAttachment(b.ID, a.ID))
+	assert.True(t, c.isDescendant(a.ID, b.ID))
+	assert.False(t, c.isDescendant(b.ID, a.ID))
+
+	// Tidy purges deleted nodes from the index
+	assert.Nil(t, c.Nodes[e.ID].MarkDeleted("client1"))
+	c.Tidy()
+	assertIndexConsistent(t, c)
+	_, ok = c.parentEdge(e.ID)
+	assert.False(t, ok)
+}
+
+func TestEdgeIndexMergeAndLoad(t *testing.T) {
+	identity, err := crypto.CreateIdendityFromString("d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6")
+	assert.Nil(t, err)
+
+	c1 := newTreeCRDT()
+	_, err = c1.ImportJSON([]byte(`{"list": [1, 2], "obj": {"k": "v"}}`), "client1")
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	assertIndexConsistent(t, c2)
+
+	err = c1.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/list/-", "value": 3}]`), identity)
+	assert.Nil(t, err)
+	err = c2.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/obj/k2", "value": "w"}, {"op": "add", "path": "/list/0", "value": 0}]`), identity)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c1.Merge(c2))
+	assertIndexConsistent(t, c1)
+	exported, err := c1.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"list": [0, 1, 2, 3], "obj": {"k": "v", "k2": "w"}}`, string(exported))
+
+	node, err := c1.GetNodeByPath("/obj/k2")
+	assert.Nil(t, err)
+	assert.Equal(t, "w", node.LiteralValue)
+
+	saved, err := c1.Save()
+	assert.Nil(t, err)
+	c1.index()
+	assert.Nil(t, c1.Load(saved))
+	assertIndexConsistent(t, c1)
+	node, err = c1.GetNodeByPath("/obj/k2")
+	assert.Nil(t, err)
+	_, ok := c1.parentEdge(node.ID)
+	assert.True(t, ok)
+}
+
+// benchmarkDocument returns a JSON object with n members, each a small object, and an array with n items
+func benchmarkDocument(n int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"devices": {`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `"device%d": {"id": %d, "name": "sensor %d"}`, i, i, i)
+	}
+	sb.WriteString(`}, "readings": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `%d`, i)
+	}
+	sb.WriteString(`]}`)
+	return []byte(sb.String())
+}
+
+func BenchmarkImportJSON(b *testing.B) {
+	for _, n := range []int{1000, 2000, 4000, 8000} {
+		doc := benchmarkDocument(n)
+		b.Run(fmt.Sprintf("members=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := newTreeCRDT()
+				if _, err := c.ImportJSON(doc, "client1"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, n := range []int{1000, 2000, 4000, 8000} {
+		remote := newTreeCRDT()
+		if _, err := remote.ImportJSON(benchmarkDocument(n), "client1"); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("members=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := newTreeCRDT()
+				if err := c.Merge(remote); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package crdt
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
 )
 
 func (c *TreeCRDT) GetNodeByPath(path string) (*NodeCRDT, error) {
@@ -24,9 +27,9 @@ func (c *TreeCRDT) GetNodeByPath(path string) (*NodeCRDT, error) {
 		return nil, fmt.Errorf("path must start with a slash: %s", path)
 	}
 
-	parts := strings.Split(path, "/")[1:]
-	if len(parts) == 0 {
-		return nil, fmt.Errorf("path is empty")
+	parts, err := splitJSONPointer(path)
+	if err != nil {
+		return nil, err
 	}
 
 	node := c.Root
@@ -48,7 +51,7 @@ func (c *TreeCRDT) GetNodeByPath(path string) (*NodeCRDT, error) {
 				return nil, fmt.Errorf("invalid array index at '%s': %v", part, err)
 			}
 
-			edges := node.Edges
+			edges := (&jsonPatcher{tree: c}).children(node) // Visible items in LSEQ order, like ExportJSON
 			if index < 0 || index >= len(edges) {
 				return nil, fmt.Errorf("array index out of bounds at '%s'", part)
 			}
@@ -61,21 +64,15 @@ func (c *TreeCRDT) GetNodeByPath(path string) (*NodeCRDT, error) {
 			node = child
 
 		} else {
-			found := false
-			for _, edge := range node.Edges {
-				if edge.Label == part {
-					child, exists := c.Nodes[edge.To]
-					if !exists {
-						return nil, fmt.Errorf("node %s for key '%s' not found", edge.To, part)
-					}
-					node = child
-					found = true
-					break
-				}
-			}
-			if !found {
+			edges := c.labelEdges(node.ID, part)
+			if len(edges) == 0 {
 				return nil, fmt.Errorf("path not found at '%s'", part)
 			}
+			child, exists := c.Nodes[edges[0].To]
+			if !exists {
+				return nil, fmt.Errorf("node %s for key '%s' not found", edges[0].To, part)
+			}
+			node = child
 		}
 	}
 
@@ -90,10 +87,11 @@ func (c *TreeCRDT) GetValueByPath(path string) (interface{}, error) {
 	if !node.IsLiteral {
 		return nil, fmt.Errorf("node at path '%s' is not a literal", path)
 	}
-	if node.LiteralValue == nil {
+	value := node.effectiveValue()
+	if value == nil {
 		return nil, fmt.Errorf("node at path '%s' has no value", path)
 	}
-	return node.LiteralValue, nil
+	return c.decryptLiteral(value)
 }
 
 func (c *TreeCRDT) GetStringValueByPath(path string) (string, error) {
@@ -106,3 +104,204 @@ func (c *TreeCRDT) GetStringValueByPath(path string) (string, error) {
 	}
 	return "", fmt.Errorf("value at path '%s' is not a string", path)
 }
+
+// The write APIs below are applied as single JSON patch operations, so they follow the same rules as
+// ApplyJSONPatch: values are any JSON compatible Go value, changed nodes are signed by the identity and
+// array indexes count the visible items. They return the ID of the node at the path after the write.
+
+// SetByPath sets the member of a map, adding it if missing, or replaces an existing array item
+func (c *TreeCRDT) SetByPath(path string, value interface{}, identity *crypto.Idendity) (NodeID, error) {
+	return c.setByPath(path, value, identity, nil)
+}
+
+// InsertByPath inserts a value into an array before the index, "-" appends it
+func (c *TreeCRDT) InsertByPath(path string, value interface{}, identity *crypto.Idendity) (NodeID, error) {
+	return c.insertByPath(path, value, identity, nil)
+}
+
+// DeleteByPath removes a map member or array item
+func (c *TreeCRDT) DeleteByPath(path string, identity *crypto.Idendity) error {
+	return c.deleteByPath(path, identity, nil)
+}
+
+// EnsurePath creates the missing maps along the path, existing values are kept
+func (c *TreeCRDT) EnsurePath(path string, identity *crypto.Idendity) (NodeID, error) {
+	return c.ensurePath(path, identity, nil)
+}
+
+func (c *TreeCRDT) setByPath(path string, value interface{}, identity *crypto.Idendity, check patchCheck) (NodeID, error) {
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return "", err
+	}
+
+	op := "add"
+	if len(tokens) == 0 {
+		op = "replace"
+	} else {
+		parent, err := (&jsonPatcher{tree: c}).resolve(tokens[:len(tokens)-1])
+		if err != nil {
+			return "", err
+		}
+		if parent.IsArray {
+			if tokens[len(tokens)-1] == "-" {
+				return "", fmt.Errorf("cannot set %s, use InsertByPath to append to an array", path)
+			}
+			op = "replace"
+		}
+	}
+
+	if err := c.writeByPath(op, path, value, identity, check); err != nil {
+		return "", err
+	}
+	return c.nodeIDByPointer(tokens)
+}
+
+func (c *TreeCRDT) insertByPath(path string, value interface{}, identity *crypto.Idendity, check patchCheck) (NodeID, error) {
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("cannot insert at the document root")
+	}
+
+	p := &jsonPatcher{tree: c}
+	parent, err := p.resolve(tokens[:len(tokens)-1])
+	if err != nil {
+		return "", err
+	}
+	if !parent.IsArray {
+		return "", fmt.Errorf("cannot insert at %s, parent is not an array", path)
+	}
+
+	if err := c.writeByPath("add", path, value, identity, check); err != nil {
+		return "", err
+	}
+	if tokens[len(tokens)-1] == "-" {
+		tokens[len(tokens)-1] = strconv.Itoa(len(p.children(parent)) - 1)
+	}
+	return c.nodeIDByPointer(tokens)
+}
+
+func (c *TreeCRDT) deleteByPath(path string, identity *crypto.Idendity, check patchCheck) error {
+	return c.applyPatch([]*JSONPatchOperation{{Op: "remove", Path: path}}, identity, check)
+}
+
+func (c *TreeCRDT) ensurePath(path string, identity *crypto.Idendity, check patchCheck) (NodeID, error) {
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Find the longest existing prefix, the rest is added as nested maps in one operation
+	p := &jsonPatcher{tree: c}
+	node, err := p.document()
+	if err != nil {
+		return "", err
+	}
+	existing := 0
+	for node != nil && existing < len(tokens) {
+		if node.IsLiteral {
+			return "", fmt.Errorf("cannot ensure %s, /%s is not a map or an array", path, strings.Join(escapeTokens(tokens[:existing]), "/"))
+		}
+		child, ok, err := p.child(node, tokens[existing])
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			break
+		}
+		node = child
+		existing++
+	}
+
+	if node == nil || existing < len(tokens) {
+		var value interface{} = map[string]interface{}{}
+		for i := len(tokens) - 1; i > existing; i-- {
+			value = map[string]interface{}{tokens[i]: value}
+		}
+		op, target := "add", "/"+strings.Join(escapeTokens(tokens[:existing+1]), "/")
+		if node == nil {
+			op, target = "replace", "" // Empty document
+			if len(tokens) > 0 {
+				value = map[string]interface{}{tokens[0]: value}
+			}
+		}
+		if err := c.writeByPath(op, target, value, identity, check); err != nil {
+			return "", err
+		}
+	}
+
+	return c.nodeIDByPointer(tokens)
+}
+
+func (c *TreeCRDT) writeByPath(op string, path string, value interface{}, identity *crypto.Idendity, check patchCheck) error {
+	// Round trip through JSON, so structs and typed maps and slices become plain JSON values
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal value at %s: %w", path, err)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(raw, &plain); err != nil {
+		return fmt.Errorf("Failed to unmarshal value at %s: %w", path, err)
+	}
+	return c.applyPatch([]*JSONPatchOperation{{Op: op, Path: path, Value: raw, value: plain}}, identity, check)
+}
+
+func (c *TreeCRDT) nodeIDByPointer(tokens []string) (NodeID, error) {
+	node, err := (&jsonPatcher{tree: c}).resolve(tokens)
+	if err != nil {
+		return "", err
+	}
+	return node.ID, nil
+}
+
+func escapeTokens(tokens []string) []string {
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		escaped[i] = escapeJSONPointer(token)
+	}
+	return escaped
+}
+
+// splitJSONPointer splits an RFC 6901 pointer into unescaped reference tokens, "" refers to the whole document
+func splitJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON pointer must be empty or start with a slash: %s", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, fmt.Errorf("invalid escape sequence in JSON pointer: %s", pointer)
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// escapeJSONPointer escapes a reference token, ~ becomes ~0 and / becomes ~1
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// arrayIndex parses an array index token, leading zeros are not allowed
+func arrayIndex(token string, length int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.Trim(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if index >= length {
+		return 0, fmt.Errorf("array index %d out of bounds, length %d", index, length)
+	}
+	return index, nil
+}
@@ -91,3 +91,83 @@ func TestTreeCRDTGetByPathArray(t *testing.T) {
 	assert.True(t, node3.IsLiteral, "Node should be a literal")
 	assert.True(t, node3.LiteralValue.(float64) == 3, "Node value should be 3")
 }
+
+func TestTreeCRDTGetByPathEscaped(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a/b": {"c~d": "x"}, "list": [1, 2, 3]}`), "client1")
+	assert.Nil(t, err)
+
+	value, err := c.GetStringValueByPath("/a~1b/c~0d")
+	assert.Nil(t, err)
+	assert.Equal(t, "x", value)
+
+	_, err = c.GetNodeByPath("/a~2b")
+	assert.NotNil(t, err, "Invalid escape sequences should be rejected")
+
+	// Removed items are not counted
+	list, err := c.GetNodeByPath("/list")
+	assert.Nil(t, err)
+	first, err := c.GetNodeByPath("/list/0")
+	assert.Nil(t, err)
+	err = c.RemoveEdge(list.ID, first.ID, "client1")
+	assert.Nil(t, err)
+	value2, err := c.GetValueByPath("/list/0")
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, value2)
+}
+
+func TestSecureTreeWriteByPath(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+
+	// EnsurePath creates the document and the intermediate maps
+	id, err := c.EnsurePath("/config/net~1eth0", prvKey)
+	assert.Nil(t, err)
+	node, err := c.GetNodeByPath("/config/net~1eth0")
+	assert.Nil(t, err)
+	assert.Equal(t, id, node.ID())
+
+	_, err = c.SetByPath("/config/net~1eth0/mtu", 1500, prvKey)
+	assert.Nil(t, err)
+	_, err = c.SetByPath("/config/tags", []string{"a", "c"}, prvKey)
+	assert.Nil(t, err)
+	_, err = c.InsertByPath("/config/tags/1", "b", prvKey)
+	assert.Nil(t, err)
+	id, err = c.InsertByPath("/config/tags/-", "d", prvKey)
+	assert.Nil(t, err)
+	node, err = c.GetNodeByPath("/config/tags/3")
+	assert.Nil(t, err)
+	assert.Equal(t, id, node.ID())
+	_, err = c.SetByPath("/config/tags/0", "A", prvKey)
+	assert.Nil(t, err)
+
+	// EnsurePath keeps existing values
+	_, err = c.EnsurePath("/config/net~1eth0", prvKey)
+	assert.Nil(t, err)
+
+	err = c.DeleteByPath("/config/tags/3", prvKey)
+	assert.Nil(t, err)
+
+	exported, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"config": {"net/eth0": {"mtu": 1500}, "tags": ["A", "b", "c"]}}`, string(exported))
+
+	_, err = c.SetByPath("/config/tags/-", "e", prvKey)
+	assert.NotNil(t, err, "Appending needs InsertByPath")
+	_, err = c.InsertByPath("/config/x", "e", prvKey)
+	assert.NotNil(t, err, "Inserting needs an array")
+	_, err = c.EnsurePath("/config/net~1eth0/mtu/x", prvKey)
+	assert.NotNil(t, err, "Literals cannot get members")
+
+	// Writes are checked against the ABAC policy
+	_, err = c.SetByPath("/config/tags/0", "B", prvKey2)
+	assert.NotNil(t, err)
+	err = c.DeleteByPath("/config", prvKey2)
+	assert.NotNil(t, err)
+
+	c.(*AdapterSecureTreeCRDT).treeCrdt.Tidy()
+	assert.Nil(t, c.VerifyTree())
+}
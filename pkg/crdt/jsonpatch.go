@@ -0,0 +1,690 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// JSON Patch (RFC 6902) operates on the exported JSON view of the tree, deleted nodes are not visible.
+// Setting a scalar on an existing literal keeps the node, so concurrent updates resolve with last writer
+// wins, other values are added as new nodes. move copies the value to new nodes and marks the old node deleted.
+// Removed and replaced nodes are unlinked like with RemoveEdge, call Tidy to drop them.
+// A patch is atomic, it is applied to a clone first and only applied to the tree if every operation succeeds.
+
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	value interface{}
+}
+
+// patchCheck authorizes a change of the target node, used by the secure adapter to enforce ABAC
+type patchCheck func(tree *TreeCRDT, action ABACAction, target NodeID) error
+
+type jsonPatcher struct {
+	tree     *TreeCRDT
+	identity *crypto.Idendity
+	clientID ClientID
+	check    patchCheck
+}
+
+func (c *TreeCRDT) ApplyJSONPatch(patch []byte, identity *crypto.Idendity) error {
+	return c.applyJSONPatch(patch, identity, nil)
+}
+
+func (c *TreeCRDT) applyJSONPatch(patch []byte, identity *crypto.Idendity, check patchCheck) error {
+	ops, err := parseJSONPatch(patch)
+	if err != nil {
+		return err
+	}
+	return c.applyPatch(ops, identity, check)
+}
+
+// applyPatch applies parsed operations atomically, they must not add violations of the attached schemas
+func (c *TreeCRDT) applyPatch(ops []*JSONPatchOperation, identity *crypto.Idendity, check patchCheck) error {
+	trial, err := c.Clone()
+	if err != nil {
+		return fmt.Errorf("Failed to clone tree: %w", err)
+	}
+	if err := trial.applyOperations(ops, identity, check); err != nil {
+		return err
+	}
+	if err := c.checkSchemaChange(trial); err != nil {
+		return err
+	}
+	if err := c.applyOperations(ops, identity, check); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"Operations": len(ops), "ClientID": identity.ID()}).Debug("JSON patch applied")
+
+	return nil
+}
+
+func parseJSONPatch(patch []byte) ([]*JSONPatchOperation, error) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &raw); err != nil {
+		return nil, fmt.Errorf("Failed to parse JSON patch: %w", err)
+	}
+
+	ops := make([]*JSONPatchOperation, len(raw))
+	for i, fields := range raw {
+		op := &JSONPatchOperation{}
+		if err := remarshal(fields, op); err != nil {
+			return nil, fmt.Errorf("Failed to parse JSON patch operation %d: %w", i, err)
+		}
+		if _, ok := fields["path"]; !ok {
+			return nil, fmt.Errorf("JSON patch operation %d: missing path", i)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if _, ok := fields["value"]; !ok {
+				return nil, fmt.Errorf("JSON patch operation %d (%s): missing value", i, op.Op)
+			}
+			if err := json.Unmarshal(op.Value, &op.value); err != nil {
+				return nil, fmt.Errorf("JSON patch operation %d (%s): invalid value: %w", i, op.Op, err)
+			}
+		case "move", "copy":
+			if _, ok := fields["from"]; !ok {
+				return nil, fmt.Errorf("JSON patch operation %d (%s): missing from", i, op.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("JSON patch operation %d: unknown op '%s'", i, op.Op)
+		}
+		ops[i] = op
+	}
+
+	return ops, nil
+}
+
+func (c *TreeCRDT) applyOperations(ops []*JSONPatchOperation, identity *crypto.Idendity, check patchCheck) error {
+	p := &jsonPatcher{tree: c, identity: identity, clientID: ClientID(identity.ID()), check: check}
+	for i, op := range ops {
+		if err := p.apply(op); err != nil {
+			return fmt.Errorf("JSON patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	c.normalize()
+	return nil
+}
+
+func (p *jsonPatcher) apply(op *JSONPatchOperation) error {
+	tokens, err := splitJSONPointer(op.Path)
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case "add":
+		return p.set(tokens, op.value, false)
+	case "replace":
+		return p.set(tokens, op.value, true)
+	case "remove":
+		return p.remove(tokens)
+	case "move":
+		if op.From == op.Path {
+			return nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return fmt.Errorf("cannot move %s into one of its children", op.From)
+		}
+		fromTokens, err := splitJSONPointer(op.From)
+		if err != nil {
+			return err
+		}
+		return p.move(fromTokens, tokens)
+	case "copy":
+		fromTokens, err := splitJSONPointer(op.From)
+		if err != nil {
+			return err
+		}
+		node, err := p.resolve(fromTokens)
+		if err != nil {
+			return err
+		}
+		value, err := p.value(node)
+		if err != nil {
+			return err
+		}
+		return p.set(tokens, value, false)
+	case "test":
+		node, err := p.resolve(tokens)
+		if err != nil {
+			return err
+		}
+		value, err := p.value(node)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(value, op.value) {
+			return errors.New("test failed, value is not equal")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown op '%s'", op.Op)
+}
+
+// document returns the node holding the top level value, or nil if the tree is empty
+func (p *jsonPatcher) document() (*NodeCRDT, error) {
+	root := p.tree.Root
+	switch len(root.Edges) {
+	case 0:
+		return nil, nil
+	case 1:
+		node, ok := p.tree.Nodes[root.Edges[0].To]
+		if !ok {
+			return nil, fmt.Errorf("invalid CRDT: root child %s not found", root.Edges[0].To)
+		}
+		return node, nil
+	}
+	return nil, errors.New("trees with several top level values are not supported")
+}
+
+// children returns the edges of the visible children, in order for arrays
+func (p *jsonPatcher) children(node *NodeCRDT) []*EdgeCRDT {
+	edges := make([]*EdgeCRDT, 0, len(node.Edges))
+	for _, edge := range node.Edges {
+		if child, ok := p.tree.Nodes[edge.To]; ok && !child.IsDeleted {
+			edges = append(edges, edge)
+		}
+	}
+	if node.IsArray {
+		sortEdgesByLSEQ(edges)
+	}
+	return edges
+}
+
+func (p *jsonPatcher) child(node *NodeCRDT, token string) (*NodeCRDT, bool, error) {
+	switch {
+	case node.IsArray:
+		edges := p.children(node)
+		index, err := arrayIndex(token, len(edges))
+		if err != nil {
+			return nil, false, err
+		}
+		return p.tree.Nodes[edges[index].To], true, nil
+	case node.IsMap:
+		for _, edge := range p.tree.labelEdges(node.ID, token) {
+			if child, ok := p.tree.Nodes[edge.To]; ok && !child.IsDeleted {
+				return child, true, nil
+			}
+		}
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("node %s is a literal", node.ID)
+}
+
+func (p *jsonPatcher) resolve(tokens []string) (*NodeCRDT, error) {
+	node, err := p.document()
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, errors.New("document is empty")
+	}
+
+	for _, token := range tokens {
+		child, ok, err := p.child(node, token)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("path not found at '%s'", token)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+func (p *jsonPatcher) value(node *NodeCRDT) (interface{}, error) {
+	exported, err := p.tree.exportNodeOrdered(node.ID, make(map[NodeID]bool))
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := remarshal(exported, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (p *jsonPatcher) allow(target NodeID) error {
+	if p.check == nil {
+		return nil
+	}
+	return p.check(p.tree, ActionModify, target)
+}
+
+// allowReplace checks that a node can be replaced or removed, literals protected by an approval rule at or
+// below it can only be changed by proposals
+func (p *jsonPatcher) allowReplace(id NodeID) error {
+	if p.check == nil {
+		return nil
+	}
+	if protected, ok := p.tree.protectedSubtree(id); ok {
+		return fmt.Errorf("%s is protected by an approval rule, changes must be proposed and approved", protected)
+	}
+	return nil
+}
+
+// sign signs the nodes modified by the identity
+func (p *jsonPatcher) sign(nodes ...*NodeCRDT) error {
+	for _, node := range nodes {
+		if node.Owner != p.clientID {
+			continue
+		}
+		if err := node.Sign(p.identity); err != nil {
+			return fmt.Errorf("failed to sign node: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *jsonPatcher) set(tokens []string, value interface{}, replace bool) error {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		if len(tokens) > 0 {
+			// Update an existing literal in place
+			parent, err := p.resolve(tokens[:len(tokens)-1])
+			if err != nil {
+				return err
+			}
+			key := tokens[len(tokens)-1]
+			if parent.IsMap || (parent.IsArray && replace) {
+				node, ok, err := p.child(parent, key)
+				if err != nil {
+					return err
+				}
+				if ok && node.IsLiteral {
+					if err := p.allow(parent.ID); err != nil {
+						return err
+					}
+					if err := p.allowReplace(node.ID); err != nil {
+						return err
+					}
+					if parent.IsMap {
+						if _, err := parent.SetKeyValue(key, value, p.clientID); err != nil {
+							return err
+						}
+					} else if err := node.SetLiteral(value, p.clientID); err != nil {
+						return err
+					}
+					return p.sign(node, parent)
+				}
+			}
+		}
+	}
+
+	// Validate the target before creating nodes
+	if len(tokens) > 0 {
+		if _, err := p.resolve(tokens[:len(tokens)-1]); err != nil {
+			return err
+		}
+	}
+
+	nodeID, err := p.tree.secureImportRecursive(value, nil, "", -1, Literal, p.clientID, true, p.identity)
+	if err != nil {
+		return err
+	}
+	return p.attach(tokens, nodeID, replace)
+}
+
+// attach places a detached node at the position, an existing member of a map or the replaced array item is removed
+func (p *jsonPatcher) attach(tokens []string, nodeID NodeID, replace bool) error {
+	if len(tokens) == 0 {
+		root := p.tree.Root
+		if err := p.allow(root.ID); err != nil {
+			return err
+		}
+		for _, edge := range root.Edges {
+			if err := p.allowReplace(edge.To); err != nil {
+				return err
+			}
+		}
+		for _, edge := range append([]*EdgeCRDT{}, root.Edges...) {
+			if err := p.tree.RemoveEdge(root.ID, edge.To, p.clientID); err != nil {
+				return err
+			}
+		}
+		if err := p.tree.AddEdge(root.ID, nodeID, "", p.clientID); err != nil {
+			return err
+		}
+		return p.sign(root)
+	}
+
+	parent, err := p.resolve(tokens[:len(tokens)-1])
+	if err != nil {
+		return err
+	}
+	key := tokens[len(tokens)-1]
+	if err := p.allow(parent.ID); err != nil {
+		return err
+	}
+
+	switch {
+	case parent.IsMap:
+		existing, ok, err := p.child(parent, key)
+		if err != nil {
+			return err
+		}
+		if replace && !ok {
+			return fmt.Errorf("path not found at '%s'", key)
+		}
+		if ok {
+			if err := p.allowReplace(existing.ID); err != nil {
+				return err
+			}
+			if err := p.tree.RemoveEdge(parent.ID, existing.ID, p.clientID); err != nil {
+				return err
+			}
+		}
+		if err := p.tree.AddEdge(parent.ID, nodeID, key, p.clientID); err != nil {
+			return err
+		}
+
+	case parent.IsArray:
+		edges := p.children(parent)
+		if replace {
+			index, err := arrayIndex(key, len(edges))
+			if err != nil {
+				return err
+			}
+			old := edges[index].To
+			if err := p.allowReplace(old); err != nil {
+				return err
+			}
+			if err := p.tree.InsertEdgeLeft(parent.ID, nodeID, "", old, p.clientID); err != nil {
+				return err
+			}
+			if err := p.tree.RemoveEdge(parent.ID, old, p.clientID); err != nil {
+				return err
+			}
+			break
+		}
+
+		index := len(edges)
+		if key != "-" {
+			if index, err = arrayIndex(key, len(edges)+1); err != nil {
+				return err
+			}
+		}
+		if index == len(edges) {
+			err = p.tree.AppendEdge(parent.ID, nodeID, "", p.clientID)
+		} else {
+			err = p.tree.InsertEdgeLeft(parent.ID, nodeID, "", edges[index].To, p.clientID)
+		}
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("node %s is a literal", parent.ID)
+	}
+
+	return p.sign(parent)
+}
+
+func (p *jsonPatcher) detach(tokens []string) (*NodeCRDT, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	parent, err := p.resolve(tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := tokens[len(tokens)-1]
+	node, ok, err := p.child(parent, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("path not found at '%s'", key)
+	}
+	if err := p.allow(parent.ID); err != nil {
+		return nil, err
+	}
+	if err := p.allowReplace(node.ID); err != nil {
+		return nil, err
+	}
+
+	if err := p.tree.RemoveEdge(parent.ID, node.ID, p.clientID); err != nil {
+		return nil, err
+	}
+	return node, p.sign(parent)
+}
+
+func (p *jsonPatcher) remove(tokens []string) error {
+	_, err := p.detach(tokens)
+	return err
+}
+
+// move copies the value to new nodes and marks the old node deleted. Re-attaching the node would give it
+// two parents once merged with a replica that still has it at the old position, and merges do not remove
+// edges, so the deleted node stays linked until Tidy.
+func (p *jsonPatcher) move(from []string, to []string) error {
+	if len(from) == 0 {
+		return errors.New("cannot move the whole document")
+	}
+	parent, err := p.resolve(from[:len(from)-1])
+	if err != nil {
+		return err
+	}
+	node, ok, err := p.child(parent, from[len(from)-1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("path not found at '%s'", from[len(from)-1])
+	}
+	if err := p.allow(parent.ID); err != nil {
+		return err
+	}
+	if err := p.allowReplace(node.ID); err != nil {
+		return err
+	}
+
+	value, err := p.value(node)
+	if err != nil {
+		return err
+	}
+	if err := node.MarkDeleted(p.clientID); err != nil {
+		return err
+	}
+	if err := p.sign(node); err != nil {
+		return err
+	}
+	return p.set(to, value, false)
+}
+
+func (c *TreeCRDT) DiffAsJSONPatch(other *TreeCRDT) ([]byte, error) {
+	from, err := c.documentValue()
+	if err != nil {
+		return nil, err
+	}
+	to, err := other.documentValue()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := diffJSON("", from, to, make([]*JSONPatchOperation, 0))
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(ops, "", "  ")
+}
+
+func (c *TreeCRDT) documentValue() (interface{}, error) {
+	if len(c.Root.Edges) == 0 {
+		return nil, nil
+	}
+	exported, err := c.export()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := remarshal(exported, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func diffJSON(path string, from, to interface{}, ops []*JSONPatchOperation) ([]*JSONPatchOperation, error) {
+	switch fromVal := from.(type) {
+	case map[string]interface{}:
+		if toVal, ok := to.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(fromVal))
+			for key := range fromVal {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			var err error
+			for _, key := range keys {
+				childPath := path + "/" + escapeJSONPointer(key)
+				if toChild, ok := toVal[key]; ok {
+					if ops, err = diffJSON(childPath, fromVal[key], toChild, ops); err != nil {
+						return nil, err
+					}
+				} else {
+					ops = append(ops, &JSONPatchOperation{Op: "remove", Path: childPath})
+				}
+			}
+
+			added := make([]string, 0)
+			for key := range toVal {
+				if _, ok := fromVal[key]; !ok {
+					added = append(added, key)
+				}
+			}
+			sort.Strings(added)
+			for _, key := range added {
+				if ops, err = appendValueOp(ops, "add", path+"/"+escapeJSONPointer(key), toVal[key]); err != nil {
+					return nil, err
+				}
+			}
+			return ops, nil
+		}
+
+	case []interface{}:
+		if toVal, ok := to.([]interface{}); ok {
+			return diffArray(path, fromVal, toVal, ops)
+		}
+
+	default:
+		if reflect.DeepEqual(from, to) {
+			return ops, nil
+		}
+	}
+
+	return appendValueOp(ops, "replace", path, to)
+}
+
+// diffArray keeps the longest common subsequence of equal items, other items are diffed in place where
+// possible so nodes of edited items are kept, and the rest is removed or added
+func diffArray(path string, from, to []interface{}, ops []*JSONPatchOperation) ([]*JSONPatchOperation, error) {
+	matches := append(commonItems(from, to), [2]int{len(from), len(to)})
+
+	var err error
+	index, i, j := 0, 0, 0 // index is the position in the array after the operations so far
+	for n, match := range matches {
+		removed, added := match[0]-i, match[1]-j
+		paired := removed
+		if added < paired {
+			paired = added
+		}
+
+		for k := 0; k < paired; k++ {
+			if ops, err = diffJSON(path+"/"+strconv.Itoa(index), from[i+k], to[j+k], ops); err != nil {
+				return nil, err
+			}
+			index++
+		}
+		for k := paired; k < removed; k++ {
+			ops = append(ops, &JSONPatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(index)})
+		}
+		for k := paired; k < added; k++ {
+			if ops, err = appendValueOp(ops, "add", path+"/"+strconv.Itoa(index), to[j+k]); err != nil {
+				return nil, err
+			}
+			index++
+		}
+
+		if n < len(matches)-1 {
+			index++ // The matched item is kept
+		}
+		i, j = match[0]+1, match[1]+1
+	}
+
+	return ops, nil
+}
+
+// commonItems returns the index pairs of the longest common subsequence of equal items
+func commonItems(from, to []interface{}) [][2]int {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && reflect.DeepEqual(from[prefix], to[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && reflect.DeepEqual(from[len(from)-1-suffix], to[len(to)-1-suffix]) {
+		suffix++
+	}
+
+	a, b := from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if reflect.DeepEqual(a[i], b[j]) {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	matches := make([][2]int, 0, prefix+suffix+lengths[0][0])
+	for i := 0; i < prefix; i++ {
+		matches = append(matches, [2]int{i, i})
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if reflect.DeepEqual(a[i], b[j]) {
+			matches = append(matches, [2]int{prefix + i, prefix + j})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	for k := suffix; k > 0; k-- {
+		matches = append(matches, [2]int{len(from) - k, len(to) - k})
+	}
+	return matches
+}
+
+func appendValueOp(ops []*JSONPatchOperation, op string, path string, value interface{}) ([]*JSONPatchOperation, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal value at %s: %w", path, err)
+	}
+	return append(ops, &JSONPatchOperation{Op: op, Path: path, Value: raw, value: value}), nil
+}
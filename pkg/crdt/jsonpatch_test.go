@@ -0,0 +1,230 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportedValue(t *testing.T, c SecureTree) interface{} {
+	data, err := c.ExportJSON()
+	assert.Nil(t, err)
+	var value interface{}
+	assert.Nil(t, json.Unmarshal(data, &value))
+	return value
+}
+
+func jsonValue(t *testing.T, data string) interface{} {
+	var value interface{}
+	assert.Nil(t, json.Unmarshal([]byte(data), &value))
+	return value
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"Add member", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"foo": "bar", "baz": "qux"}`},
+		{"Add array item", `{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{"Append array item", `{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": {"a": 1}}]`, `{"foo": ["bar", {"a": 1}]}`},
+		{"Add replaces member", `{"foo": {"a": 1}}`, `[{"op": "add", "path": "/foo", "value": [1, 2]}]`, `{"foo": [1, 2]}`},
+		{"Remove member", `{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`},
+		{"Remove array item", `{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{"Replace value", `{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`},
+		{"Replace array item", `{"foo": [1, 2, 3]}`, `[{"op": "replace", "path": "/foo/1", "value": {"x": null}}]`, `{"foo": [1, {"x": null}, 3]}`},
+		{"Move member", `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{"Move array item", `{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{"Copy", `{"foo": {"a": [1]}}`, `[{"op": "copy", "from": "/foo", "path": "/bar"}]`, `{"foo": {"a": [1]}, "bar": {"a": [1]}}`},
+		{"Escaped keys", `{"a/b": 1, "m~n": 2}`, `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`, `{"a/b": 3}`},
+		{"Test then replace", `{"foo": {"a": [1, "x"]}}`, `[{"op": "test", "path": "/foo", "value": {"a": [1, "x"]}}, {"op": "replace", "path": "", "value": [true]}]`, `[true]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewSecureTree(prvKey)
+			assert.Nil(t, err)
+			_, err = c.ImportJSON([]byte(test.doc), prvKey)
+			assert.Nil(t, err)
+
+			err = c.ApplyJSONPatch([]byte(test.patch), prvKey)
+			assert.Nil(t, err, "ApplyJSONPatch should not return an error")
+			assert.Equal(t, jsonValue(t, test.expected), exportedValue(t, c))
+
+			c.Tidy() // Remove unlinked nodes
+			assert.Nil(t, c.VerifyTree(), "Patched nodes should be signed")
+		})
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	doc := `{"foo": ["bar"], "baz": {"qux": 1}}`
+
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"Invalid JSON", `[{"op": "add"`},
+		{"Unknown op", `[{"op": "merge", "path": "/foo"}]`},
+		{"Missing value", `[{"op": "add", "path": "/x"}]`},
+		{"Missing from", `[{"op": "move", "path": "/x"}]`},
+		{"Missing parent", `[{"op": "add", "path": "/x/y", "value": 1}]`},
+		{"Index out of bounds", `[{"op": "add", "path": "/foo/2", "value": 1}]`},
+		{"Leading zero", `[{"op": "replace", "path": "/foo/00", "value": 1}]`},
+		{"Replace missing member", `[{"op": "replace", "path": "/x", "value": 1}]`},
+		{"Remove missing member", `[{"op": "remove", "path": "/x"}]`},
+		{"Move into child", `[{"op": "move", "from": "/baz", "path": "/baz/qux/x"}]`},
+		{"Failed test is atomic", `[{"op": "add", "path": "/x", "value": 1}, {"op": "test", "path": "/baz/qux", "value": 2}]`},
+		{"Invalid escape", `[{"op": "remove", "path": "/baz~2"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewSecureTree(prvKey)
+			assert.Nil(t, err)
+			_, err = c.ImportJSON([]byte(doc), prvKey)
+			assert.Nil(t, err)
+
+			err = c.ApplyJSONPatch([]byte(test.patch), prvKey)
+			assert.NotNil(t, err)
+			assert.Equal(t, jsonValue(t, doc), exportedValue(t, c), "A failed patch should not change the tree")
+		})
+	}
+}
+
+func TestApplyJSONPatchABAC(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	other, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	otherPrvKey := other.PrivateKeyAsHex()
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"public": {"a": 1}, "private": {"b": 2}}`), prvKey)
+	assert.Nil(t, err)
+
+	public, err := c.GetNodeByPath("/public")
+	assert.Nil(t, err)
+	c.ABAC().Allow(other.ID(), ActionModify, public.ID(), true)
+
+	err = c.ApplyJSONPatch([]byte(`[{"op": "replace", "path": "/public/a", "value": 3}]`), otherPrvKey)
+	assert.Nil(t, err)
+
+	err = c.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/public/c", "value": 4}, {"op": "remove", "path": "/private/b"}]`), otherPrvKey)
+	assert.NotNil(t, err, "Changes outside the allowed subtree should be rejected")
+	assert.Contains(t, err.Error(), "not allowed")
+
+	assert.Equal(t, jsonValue(t, `{"public": {"a": 3}, "private": {"b": 2}}`), exportedValue(t, c))
+	c.Tidy()
+	assert.Nil(t, c.VerifyTree())
+}
+
+func TestApplyJSONPatchApproval(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	owner, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"config": {"limit": 10, "name": "test"}, "list": [{"limit": 1}]}`), prvKey)
+	assert.Nil(t, err)
+
+	for _, path := range []string{"/config/limit", "/list/0/limit"} {
+		node, err := c.GetNodeByPath(path)
+		assert.Nil(t, err)
+		assert.Nil(t, c.ABAC().RequireApproval(node.ID(), []string{owner.ID()}, 1, false))
+	}
+	before := exportedValue(t, c)
+
+	for _, patch := range []string{
+		`[{"op": "replace", "path": "/config/limit", "value": 99}]`,
+		`[{"op": "add", "path": "/config/limit", "value": {"nested": 99}}]`,
+		`[{"op": "remove", "path": "/config/limit"}]`,
+		`[{"op": "remove", "path": "/config"}]`,
+		`[{"op": "move", "from": "/config", "path": "/moved"}]`,
+		`[{"op": "replace", "path": "/list/0", "value": {"limit": 99}}]`,
+		`[{"op": "replace", "path": "", "value": {}}]`,
+	} {
+		err := c.ApplyJSONPatch([]byte(patch), prvKey)
+		assert.NotNil(t, err, "Patch should not change a protected literal: %s", patch)
+	}
+
+	_, err = c.SetByPath("/config/limit", 99, prvKey)
+	assert.NotNil(t, err)
+	assert.NotNil(t, c.DeleteByPath("/config/limit", prvKey))
+	_, err = c.ReconcileJSON("/config", []byte(`{"limit": 99, "name": "test"}`), prvKey)
+	assert.NotNil(t, err)
+	assert.Equal(t, before, exportedValue(t, c))
+
+	_, err = c.SetByPath("/config/name", "changed", prvKey)
+	assert.Nil(t, err, "Unprotected siblings can still be changed")
+	assert.Nil(t, c.VerifyTree())
+}
+
+func TestApplyJSONPatchMoveMerge(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"a": {"x": {"y": 1}}, "b": {}, "list": [1, 2, 3]}`), prvKey)
+	assert.Nil(t, err)
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	assert.Nil(t, c2.ApplyJSONPatch([]byte(`[
+		{"op": "move", "from": "/a/x", "path": "/b/x"},
+		{"op": "move", "from": "/list/0", "path": "/list/-"}
+	]`), prvKey))
+	expected := jsonValue(t, `{"a": {}, "b": {"x": {"y": 1}}, "list": [2, 3, 1]}`)
+	assert.Equal(t, expected, exportedValue(t, c2))
+
+	// The original replica still has the value at the old position
+	assert.Nil(t, c1.Merge(c2, prvKey))
+	assert.Equal(t, expected, exportedValue(t, c1))
+	assert.Nil(t, c1.VerifyTree())
+
+	data, err := c1.Save()
+	assert.Nil(t, err)
+	c3, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c3.Load(data), "Load should accept a tree merged with a move")
+	assert.Equal(t, expected, exportedValue(t, c3))
+	assert.Nil(t, c3.VerifyTree())
+}
+
+func TestDiffAsJSONPatch(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"name": "Alice", "tags": ["a", "b", "c"], "address": {"city": "Lund", "zip": "22100"}, "a/b": 1}`), prvKey)
+	assert.Nil(t, err)
+
+	c2, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	target := `{"name": "Bob", "tags": ["a", "x"], "address": {"city": "Lund", "street": {"no": 1}}, "age": 42, "a/b": [1]}`
+	_, err = c2.ImportJSON([]byte(target), prvKey)
+	assert.Nil(t, err)
+
+	patch, err := c1.DiffAsJSONPatch(c2)
+	assert.Nil(t, err)
+
+	var ops []*JSONPatchOperation
+	assert.Nil(t, json.Unmarshal(patch, &ops))
+	assert.Contains(t, string(patch), `"/a~1b"`)
+
+	err = c1.ApplyJSONPatch(patch, prvKey)
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(t, target), exportedValue(t, c1))
+
+	patch, err = c1.DiffAsJSONPatch(c2)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[]`, string(patch), "Equal trees should give an empty patch")
+}
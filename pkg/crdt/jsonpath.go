@@ -0,0 +1,1072 @@
+package crdt
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// JSONPath (RFC 9535) queries are evaluated directly on the nodes and edges of the tree. Array indexes count
+// the visible items in LSEQ order, like ExportJSON. With an identity, nodes the identity is not allowed to
+// read are left out of the result and are treated as missing by filters and functions.
+
+type QueryResult struct {
+	Pointer string      `json:"pointer"`
+	NodeID  NodeID      `json:"nodeID"`
+	Kind    string      `json:"kind"`  // literal, map or array
+	Value   interface{} `json:"value"` // Only set for literals
+}
+
+func (c *TreeCRDT) Query(expr string, identity *crypto.Idendity) ([]*QueryResult, error) {
+	query, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &jsonPathEvaluator{tree: c, patcher: &jsonPatcher{tree: c}, regexps: make(map[string]*regexp.Regexp)}
+	if identity != nil && c.ABACPolicy != nil {
+		if _, err := c.ABACPolicy.Verify(); err != nil {
+			return nil, fmt.Errorf("Failed to verify ABAC policy: %w", err)
+		}
+		id := identity.ID()
+		parents := c.parentMap()
+		isDescendant := func(root NodeID, target NodeID) bool {
+			return isDescendantInParentMap(parents, root, target)
+		}
+		e.readable = func(node *NodeCRDT) bool {
+			return c.ABACPolicy.isAllowed(id, ActionRead, node.ID, isDescendant)
+		}
+	}
+
+	doc, err := e.patcher.document()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*QueryResult, 0)
+	if doc == nil {
+		return results, nil
+	}
+	e.root = jsonPathNode{node: doc}
+
+	for _, n := range e.visible(e.query(query, e.root)) {
+		result := &QueryResult{Pointer: n.pointer, NodeID: n.node.ID}
+		switch {
+		case n.node.IsLiteral:
+			result.Kind = "literal"
+			result.Value = n.node.effectiveValue()
+		case n.node.IsArray:
+			result.Kind = "array"
+		default:
+			result.Kind = "map"
+		}
+		results = append(results, result)
+	}
+
+	log.WithFields(log.Fields{"Query": expr, "Results": len(results)}).Debug("JSONPath query evaluated")
+
+	return results, nil
+}
+
+// Syntax tree
+
+type jsonPathQuery struct {
+	relative bool // @ instead of $
+	segments []*jsonPathSegment
+}
+
+type jsonPathSegment struct {
+	descendant bool
+	selectors  []interface{}
+}
+
+type jsonPathName struct{ name string }
+type jsonPathWildcard struct{}
+type jsonPathIndex struct{ index int64 }
+type jsonPathSlice struct{ start, end, step *int64 }
+type jsonPathFilter struct{ expr interface{} }
+
+type jsonPathOr struct{ operands []interface{} }
+type jsonPathAnd struct{ operands []interface{} }
+type jsonPathNot struct{ operand interface{} }
+type jsonPathComparison struct {
+	op          string
+	left, right interface{}
+}
+type jsonPathLiteral struct{ value interface{} }
+type jsonPathFunction struct {
+	name string
+	args []interface{}
+}
+
+type jsonPathType int
+
+const (
+	jsonPathValueType jsonPathType = iota
+	jsonPathLogicalType
+	jsonPathNodesType
+)
+
+type jsonPathFunctionType struct {
+	params []jsonPathType
+	result jsonPathType
+}
+
+var jsonPathFunctions = map[string]jsonPathFunctionType{
+	"length": {[]jsonPathType{jsonPathValueType}, jsonPathValueType},
+	"count":  {[]jsonPathType{jsonPathNodesType}, jsonPathValueType},
+	"match":  {[]jsonPathType{jsonPathValueType, jsonPathValueType}, jsonPathLogicalType},
+	"search": {[]jsonPathType{jsonPathValueType, jsonPathValueType}, jsonPathLogicalType},
+	"value":  {[]jsonPathType{jsonPathNodesType}, jsonPathValueType},
+}
+
+const jsonPathMaxInt = 1<<53 - 1
+
+// Parser
+
+type jsonPathParser struct {
+	expr string
+	pos  int
+}
+
+func parseJSONPath(expr string) (*jsonPathQuery, error) {
+	p := &jsonPathParser{expr: expr}
+	if !p.consume("$") {
+		return nil, p.errorf("query must start with $")
+	}
+	query, err := p.segments(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos:])
+	}
+	return query, nil
+}
+
+func (p *jsonPathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid JSONPath at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jsonPathParser) peek() byte {
+	if p.pos < len(p.expr) {
+		return p.expr[p.pos]
+	}
+	return 0
+}
+
+func (p *jsonPathParser) consume(s string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *jsonPathParser) ws() {
+	for p.pos < len(p.expr) && strings.IndexByte(" \t\n\r", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *jsonPathParser) segments(relative bool) (*jsonPathQuery, error) {
+	query := &jsonPathQuery{relative: relative}
+	for {
+		start := p.pos
+		p.ws()
+		var segment *jsonPathSegment
+		var err error
+		switch {
+		case p.consume(".."):
+			segment, err = p.descendantSegment()
+		case p.consume("."):
+			segment, err = p.shorthandSegment()
+		case p.peek() == '[':
+			var selectors []interface{}
+			selectors, err = p.bracketedSelection()
+			segment = &jsonPathSegment{selectors: selectors}
+		default:
+			p.pos = start // The whitespace belongs to the enclosing expression
+			return query, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		query.segments = append(query.segments, segment)
+	}
+}
+
+func (p *jsonPathParser) descendantSegment() (*jsonPathSegment, error) {
+	if p.peek() == '[' {
+		selectors, err := p.bracketedSelection()
+		if err != nil {
+			return nil, err
+		}
+		return &jsonPathSegment{descendant: true, selectors: selectors}, nil
+	}
+	segment, err := p.shorthandSegment()
+	if err != nil {
+		return nil, err
+	}
+	segment.descendant = true
+	return segment, nil
+}
+
+func (p *jsonPathParser) shorthandSegment() (*jsonPathSegment, error) {
+	if p.consume("*") {
+		return &jsonPathSegment{selectors: []interface{}{jsonPathWildcard{}}}, nil
+	}
+	name := p.memberName()
+	if name == "" {
+		return nil, p.errorf("expected a member name or *")
+	}
+	return &jsonPathSegment{selectors: []interface{}{jsonPathName{name}}}, nil
+}
+
+func (p *jsonPathParser) memberName() string {
+	start := p.pos
+	for p.pos < len(p.expr) {
+		r, size := utf8.DecodeRuneInString(p.expr[p.pos:])
+		first := r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= 0x80 && r != utf8.RuneError)
+		if !first && !(p.pos > start && r >= '0' && r <= '9') {
+			break
+		}
+		p.pos += size
+	}
+	return p.expr[start:p.pos]
+}
+
+func (p *jsonPathParser) bracketedSelection() ([]interface{}, error) {
+	p.pos++ // [
+	selectors := make([]interface{}, 0)
+	for {
+		p.ws()
+		selector, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+		p.ws()
+		if p.consume(",") {
+			continue
+		}
+		if p.consume("]") {
+			return selectors, nil
+		}
+		return nil, p.errorf("expected , or ]")
+	}
+}
+
+func (p *jsonPathParser) selector() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return jsonPathName{name}, nil
+	case c == '*':
+		p.pos++
+		return jsonPathWildcard{}, nil
+	case c == '?':
+		p.pos++
+		p.ws()
+		expr, err := p.logicalOr()
+		if err != nil {
+			return nil, err
+		}
+		return jsonPathFilter{expr}, nil
+	case c == '-' || c == ':' || (c >= '0' && c <= '9'):
+		return p.indexOrSlice()
+	}
+	return nil, p.errorf("expected a selector")
+}
+
+func (p *jsonPathParser) indexOrSlice() (interface{}, error) {
+	start, err := p.optionalInteger()
+	if err != nil {
+		return nil, err
+	}
+	p.ws()
+	if !p.consume(":") {
+		if start == nil {
+			return nil, p.errorf("expected an index")
+		}
+		return jsonPathIndex{*start}, nil
+	}
+
+	slice := jsonPathSlice{start: start}
+	p.ws()
+	if slice.end, err = p.optionalInteger(); err != nil {
+		return nil, err
+	}
+	p.ws()
+	if p.consume(":") {
+		p.ws()
+		if slice.step, err = p.optionalInteger(); err != nil {
+			return nil, err
+		}
+	}
+	return slice, nil
+}
+
+func (p *jsonPathParser) optionalInteger() (*int64, error) {
+	start := p.pos
+	p.consume("-")
+	digits := p.pos
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == digits {
+		if p.pos > start {
+			return nil, p.errorf("expected digits after -")
+		}
+		return nil, nil
+	}
+	token := p.expr[start:p.pos]
+	if (p.pos-digits > 1 && p.expr[digits] == '0') || token == "-0" {
+		return nil, p.errorf("invalid integer %s", token)
+	}
+	value, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || value > jsonPathMaxInt || value < -jsonPathMaxInt {
+		return nil, p.errorf("integer %s out of range", token)
+	}
+	return &value, nil
+}
+
+func (p *jsonPathParser) stringLiteral() (string, error) {
+	quote := p.expr[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		case c == '\\':
+			p.pos++
+			r, err := p.escape(quote)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *jsonPathParser) escape(quote byte) (rune, error) {
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case '/', '\\':
+		return rune(c), nil
+	case 'u':
+		r, err := p.hex4()
+		if err != nil {
+			return 0, err
+		}
+		if utf16.IsSurrogate(r) {
+			if r >= 0xdc00 || !p.consume("\\u") {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+			low, err := p.hex4()
+			if err != nil {
+				return 0, err
+			}
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return 0, p.errorf("invalid surrogate pair")
+			}
+		}
+		return r, nil
+	}
+	if c == quote {
+		return rune(c), nil
+	}
+	return 0, p.errorf("invalid escape sequence")
+}
+
+func (p *jsonPathParser) hex4() (rune, error) {
+	if p.pos+4 > len(p.expr) {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	value, err := strconv.ParseUint(p.expr[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid unicode escape")
+	}
+	p.pos += 4
+	return rune(value), nil
+}
+
+func (p *jsonPathParser) logicalOr() (interface{}, error) {
+	operands := make([]interface{}, 0)
+	for {
+		operand, err := p.logicalAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		p.ws()
+		if !p.consume("||") {
+			break
+		}
+		p.ws()
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return jsonPathOr{operands}, nil
+}
+
+func (p *jsonPathParser) logicalAnd() (interface{}, error) {
+	operands := make([]interface{}, 0)
+	for {
+		operand, err := p.basicExpr()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		p.ws()
+		if !p.consume("&&") {
+			break
+		}
+		p.ws()
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return jsonPathAnd{operands}, nil
+}
+
+func (p *jsonPathParser) basicExpr() (interface{}, error) {
+	if p.peek() == '!' {
+		p.pos++
+		p.ws()
+		if p.consume("(") {
+			expr, err := p.parenExpr()
+			if err != nil {
+				return nil, err
+			}
+			return jsonPathNot{expr}, nil
+		}
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkTest(operand); err != nil {
+			return nil, err
+		}
+		return jsonPathNot{operand}, nil
+	}
+	if p.consume("(") {
+		return p.parenExpr()
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	start := p.pos
+	p.ws()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			p.ws()
+			right, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.checkComparable(left); err != nil {
+				return nil, err
+			}
+			if err := p.checkComparable(right); err != nil {
+				return nil, err
+			}
+			return jsonPathComparison{op: op, left: left, right: right}, nil
+		}
+	}
+	p.pos = start
+	if err := p.checkTest(left); err != nil {
+		return nil, err
+	}
+	return left, nil
+}
+
+func (p *jsonPathParser) parenExpr() (interface{}, error) {
+	p.ws()
+	expr, err := p.logicalOr()
+	if err != nil {
+		return nil, err
+	}
+	p.ws()
+	if !p.consume(")") {
+		return nil, p.errorf("expected )")
+	}
+	return expr, nil
+}
+
+// operand parses a literal, a query or a function call
+func (p *jsonPathParser) operand() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		return p.segments(c == '@')
+	case c == '\'' || c == '"':
+		s, err := p.stringLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return jsonPathLiteral{s}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.numberLiteral()
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.expr) && (p.peek() == '_' || (p.peek() >= 'a' && p.peek() <= 'z') || (p.peek() >= '0' && p.peek() <= '9')) {
+			p.pos++
+		}
+		name := p.expr[start:p.pos]
+		if p.peek() != '(' {
+			switch name {
+			case "true":
+				return jsonPathLiteral{true}, nil
+			case "false":
+				return jsonPathLiteral{false}, nil
+			case "null":
+				return jsonPathLiteral{nil}, nil
+			}
+			return nil, p.errorf("unknown literal %s", name)
+		}
+		return p.function(name)
+	}
+	return nil, p.errorf("expected a literal, query or function")
+}
+
+var jsonPathNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?`)
+
+func (p *jsonPathParser) numberLiteral() (interface{}, error) {
+	token := jsonPathNumber.FindString(p.expr[p.pos:])
+	if token == "" {
+		return nil, p.errorf("invalid number")
+	}
+	value, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", token)
+	}
+	p.pos += len(token)
+	return jsonPathLiteral{value}, nil
+}
+
+func (p *jsonPathParser) function(name string) (interface{}, error) {
+	definition, ok := jsonPathFunctions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s", name)
+	}
+	p.pos++ // (
+
+	f := jsonPathFunction{name: name}
+	p.ws()
+	for !p.consume(")") {
+		if len(f.args) > 0 && !p.consume(",") {
+			return nil, p.errorf("expected , or ) in %s()", name)
+		}
+		p.ws()
+		// Literals, queries and functions are arguments on their own, anything else is a logical expression
+		start := p.pos
+		arg, err := p.operand()
+		p.ws()
+		if err != nil || (p.peek() != ',' && p.peek() != ')') {
+			p.pos = start
+			if arg, err = p.logicalOr(); err != nil {
+				return nil, err
+			}
+		}
+		f.args = append(f.args, arg)
+		p.ws()
+	}
+
+	if len(f.args) != len(definition.params) {
+		return nil, p.errorf("%s() takes %d arguments", name, len(definition.params))
+	}
+	for i, arg := range f.args {
+		if !jsonPathArgumentOK(arg, definition.params[i]) {
+			return nil, p.errorf("argument %d of %s() has the wrong type", i+1, name)
+		}
+	}
+	return f, nil
+}
+
+func jsonPathArgumentOK(arg interface{}, param jsonPathType) bool {
+	switch a := arg.(type) {
+	case jsonPathLiteral:
+		return param == jsonPathValueType
+	case *jsonPathQuery:
+		return param == jsonPathNodesType || param == jsonPathLogicalType || (param == jsonPathValueType && a.singular())
+	case jsonPathFunction:
+		result := jsonPathFunctions[a.name].result
+		return result == param || (param == jsonPathLogicalType && result == jsonPathNodesType)
+	}
+	return param == jsonPathLogicalType // Logical expressions
+}
+
+func (p *jsonPathParser) checkComparable(operand interface{}) error {
+	switch o := operand.(type) {
+	case jsonPathLiteral:
+		return nil
+	case *jsonPathQuery:
+		if o.singular() {
+			return nil
+		}
+		return p.errorf("only singular queries can be compared")
+	case jsonPathFunction:
+		if jsonPathFunctions[o.name].result == jsonPathValueType {
+			return nil
+		}
+		return p.errorf("%s() cannot be compared", o.name)
+	}
+	return p.errorf("invalid comparison")
+}
+
+func (p *jsonPathParser) checkTest(operand interface{}) error {
+	switch o := operand.(type) {
+	case *jsonPathQuery:
+		return nil
+	case jsonPathFunction:
+		if jsonPathFunctions[o.name].result != jsonPathValueType {
+			return nil
+		}
+		return p.errorf("the result of %s() must be compared", o.name)
+	}
+	return p.errorf("a literal must be compared")
+}
+
+// singular reports if the query selects at most one node, only names and indexes without descendants
+func (q *jsonPathQuery) singular() bool {
+	for _, segment := range q.segments {
+		if segment.descendant || len(segment.selectors) != 1 {
+			return false
+		}
+		switch segment.selectors[0].(type) {
+		case jsonPathName, jsonPathIndex:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluation
+
+type jsonPathNode struct {
+	node    *NodeCRDT
+	key     string // Member name if the parent is a map
+	pointer string
+}
+
+// jsonPathValue is a value or nothing, e.g. the result of a singular query without a match
+type jsonPathValue struct {
+	value interface{}
+	ok    bool
+}
+
+type jsonPathEvaluator struct {
+	tree     *TreeCRDT
+	patcher  *jsonPatcher
+	root     jsonPathNode
+	readable func(node *NodeCRDT) bool
+	regexps  map[string]*regexp.Regexp
+}
+
+func (e *jsonPathEvaluator) visible(nodes []jsonPathNode) []jsonPathNode {
+	if e.readable == nil {
+		return nodes
+	}
+	result := make([]jsonPathNode, 0, len(nodes))
+	for _, n := range nodes {
+		if e.readable(n.node) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func (e *jsonPathEvaluator) query(q *jsonPathQuery, current jsonPathNode) []jsonPathNode {
+	nodes := []jsonPathNode{e.root}
+	if q.relative {
+		nodes = []jsonPathNode{current}
+	}
+	for _, segment := range q.segments {
+		next := make([]jsonPathNode, 0)
+		for _, n := range nodes {
+			if segment.descendant {
+				for _, d := range e.descendants(n) {
+					next = e.selectAll(segment.selectors, d, next)
+				}
+			} else {
+				next = e.selectAll(segment.selectors, n, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// descendants returns the node followed by all its descendants in document order
+func (e *jsonPathEvaluator) descendants(n jsonPathNode) []jsonPathNode {
+	result := []jsonPathNode{n}
+	for _, child := range e.children(n) {
+		result = append(result, e.descendants(child)...)
+	}
+	return result
+}
+
+func (e *jsonPathEvaluator) children(n jsonPathNode) []jsonPathNode {
+	if n.node.IsLiteral {
+		return nil
+	}
+	edges := e.patcher.children(n.node)
+	children := make([]jsonPathNode, 0, len(edges))
+	for i, edge := range edges {
+		child := jsonPathNode{node: e.tree.Nodes[edge.To], key: edge.Label, pointer: n.pointer + "/" + escapeJSONPointer(edge.Label)}
+		if n.node.IsArray {
+			child.key, child.pointer = "", n.pointer+"/"+strconv.Itoa(i)
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+func (e *jsonPathEvaluator) selectAll(selectors []interface{}, n jsonPathNode, result []jsonPathNode) []jsonPathNode {
+	for _, selector := range selectors {
+		result = append(result, e.selectNodes(selector, n)...)
+	}
+	return result
+}
+
+func (e *jsonPathEvaluator) selectNodes(selector interface{}, n jsonPathNode) []jsonPathNode {
+	switch s := selector.(type) {
+	case jsonPathName:
+		if n.node.IsMap {
+			for _, child := range e.children(n) {
+				if child.key == s.name {
+					return []jsonPathNode{child}
+				}
+			}
+		}
+	case jsonPathWildcard:
+		return e.children(n)
+	case jsonPathIndex:
+		if n.node.IsArray {
+			children := e.children(n)
+			index := s.index
+			if index < 0 {
+				index += int64(len(children))
+			}
+			if index >= 0 && index < int64(len(children)) {
+				return []jsonPathNode{children[index]}
+			}
+		}
+	case jsonPathSlice:
+		if n.node.IsArray {
+			children := e.children(n)
+			result := make([]jsonPathNode, 0)
+			for _, i := range sliceIndexes(s, int64(len(children))) {
+				result = append(result, children[i])
+			}
+			return result
+		}
+	case jsonPathFilter:
+		result := make([]jsonPathNode, 0)
+		for _, child := range e.children(n) {
+			if e.logical(s.expr, child) {
+				result = append(result, child)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// sliceIndexes returns the selected indexes of an array with the given length, see RFC 9535 section 2.3.4.2
+func sliceIndexes(s jsonPathSlice, length int64) []int64 {
+	step := int64(1)
+	if s.step != nil {
+		step = *s.step
+	}
+	if step == 0 {
+		return nil
+	}
+	normalize := func(i int64) int64 {
+		if i < 0 {
+			return length + i
+		}
+		return i
+	}
+	clamp := func(i, min, max int64) int64 {
+		if i < min {
+			return min
+		}
+		if i > max {
+			return max
+		}
+		return i
+	}
+
+	indexes := make([]int64, 0)
+	if step > 0 {
+		start, end := int64(0), length
+		if s.start != nil {
+			start = normalize(*s.start)
+		}
+		if s.end != nil {
+			end = normalize(*s.end)
+		}
+		for i := clamp(start, 0, length); i < clamp(end, 0, length); i += step {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+
+	start, end := length-1, -length-1
+	if s.start != nil {
+		start = normalize(*s.start)
+	}
+	if s.end != nil {
+		end = normalize(*s.end)
+	}
+	for i := clamp(start, -1, length-1); clamp(end, -1, length-1) < i; i += step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func (e *jsonPathEvaluator) logical(expr interface{}, current jsonPathNode) bool {
+	switch x := expr.(type) {
+	case jsonPathOr:
+		for _, operand := range x.operands {
+			if e.logical(operand, current) {
+				return true
+			}
+		}
+		return false
+	case jsonPathAnd:
+		for _, operand := range x.operands {
+			if !e.logical(operand, current) {
+				return false
+			}
+		}
+		return true
+	case jsonPathNot:
+		return !e.logical(x.operand, current)
+	case jsonPathComparison:
+		return compareJSONPathValues(e.comparable(x.left, current), x.op, e.comparable(x.right, current))
+	case *jsonPathQuery:
+		return len(e.visible(e.query(x, current))) > 0
+	case jsonPathFunction:
+		result := e.function(x, current)
+		return result.ok && result.value == true
+	}
+	return false
+}
+
+func (e *jsonPathEvaluator) comparable(operand interface{}, current jsonPathNode) jsonPathValue {
+	switch o := operand.(type) {
+	case jsonPathLiteral:
+		return jsonPathValue{o.value, true}
+	case *jsonPathQuery:
+		nodes := e.visible(e.query(o, current))
+		if len(nodes) == 1 {
+			return jsonPathValue{e.value(nodes[0].node), true}
+		}
+	case jsonPathFunction:
+		return e.function(o, current)
+	}
+	return jsonPathValue{}
+}
+
+// function evaluates a function, logical results are returned as a boolean value
+func (e *jsonPathEvaluator) function(f jsonPathFunction, current jsonPathNode) jsonPathValue {
+	switch f.name {
+	case "length":
+		switch v := e.comparable(f.args[0], current).value.(type) {
+		case string:
+			return jsonPathValue{float64(utf8.RuneCountInString(v)), true}
+		case []interface{}:
+			return jsonPathValue{float64(len(v)), true}
+		case map[string]interface{}:
+			return jsonPathValue{float64(len(v)), true}
+		}
+	case "count":
+		return jsonPathValue{float64(len(e.nodesArgument(f.args[0], current))), true}
+	case "value":
+		nodes := e.nodesArgument(f.args[0], current)
+		if len(nodes) == 1 {
+			return jsonPathValue{e.value(nodes[0].node), true}
+		}
+	case "match", "search":
+		s, ok1 := e.comparable(f.args[0], current).value.(string)
+		pattern, ok2 := e.comparable(f.args[1], current).value.(string)
+		if !ok1 || !ok2 {
+			return jsonPathValue{false, true}
+		}
+		if f.name == "match" {
+			pattern = "^(?:" + pattern + ")$"
+		}
+		re, err := e.regexp(pattern)
+		if err != nil {
+			return jsonPathValue{false, true} // An invalid pattern matches nothing
+		}
+		return jsonPathValue{re.MatchString(s), true}
+	}
+	return jsonPathValue{}
+}
+
+func (e *jsonPathEvaluator) nodesArgument(arg interface{}, current jsonPathNode) []jsonPathNode {
+	if q, ok := arg.(*jsonPathQuery); ok {
+		return e.visible(e.query(q, current))
+	}
+	return nil
+}
+
+func (e *jsonPathEvaluator) regexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := e.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	e.regexps[pattern] = re
+	return re, nil
+}
+
+// value returns the JSON value of the node, without the children the identity is not allowed to read
+func (e *jsonPathEvaluator) value(node *NodeCRDT) interface{} {
+	if node.IsLiteral {
+		return node.effectiveValue()
+	}
+	children := e.visible(e.children(jsonPathNode{node: node}))
+	if node.IsArray {
+		items := make([]interface{}, 0, len(children))
+		for _, child := range children {
+			items = append(items, e.value(child.node))
+		}
+		return items
+	}
+	members := make(map[string]interface{}, len(children))
+	for _, child := range children {
+		members[child.key] = e.value(child.node)
+	}
+	return members
+}
+
+func compareJSONPathValues(left jsonPathValue, op string, right jsonPathValue) bool {
+	switch op {
+	case "==":
+		return jsonPathEqual(left, right)
+	case "!=":
+		return !jsonPathEqual(left, right)
+	case "<":
+		return jsonPathLess(left, right)
+	case "<=":
+		return jsonPathLess(left, right) || jsonPathEqual(left, right)
+	case ">":
+		return jsonPathLess(right, left)
+	case ">=":
+		return jsonPathLess(right, left) || jsonPathEqual(left, right)
+	}
+	return false
+}
+
+func jsonPathEqual(left, right jsonPathValue) bool {
+	if !left.ok || !right.ok {
+		return !left.ok && !right.ok
+	}
+	return jsonValuesEqual(left.value, right.value)
+}
+
+func jsonValuesEqual(a, b interface{}) bool {
+	if x, ok := jsonNumber(a); ok {
+		y, ok := jsonNumber(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonValuesEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !jsonValuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case string, bool, nil:
+		return a == b
+	}
+	return false
+}
+
+func jsonPathLess(left, right jsonPathValue) bool {
+	if !left.ok || !right.ok {
+		return false
+	}
+	if x, ok := jsonNumber(left.value); ok {
+		y, ok := jsonNumber(right.value)
+		return ok && x < y
+	}
+	x, ok1 := left.value.(string)
+	y, ok2 := right.value.(string)
+	return ok1 && ok2 && x < y // Byte order of UTF-8 is the order of the code points
+}
+
+func jsonNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, !math.IsNaN(n)
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,173 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const bookstoreJSON = `{"store": {
+	"book": [
+		{"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+		{"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+		{"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+		{"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+	],
+	"bicycle": {"color": "red", "price": 399}
+}}`
+
+func queryPointers(t *testing.T, c *TreeCRDT, expr string) []string {
+	results, err := c.Query(expr, nil)
+	assert.Nil(t, err, expr)
+	pointers := make([]string, 0, len(results))
+	for _, result := range results {
+		pointers = append(pointers, result.Pointer)
+	}
+	return pointers
+}
+
+func TestQueryJSONPath(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(bookstoreJSON), "client1")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{`$`, []string{""}},
+		{`$.store.book[*].author`, []string{"/store/book/0/author", "/store/book/1/author", "/store/book/2/author", "/store/book/3/author"}},
+		{`$..author`, []string{"/store/book/0/author", "/store/book/1/author", "/store/book/2/author", "/store/book/3/author"}},
+		{`$.store['bicycle']["color"]`, []string{"/store/bicycle/color"}},
+		{`$..book[2]`, []string{"/store/book/2"}},
+		{`$..book[-1]`, []string{"/store/book/3"}},
+		{`$..book[0,1]`, []string{"/store/book/0", "/store/book/1"}},
+		{`$..book[:2]`, []string{"/store/book/0", "/store/book/1"}},
+		{`$..book[::-2]`, []string{"/store/book/3", "/store/book/1"}},
+		{`$..book[1:3:0]`, []string{}},
+		{`$..book[?@.isbn]`, []string{"/store/book/2", "/store/book/3"}},
+		{`$..book[?(@.price<10)].title`, []string{"/store/book/0/title", "/store/book/2/title"}},
+		{`$..book[?@.price > 10 && @.category == 'fiction'].title`, []string{"/store/book/1/title", "/store/book/3/title"}},
+		{`$..book[?!@.isbn || @.price == 22.99]`, []string{"/store/book/0", "/store/book/1", "/store/book/3"}},
+		{`$..book[?@.price < $.store.bicycle.price && @.price >= 12.99].price`, []string{"/store/book/1/price", "/store/book/3/price"}},
+		{`$..book[?match(@.author, 'J.*')].author`, []string{"/store/book/3/author"}},
+		{`$..book[?search(@.title, 'of')].title`, []string{"/store/book/0/title", "/store/book/1/title", "/store/book/3/title"}},
+		{`$..book[?length(@.title) == 9].title`, []string{"/store/book/2/title"}},
+		{`$.store[?count(@.*) == 2]`, []string{"/store/bicycle"}},
+		{`$..book[?value(@..isbn) == '0-553-21311-3']`, []string{"/store/book/2"}},
+		{`$.store.missing`, []string{}},
+		{`$.store.book.author`, []string{}},
+		{`$..*[?@ == 'red']`, []string{"/store/bicycle/color"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, queryPointers(t, c, test.expr), test.expr)
+	}
+
+	results, err := c.Query(`$.store.bicycle.price`, nil)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "literal", results[0].Kind)
+	assert.Equal(t, 399.0, results[0].Value)
+
+	bicycle, err := c.GetNodeByPath("/store/bicycle")
+	assert.Nil(t, err)
+	results, err = c.Query(`$.store.bicycle`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, bicycle.ID, results[0].NodeID)
+	assert.Equal(t, "map", results[0].Kind)
+	assert.Nil(t, results[0].Value)
+}
+
+func TestQueryJSONPathEscapes(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a/b": {"c~d": 1, "é": 2, "it's": 3}}`), "client1")
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"/a~1b/c~0d"}, queryPointers(t, c, `$['a/b']['c~d']`))
+	assert.Equal(t, []string{"/a~1b/é"}, queryPointers(t, c, `$['a/b'].é`))
+	assert.Equal(t, []string{"/a~1b/it's"}, queryPointers(t, c, `$['a/b']['it\'s']`))
+	assert.Equal(t, []string{"/a~1b/é"}, queryPointers(t, c, `$['a/b']["é"]`))
+}
+
+func TestQueryJSONPathInvalid(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": [1, 2]}`), "client1")
+	assert.Nil(t, err)
+
+	for _, expr := range []string{
+		``,
+		`a`,
+		`$.`,
+		`$[`,
+		`$.a[01]`,
+		`$.a[-0]`,
+		`$.a[?@.b == 1`,
+		`$.a[?1]`,
+		`$.a[?@.* == 1]`,
+		`$.a[?length(@) ]`,
+		`$.a[?count(1) == 1]`,
+		`$.a[?foo(@)]`,
+		`$.a['b]`,
+		`$.a[9007199254740992]`,
+	} {
+		_, err := c.Query(expr, nil)
+		assert.NotNil(t, err, expr)
+	}
+}
+
+func TestQueryJSONPathReadPermissions(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"devices": [{"type": "hvac", "setpoint": 21, "secret": "x"}, {"type": "light", "setpoint": 0}]}`), prvKey)
+	assert.Nil(t, err)
+
+	device, err := c.GetNodeByPath("/devices/0")
+	assert.Nil(t, err)
+	devices, err := c.GetNodeByPath("/devices")
+	assert.Nil(t, err)
+	secret, err := c.GetNodeByPath("/devices/0/secret")
+	assert.Nil(t, err)
+	err = c.ABAC().Allow(identity2.ID(), ActionRead, devices.ID(), false)
+	assert.Nil(t, err)
+	err = c.ABAC().Allow(identity2.ID(), ActionRead, device.ID(), false)
+	assert.Nil(t, err)
+	for _, key := range []string{"type", "setpoint"} {
+		node, err := c.GetNodeByPath("/devices/0/" + key)
+		assert.Nil(t, err)
+		err = c.ABAC().Allow(identity2.ID(), ActionRead, node.ID(), false)
+		assert.Nil(t, err)
+	}
+
+	results, err := c.Query(`$.devices[?@.type=='hvac'].setpoint`, prvKey)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 21.0, results[0].Value)
+
+	results, err = c.Query(`$.devices[?(@.type=='hvac')].setpoint`, prvKey2)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1, "The reader may read the setpoint of the hvac device")
+
+	results, err = c.Query(`$..secret`, prvKey2)
+	assert.Nil(t, err)
+	assert.Len(t, results, 0, "Values that may not be read are not returned")
+
+	results, err = c.Query(`$.devices[?@.secret == 'x']`, prvKey2)
+	assert.Nil(t, err)
+	assert.Len(t, results, 0, "Filters cannot test values that may not be read")
+
+	results, err = c.Query(`$.devices[?@.type == 'light']`, prvKey2)
+	assert.Nil(t, err)
+	assert.Len(t, results, 0)
+
+	results, err = c.Query(`$..secret`, "")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, secret.ID(), results[0].NodeID)
+}
@@ -0,0 +1,169 @@
+package crdt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func savedTestTree(t testing.TB) (*TreeCRDT, []byte) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"name": "Alice", "friends": [{"name": "Bob"}, "Charlie"]}`), ClientID("client1"))
+	assert.Nil(t, err)
+	data, err := c.Save()
+	assert.Nil(t, err)
+	return c, data
+}
+
+// mutateSaved applies a change to the generic structure of saved data
+func mutateSaved(t *testing.T, data []byte, mutate func(raw map[string]interface{}, node map[string]interface{})) []byte {
+	var raw map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &raw))
+
+	var node map[string]interface{}
+	for _, n := range raw["nodes"].(map[string]interface{}) {
+		n := n.(map[string]interface{})
+		if len(n["edges"].([]interface{})) > 0 && !n["isroot"].(bool) {
+			node = n
+			break
+		}
+	}
+	mutate(raw, node)
+
+	buf, err := json.Marshal(raw)
+	assert.Nil(t, err)
+	return buf
+}
+
+func TestLoadInvalidData(t *testing.T) {
+	_, data := savedTestTree(t)
+
+	tests := []struct {
+		name     string
+		mutate   func(raw map[string]interface{}, node map[string]interface{})
+		expected string
+	}{
+		{"missing nodes", func(raw, node map[string]interface{}) { delete(raw, "nodes") }, "nodes: missing field"},
+		{"missing root", func(raw, node map[string]interface{}) { delete(raw, "root") }, "root: missing field"},
+		{"unknown root", func(raw, node map[string]interface{}) { raw["root"] = "nope" }, "root node nope not found"},
+		{"mistyped bool", func(raw, node map[string]interface{}) { node["isroot"] = "yes" }, ".isroot: expected bool, got string"},
+		{"missing owner", func(raw, node map[string]interface{}) { delete(node, "owner") }, ".owner: missing field"},
+		{"mistyped clock", func(raw, node map[string]interface{}) { node["clock"] = map[string]interface{}{"a": 1.5} }, `.clock["a"]: expected integer`},
+		{"mistyped edge", func(raw, node map[string]interface{}) { node["edges"].([]interface{})[0] = 1 }, ".edges[0]: expected object, got number"},
+		{"mistyped label", func(raw, node map[string]interface{}) {
+			node["edges"].([]interface{})[0].(map[string]interface{})["label"] = nil
+		}, ".edges[0].label: expected string, got null"},
+		{"dangling edge", func(raw, node map[string]interface{}) {
+			node["edges"].([]interface{})[0].(map[string]interface{})["to"] = "missing"
+		}, ".edges[0].to: dangling edge to missing node missing"},
+		{"multiple parents", func(raw, node map[string]interface{}) {
+			root := raw["nodes"].(map[string]interface{})[raw["root"].(string)].(map[string]interface{})
+			edge := map[string]interface{}{}
+			for k, v := range node["edges"].([]interface{})[0].(map[string]interface{}) {
+				edge[k] = v
+			}
+			edge["from"] = raw["root"]
+			root["edges"] = append(root["edges"].([]interface{}), edge)
+		}, "has multiple parents"},
+		{"edge to root", func(raw, node map[string]interface{}) {
+			node["edges"].([]interface{})[0].(map[string]interface{})["to"] = raw["root"]
+		}, "edge to the root node"},
+		{"cycle", func(raw, node map[string]interface{}) {
+			// Unlink the node from its parent and let it point to itself
+			for _, n := range raw["nodes"].(map[string]interface{}) {
+				n := n.(map[string]interface{})
+				edges := make([]interface{}, 0)
+				for _, edge := range n["edges"].([]interface{}) {
+					if edge.(map[string]interface{})["to"] != node["id"] {
+						edges = append(edges, edge)
+					}
+				}
+				n["edges"] = edges
+			}
+			node["edges"].([]interface{})[0].(map[string]interface{})["to"] = node["id"]
+		}, "cycle detected"},
+		{"mistyped position", func(raw, node map[string]interface{}) {
+			node["edges"].([]interface{})[0].(map[string]interface{})["lseqposition"] = []interface{}{-1}
+		}, ".edges[0].lseqposition[0]: expected integer"},
+		{"mistyped abac", func(raw, node map[string]interface{}) { raw["abac"] = "policy" }, "abac: expected object, got string"},
+		{"null key rotation", func(raw, node map[string]interface{}) { raw["keyrotations"] = []interface{}{nil} }, "keyrotations[0]: expected object, got null"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := savedTestTree(t)
+			before, err := c.Save()
+			assert.Nil(t, err)
+
+			err = c.Load(mutateSaved(t, data, test.mutate))
+			assert.NotNil(t, err)
+			if err != nil {
+				assert.Contains(t, err.Error(), test.expected)
+			}
+
+			after, err := c.Save()
+			assert.Nil(t, err)
+			assert.Equal(t, before, after, "A failed Load should leave the tree unchanged")
+		})
+	}
+
+	t.Run("Syntax error position", func(t *testing.T) {
+		c := newTreeCRDT()
+		err := c.Load([]byte(`{"nodes": {]`))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "offset 12")
+
+		err = c.Load([]byte(`null`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Size limits", func(t *testing.T) {
+		maxLoadSize := MaxLoadSize
+		defer func() { MaxLoadSize = maxLoadSize }()
+		MaxLoadSize = len(data) - 1
+
+		c := newTreeCRDT()
+		err := c.Load(data)
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(err.Error(), "exceeds the limit"))
+
+		maxLoadNodes := MaxLoadNodes
+		defer func() { MaxLoadNodes = maxLoadNodes }()
+		MaxLoadSize = maxLoadSize
+		MaxLoadNodes = 2
+
+		err = c.Load(data)
+		assert.NotNil(t, err)
+		assert.True(t, strings.Contains(err.Error(), "exceeds the limit"))
+	})
+}
+
+func FuzzLoad(f *testing.F) {
+	c, data := savedTestTree(f)
+	binaryData, err := c.SaveAs(FormatBinary)
+	assert.Nil(f, err)
+
+	f.Add(data)
+	f.Add(binaryData)
+	f.Add([]byte(`{"root": "root", "nodes": {"root": {}}}`))
+	f.Add([]byte(`{"root": "root", "nodes": {"root": {"edges": [null]}}, "abac": {"rules": null}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := newTreeCRDT()
+		if err := c.Load(data); err != nil {
+			return
+		}
+		// A tree that loads must be usable
+		if _, err := c.Save(); err != nil {
+			t.Fatalf("Save failed after Load: %v", err)
+		}
+		if _, err := c.SaveAs(FormatBinary); err != nil {
+			t.Fatalf("SaveAs binary failed after Load: %v", err)
+		}
+		c.RootHash()
+		c.ExportJSON()
+		c.ValidateTree()
+	})
+}
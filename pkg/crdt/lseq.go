@@ -26,12 +26,18 @@ func generatePositionBetweenLSEQ(left, right Position) Position {
 		if level < len(right) {
 			r = right[level]
 		} else {
-			r = Base
+			// The interval doubles at every level, so repeated appends do not keep going deeper
+			r = Base << level
 		}
 
 		if r-l > 1 {
 			// Space exists, choose randomly in the gap
-			newDigit := l + 1 + rand.Intn(r-l-1)
+			gap := r - l - 1
+			if level >= len(right) && gap > Bound {
+				// Appending, stay close to the left and leave the rest of the interval for later appends
+				gap = Bound
+			}
+			newDigit := l + 1 + rand.Intn(gap)
 			return append(pos, newDigit)
 		}
 
@@ -43,25 +49,30 @@ func generatePositionBetweenLSEQ(left, right Position) Position {
 
 func sortEdgesByLSEQ(edges []*EdgeCRDT) {
 	sort.SliceStable(edges, func(i, j int) bool {
-		p1 := edges[i].LSEQPosition
-		p2 := edges[j].LSEQPosition
+		return lessEdgeLSEQ(edges[i], edges[j])
+	})
+}
 
-		// Lexicographic comparison
-		for k := 0; k < len(p1) && k < len(p2); k++ {
-			if p1[k] < p2[k] {
-				return true
-			}
-			if p1[k] > p2[k] {
-				return false
-			}
-		}
+// lessEdgeLSEQ orders edges by LSEQ position
+func lessEdgeLSEQ(e1, e2 *EdgeCRDT) bool {
+	p1 := e1.LSEQPosition
+	p2 := e2.LSEQPosition
 
-		// If one is prefix of the other, shorter one is smaller
-		if len(p1) != len(p2) {
-			return len(p1) < len(p2)
+	// Lexicographic comparison
+	for k := 0; k < len(p1) && k < len(p2); k++ {
+		if p1[k] < p2[k] {
+			return true
 		}
+		if p1[k] > p2[k] {
+			return false
+		}
+	}
 
-		// Tie-breaker: use Node To ID to guarantee deterministic sort
-		return edges[i].To < edges[j].To
-	})
+	// If one is prefix of the other, shorter one is smaller
+	if len(p1) != len(p2) {
+		return len(p1) < len(p2)
+	}
+
+	// Tie-breaker: use Node To ID to guarantee deterministic sort
+	return e1.To < e2.To
 }
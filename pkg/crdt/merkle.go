@@ -0,0 +1,331 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Merkle hashes are cached per node. Any change to a node clears the cached hash of the node and
+// of all its ancestors, so only the changed paths are rehashed the next time a hash is requested.
+//
+// The hash covers the node ID, its type flags and deleted flag, the literal value together with its
+// owner, signature and proposed changes, and the hashes and deleted flags of all children in document order. The
+// parent repeats the deleted flag of each child so that proofs can count visible array items. Clocks and LSEQ
+// positions are not covered since they differ between replicas that hold the same document.
+
+// merkleHashSize is the length of a SHA3-256 node hash
+const merkleHashSize = 32
+
+type Checkpoint struct {
+	RootHash  string   `json:"roothash"`
+	Owner     ClientID `json:"owner"`
+	Signature string   `json:"signature"`
+}
+
+func (n *NodeCRDT) Hash() (string, error) {
+	hash, err := n.merkleHash()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+func (c *TreeCRDT) RootHash() (string, error) {
+	return c.Root.Hash()
+}
+
+func (n *NodeCRDT) merkleHash() ([]byte, error) {
+	return n.merkleHashGuarded(make(map[NodeID]bool))
+}
+
+// merkleHashGuarded tracks the nodes being hashed so that a cycle returns an error instead of recursing forever
+func (n *NodeCRDT) merkleHashGuarded(inProgress map[NodeID]bool) ([]byte, error) {
+	if n.hash != nil {
+		return n.hash, nil
+	}
+	if inProgress[n.ID] {
+		return nil, fmt.Errorf("Cannot compute hash, cycle detected at node %s", n.ID)
+	}
+	inProgress[n.ID] = true
+	defer delete(inProgress, n.ID)
+
+	content, err := n.contentHash()
+	if err != nil {
+		return nil, err
+	}
+
+	edges := n.orderedEdges()
+	labels := make([]string, len(edges))
+	deleted := make([]bool, len(edges))
+	childHashes := make([][]byte, len(edges))
+	for i, edge := range edges {
+		child, ok := n.tree.Nodes[edge.To]
+		if !ok {
+			return nil, fmt.Errorf("Cannot compute hash, child node %s of %s not found", edge.To, n.ID)
+		}
+		childHash, err := child.merkleHashGuarded(inProgress)
+		if err != nil {
+			return nil, err
+		}
+		labels[i] = edge.Label
+		deleted[i] = child.IsDeleted
+		childHashes[i] = childHash
+	}
+
+	n.hash = combineMerkleHash(content, labels, deleted, childHashes)
+
+	return n.hash, nil
+}
+
+func combineMerkleHash(content []byte, labels []string, deleted []bool, childHashes [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(content)
+	for i := range labels {
+		writeHashField(&buf, []byte(labels[i]))
+		if deleted[i] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		buf.Write(childHashes[i])
+	}
+	return crypto.GenerateHash(buf.Bytes()).Bytes()
+}
+
+// contentHash hashes everything a node carries except its children
+func (n *NodeCRDT) contentHash() ([]byte, error) {
+	var buf bytes.Buffer
+	writeHashField(&buf, []byte(n.ID))
+
+	var flags byte
+	for i, flag := range []bool{n.IsRoot, n.IsMap, n.IsArray, n.IsLiteral, n.IsDeleted} {
+		if flag {
+			flags |= 1 << i
+		}
+	}
+	buf.WriteByte(flags)
+
+	if n.IsLiteral {
+		value, err := json.Marshal(n.LiteralValue)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot compute hash, failed to marshal literal of node %s: %w", n.ID, err)
+		}
+		writeHashField(&buf, value)
+		writeHashField(&buf, []byte(n.Owner))
+		writeHashField(&buf, []byte(n.Signature))
+
+		for _, change := range []*ProposedChange{n.Approved, n.Pending} {
+			if change == nil {
+				buf.WriteByte(0)
+				continue
+			}
+			changeJSON, err := json.Marshal(change)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot compute hash, failed to marshal proposed change of node %s: %w", n.ID, err)
+			}
+			buf.WriteByte(1)
+			writeHashField(&buf, changeJSON)
+		}
+	}
+
+	return crypto.GenerateHash(buf.Bytes()).Bytes(), nil
+}
+
+// orderedEdges returns the edges in the order they are hashed: LSEQ order for arrays, and label order
+// for maps and the root since map edges can be stored in a different order on different replicas
+func (n *NodeCRDT) orderedEdges() []*EdgeCRDT {
+	edges := make([]*EdgeCRDT, len(n.Edges))
+	copy(edges, n.Edges)
+	if n.IsArray {
+		sortEdgesByLSEQ(edges)
+	} else {
+		sort.SliceStable(edges, func(i, j int) bool {
+			if edges[i].Label != edges[j].Label {
+				return edges[i].Label < edges[j].Label
+			}
+			return edges[i].To < edges[j].To
+		})
+	}
+	return edges
+}
+
+func writeHashField(buf *bytes.Buffer, field []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+	buf.Write(length[:])
+	buf.Write(field)
+}
+
+// invalidateHash clears the cached hash of the node and all of its ancestors
+func (n *NodeCRDT) invalidateHash() {
+	current := n
+	for steps := 0; current != nil; steps++ {
+		current.hash = nil
+		if current.IsRoot || current.ParentID == "" || current.tree == nil || steps > len(current.tree.Nodes) {
+			return
+		}
+		parent, ok := current.tree.Nodes[current.ParentID]
+		if !ok {
+			return
+		}
+		current = parent
+	}
+}
+
+func (c *TreeCRDT) invalidateAllHashes() {
+	for _, node := range c.Nodes {
+		node.hash = nil
+	}
+}
+
+// DivergentNodes returns the nodes in this tree that differ from the other tree. Subtrees with equal
+// hashes are skipped, so the cost is proportional to the number of changed nodes times the tree depth.
+// A node is reported if it is missing in the other tree, if its own content differs, or if it has
+// a different set of children.
+func (c *TreeCRDT) DivergentNodes(other *TreeCRDT) ([]NodeID, error) {
+	var divergent []NodeID
+
+	var walk func(local *NodeCRDT) error
+	walk = func(local *NodeCRDT) error {
+		remote, ok := other.Nodes[local.ID]
+		if !ok {
+			divergent = append(divergent, local.ID)
+			return nil
+		}
+
+		localHash, err := local.merkleHash()
+		if err != nil {
+			return err
+		}
+		remoteHash, err := remote.merkleHash()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(localHash, remoteHash) {
+			return nil
+		}
+
+		localContent, err := local.contentHash()
+		if err != nil {
+			return err
+		}
+		remoteContent, err := remote.contentHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(localContent, remoteContent) || !sameChildren(local, remote) {
+			divergent = append(divergent, local.ID)
+		}
+
+		for _, edge := range local.orderedEdges() {
+			child, ok := c.Nodes[edge.To]
+			if !ok {
+				return fmt.Errorf("Cannot compare trees, child node %s of %s not found", edge.To, local.ID)
+			}
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(c.Root); err != nil {
+		return nil, err
+	}
+
+	return divergent, nil
+}
+
+func sameChildren(a, b *NodeCRDT) bool {
+	if len(a.Edges) != len(b.Edges) {
+		return false
+	}
+	children := make(map[NodeID]string, len(a.Edges))
+	for _, edge := range a.Edges {
+		children[edge.To] = edge.Label
+	}
+	for _, edge := range b.Edges {
+		label, ok := children[edge.To]
+		if !ok || label != edge.Label {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *TreeCRDT) SignCheckpoint(identity *crypto.Idendity) (*Checkpoint, error) {
+	rootHash, err := c.RootHash()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compute root hash: %w", err)
+	}
+
+	checkpoint := &Checkpoint{
+		RootHash: rootHash,
+		Owner:    ClientID(identity.ID()),
+	}
+
+	signature, err := crypto.Sign(checkpoint.digest(), identity.PrivateKey())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"RootHash": rootHash,
+			"Error":    err,
+		}).Error("Failed to sign checkpoint")
+		return nil, fmt.Errorf("Failed to sign checkpoint: %w", err)
+	}
+	checkpoint.Signature = hex.EncodeToString(signature)
+
+	return checkpoint, nil
+}
+
+func (cp *Checkpoint) digest() *crypto.Hash {
+	return crypto.GenerateHashFromString("checkpoint:" + cp.RootHash + ":" + string(cp.Owner))
+}
+
+func (cp *Checkpoint) Verify() (string, error) {
+	signatureBytes, err := hex.DecodeString(cp.Signature)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode checkpoint signature: %w", err)
+	}
+
+	recoveredID, err := crypto.RecoveredID(cp.digest(), signatureBytes)
+	if err != nil {
+		return "", fmt.Errorf("Failed to recover ID from checkpoint signature: %w", err)
+	}
+
+	if recoveredID != string(cp.Owner) {
+		log.WithFields(log.Fields{
+			"RootHash":    cp.RootHash,
+			"RecoveredID": recoveredID,
+			"Owner":       cp.Owner,
+		}).Error("Recovered ID does not match checkpoint owner")
+		return "", fmt.Errorf("Recovered ID %s does not match checkpoint owner %s", recoveredID, cp.Owner)
+	}
+
+	return recoveredID, nil
+}
+
+// VerifyCheckpoint checks that the checkpoint is validly signed and matches the current root hash
+func (c *TreeCRDT) VerifyCheckpoint(checkpoint *Checkpoint) error {
+	if _, err := checkpoint.Verify(); err != nil {
+		return err
+	}
+
+	rootHash, err := c.RootHash()
+	if err != nil {
+		return fmt.Errorf("Failed to compute root hash: %w", err)
+	}
+
+	if rootHash != checkpoint.RootHash {
+		return fmt.Errorf("Checkpoint root hash %s does not match tree root hash %s", checkpoint.RootHash, rootHash)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/pkg/random"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeCRDTRootHash(t *testing.T) {
+	clientID := ClientID(random.GenerateRandomID())
+
+	initialJSON := []byte(`{
+		"name": "Alice",
+		"devices": [
+			{"id": "lamp", "on": true},
+			{"id": "hvac", "setpoint": 21}
+		]
+	}`)
+
+	c := newTreeCRDT()
+	_, err := c.ImportJSON(initialJSON, clientID)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	rootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.Len(t, rootHash, 64, "RootHash should be a hex encoded SHA3-256 hash")
+
+	c2, err := c.Clone()
+	assert.Nil(t, err, "Clone should not return an error")
+	rootHash2, err := c2.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.Equal(t, rootHash, rootHash2, "Cloned tree should have the same root hash")
+
+	devicesNode, err := c.GetNodeByPath("/devices")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	devicesHash, err := devicesNode.Hash()
+	assert.Nil(t, err, "Hash should not return an error")
+	nameNode, err := c.GetNodeByPath("/name")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	nameHash, err := nameNode.Hash()
+	assert.Nil(t, err, "Hash should not return an error")
+
+	setpointNode, err := c.GetNodeByPath("/devices/1/setpoint")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	err = setpointNode.SetLiteral(22, clientID)
+	assert.Nil(t, err, "SetLiteral should not return an error")
+
+	updatedRootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.NotEqual(t, rootHash, updatedRootHash, "Root hash should change when a leaf changes")
+
+	updatedDevicesHash, err := devicesNode.Hash()
+	assert.Nil(t, err, "Hash should not return an error")
+	assert.NotEqual(t, devicesHash, updatedDevicesHash, "Ancestor hash should change when a leaf changes")
+
+	updatedNameHash, err := nameNode.Hash()
+	assert.Nil(t, err, "Hash should not return an error")
+	assert.Equal(t, nameHash, updatedNameHash, "Unrelated subtree hash should not change")
+
+	// The incrementally maintained hash must match a hash computed from scratch
+	c.invalidateAllHashes()
+	recomputedRootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.Equal(t, updatedRootHash, recomputedRootHash, "Cached root hash should match recomputed root hash")
+}
+
+func TestTreeCRDTRootHashRemoveKey(t *testing.T) {
+	clientID := ClientID(random.GenerateRandomID())
+
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": {"b": "1", "c": "2"}}`), clientID)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	rootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+
+	aNode, err := c.GetNodeByPath("/a")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	err = aNode.RemoveKeyValue("b", clientID)
+	assert.Nil(t, err, "RemoveKeyValue should not return an error")
+
+	updatedRootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.NotEqual(t, rootHash, updatedRootHash, "Root hash should change when a key is removed")
+
+	c.invalidateAllHashes()
+	recomputedRootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+	assert.Equal(t, updatedRootHash, recomputedRootHash, "Cached root hash should match recomputed root hash")
+}
+
+func TestTreeCRDTRootHashCycle(t *testing.T) {
+	clientID := ClientID(random.GenerateRandomID())
+
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": {"b": {"c": "1"}}}`), clientID)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	// Link the innermost map back to its ancestor
+	aNode, err := c.GetNodeByPath("/a")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	bNode, err := c.GetNodeByPath("/a/b")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	bNode.Edges = append(bNode.Edges, &EdgeCRDT{From: bNode.ID, To: aNode.ID, Label: "loop"})
+	c.invalidateAllHashes()
+
+	_, err = c.RootHash()
+	assert.NotNil(t, err, "RootHash should return an error on a cycle")
+	assert.Contains(t, err.Error(), "cycle detected")
+}
+
+func TestTreeCRDTDivergentNodes(t *testing.T) {
+	clientID := ClientID(random.GenerateRandomID())
+
+	initialJSON := []byte(`{
+		"a": {"x": "1", "y": "2"},
+		"b": {"x": "3", "y": "4"}
+	}`)
+
+	c1 := newTreeCRDT()
+	_, err := c1.ImportJSON(initialJSON, clientID)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err, "Clone should not return an error")
+
+	divergent, err := c1.DivergentNodes(c2)
+	assert.Nil(t, err, "DivergentNodes should not return an error")
+	assert.Empty(t, divergent, "Identical trees should not diverge")
+
+	node, err := c2.GetNodeByPath("/b/y")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	err = node.SetLiteral("5", clientID)
+	assert.Nil(t, err, "SetLiteral should not return an error")
+
+	divergent, err = c1.DivergentNodes(c2)
+	assert.Nil(t, err, "DivergentNodes should not return an error")
+	assert.Equal(t, []NodeID{node.ID}, divergent, "Only the changed literal should diverge")
+
+	bNode, err := c2.GetNodeByPath("/b")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	_, err = bNode.SetKeyValue("z", "6", clientID)
+	assert.Nil(t, err, "SetKeyValue should not return an error")
+
+	divergent, err = c1.DivergentNodes(c2)
+	assert.Nil(t, err, "DivergentNodes should not return an error")
+	assert.ElementsMatch(t, []NodeID{bNode.ID, node.ID}, divergent, "Parent with a new child should diverge")
+}
+
+func TestSecureTreeCheckpoint(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+
+	_, err = c.ImportJSON([]byte(`{"foo": "bar", "baz": 123}`), prvKey)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	checkpoint, err := c.SignCheckpoint(prvKey)
+	assert.Nil(t, err, "SignCheckpoint should not return an error")
+
+	err = c.VerifyCheckpoint(checkpoint)
+	assert.Nil(t, err, "VerifyCheckpoint should not return an error")
+
+	node, err := c.GetNodeByPath("/foo")
+	assert.Nil(t, err, "GetNodeByPath should not return an error")
+	err = node.SetLiteral("changed", prvKey)
+	assert.Nil(t, err, "SetLiteral should not return an error")
+
+	err = c.VerifyCheckpoint(checkpoint)
+	assert.NotNil(t, err, "VerifyCheckpoint should fail after the tree changed")
+
+	checkpoint, err = c.SignCheckpoint(prvKey)
+	assert.Nil(t, err, "SignCheckpoint should not return an error")
+	checkpoint.Owner = "ff4d4028f7a41edca91c01d17da4c4c3edb18950ac98b465cb918ad5362c5bdc"
+	err = c.VerifyCheckpoint(checkpoint)
+	assert.NotNil(t, err, "VerifyCheckpoint should fail for a forged owner")
+}
@@ -0,0 +1,135 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Saved trees carry a formatVersion. Load upgrades older layouts by running the registered migrations in
+// order, each migration rewrites the generic JSON structure from one version to the next. A migration
+// that changes what goes into a node signature digest returns the affected nodes, their signatures are
+// then invalid and must be renewed by their owners, see AdapterSecureTreeCRDT.Migrate. Files written
+// before versioning was introduced have no formatVersion and are treated as version 0.
+
+const FormatVersion = 1
+
+type Migration struct {
+	From        int
+	Description string
+	Migrate     func(raw map[string]interface{}) ([]NodeID, error) // Returns the nodes whose signature digest changed
+}
+
+type MigrationReport struct {
+	FromVersion int      `json:"fromVersion"`
+	ToVersion   int      `json:"toVersion"`
+	Applied     []string `json:"applied"`
+	Resign      []NodeID `json:"resign"`               // Nodes whose signature digest changed
+	Resigned    []NodeID `json:"resigned,omitempty"`   // Nodes re-signed by Migrate
+	Unresolved  []NodeID `json:"unresolved,omitempty"` // Nodes that must be re-signed by another owner
+}
+
+var migrations = make(map[int]*Migration)
+
+func registerMigration(m *Migration) {
+	if _, ok := migrations[m.From]; ok {
+		panic(fmt.Sprintf("migration from format version %d registered twice", m.From))
+	}
+	migrations[m.From] = m
+}
+
+func init() {
+	registerMigration(&Migration{
+		From:        0,
+		Description: "rename litteralValue to literalValue",
+		Migrate: func(raw map[string]interface{}) ([]NodeID, error) {
+			nodes, ok := raw["nodes"].(map[string]interface{})
+			if !ok {
+				return nil, nil // Reported by the decoder
+			}
+			for _, val := range nodes {
+				if nodeMap, ok := val.(map[string]interface{}); ok {
+					if v, ok := nodeMap["litteralValue"]; ok {
+						nodeMap["literalValue"] = v
+						delete(nodeMap, "litteralValue")
+					}
+				}
+			}
+			// The node digest has its own field names, so no signatures are affected
+			return nil, nil
+		},
+	})
+}
+
+func formatVersion(raw map[string]interface{}) (int, error) {
+	v, ok := raw["formatVersion"]
+	if !ok {
+		return 0, nil
+	}
+	return intValue(v, "formatVersion", float64(1<<31))
+}
+
+// migrate upgrades raw to the target version with the given registry
+func migrate(raw map[string]interface{}, registry map[int]*Migration, target int) (*MigrationReport, error) {
+	version, err := formatVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	if version > target {
+		return nil, fmt.Errorf("formatVersion: file format version %d is newer than the supported version %d", version, target)
+	}
+
+	report := &MigrationReport{FromVersion: version, ToVersion: target, Applied: []string{}, Resign: []NodeID{}}
+	resign := make(map[NodeID]bool)
+	for ; version < target; version++ {
+		m, ok := registry[version]
+		if !ok {
+			return nil, fmt.Errorf("formatVersion: no migration from file format version %d", version)
+		}
+
+		nodeIDs, err := m.Migrate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to migrate from file format version %d: %w", version, err)
+		}
+		for _, id := range nodeIDs {
+			resign[id] = true
+		}
+		report.Applied = append(report.Applied, m.Description)
+
+		log.WithFields(log.Fields{
+			"From":        version,
+			"To":          version + 1,
+			"Description": m.Description,
+			"Resign":      len(nodeIDs),
+		}).Debug("Migrated file format")
+	}
+	raw["formatVersion"] = float64(target)
+
+	for id := range resign {
+		report.Resign = append(report.Resign, id)
+	}
+	sort.Slice(report.Resign, func(i, j int) bool { return report.Resign[i] < report.Resign[j] })
+
+	return report, nil
+}
+
+// resign renews the signatures of the given nodes that are owned by the identity
+func (c *TreeCRDT) resign(nodeIDs []NodeID, identity *crypto.Idendity) (resigned []NodeID, unresolved []NodeID, err error) {
+	for _, id := range nodeIDs {
+		node, ok := c.Nodes[id]
+		if !ok {
+			continue // Removed by a later migration
+		}
+		if string(node.Owner) != identity.ID() {
+			unresolved = append(unresolved, id)
+			continue
+		}
+		if err := node.Sign(identity); err != nil {
+			return nil, nil, fmt.Errorf("Failed to re-sign node %s: %w", id, err)
+		}
+		resigned = append(resigned, id)
+	}
+	return resigned, unresolved, nil
+}
@@ -0,0 +1,153 @@
+package crdt
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyLayout rewrites saved data to the layout used before format versions were introduced
+func legacyLayout(t *testing.T, data []byte) []byte {
+	var raw map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &raw))
+	delete(raw, "formatVersion")
+	for _, val := range raw["nodes"].(map[string]interface{}) {
+		nodeMap := val.(map[string]interface{})
+		nodeMap["litteralValue"] = nodeMap["literalValue"]
+		delete(nodeMap, "literalValue")
+	}
+	buf, err := json.Marshal(raw)
+	assert.Nil(t, err)
+	return buf
+}
+
+func TestSecureTreeMigrate(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"name": "Alice", "age": 42}`), prvKey)
+	assert.Nil(t, err)
+
+	data, err := c1.Save()
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"formatVersion": 1`)
+
+	c2, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	report, err := c2.Migrate(legacyLayout(t, data), prvKey)
+	assert.Nil(t, err, "Migrate should not return an error")
+	assert.Equal(t, 0, report.FromVersion)
+	assert.Equal(t, FormatVersion, report.ToVersion)
+	assert.Len(t, report.Applied, 1)
+	assert.Empty(t, report.Resign, "Renaming a field in the layout should not require re-signing")
+
+	assert.Nil(t, c2.VerifyTree())
+	value, err := c2.GetValueByPath("/age")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(42), value)
+
+	migrated, err := c2.Save()
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), string(migrated), "A migrated tree should save like a current tree")
+
+	t.Run("Load migrates", func(t *testing.T) {
+		c3, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+		err = c3.Load(legacyLayout(t, data))
+		assert.Nil(t, err)
+		assert.Nil(t, c3.VerifyTree())
+	})
+
+	t.Run("Reject newer versions", func(t *testing.T) {
+		var raw map[string]interface{}
+		assert.Nil(t, json.Unmarshal(data, &raw))
+		raw["formatVersion"] = FormatVersion + 1
+		newer, err := json.Marshal(raw)
+		assert.Nil(t, err)
+
+		c3, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+		err = c3.Load(newer)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "newer than the supported version")
+	})
+}
+
+func TestLoadLegacyViewerFiles(t *testing.T) {
+	for _, file := range []string{"iot_crdt.json", "k8s_crdt.json", "dpp_crdt.json"} {
+		data, err := os.ReadFile("../../viewer/" + file)
+		assert.Nil(t, err)
+
+		c := newTreeCRDT()
+		err = c.Load(data)
+		assert.Nil(t, err, "Files saved before format versions should load, %s", file)
+
+		_, err = c.ExportJSON()
+		assert.Nil(t, err)
+	}
+}
+
+func TestMigrationResign(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+	other, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"a": "1", "b": "2"}`), prvKey)
+	assert.Nil(t, err)
+	tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+
+	a, err := tree.GetNodeByPath("/a")
+	assert.Nil(t, err)
+	b, err := tree.GetNodeByPath("/b")
+	assert.Nil(t, err)
+
+	// A migration to a hypothetical version 2 that changes the digest of node a
+	registry := map[int]*Migration{
+		0: migrations[0],
+		1: {
+			From:        1,
+			Description: "change digest",
+			Migrate: func(raw map[string]interface{}) ([]NodeID, error) {
+				nodeMap := raw["nodes"].(map[string]interface{})[string(a.ID)].(map[string]interface{})
+				nodeMap["literalValue"] = "changed"
+				return []NodeID{a.ID, b.ID}, nil
+			},
+		},
+	}
+
+	data, err := tree.Save()
+	assert.Nil(t, err)
+	var raw map[string]interface{}
+	assert.Nil(t, json.Unmarshal(legacyLayout(t, data), &raw))
+
+	report, err := migrate(raw, registry, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.FromVersion)
+	assert.Equal(t, []string{"rename litteralValue to literalValue", "change digest"}, report.Applied)
+	assert.ElementsMatch(t, []NodeID{a.ID, b.ID}, report.Resign)
+
+	_, err = migrate(raw, map[int]*Migration{}, 3)
+	assert.NotNil(t, err, "A missing migration should be reported")
+
+	// Simulate the changed digest and re-sign
+	a.LiteralValue = "changed"
+	b.Owner = ClientID(other.ID())
+	assert.NotNil(t, tree.VerifyTree())
+
+	resigned, unresolved, err := tree.resign(report.Resign, identity)
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{a.ID}, resigned)
+	assert.Equal(t, []NodeID{b.ID}, unresolved, "Nodes owned by others cannot be re-signed")
+
+	recoveredID, err := a.Verify()
+	assert.Nil(t, err)
+	assert.Equal(t, identity.ID(), recoveredID)
+}
@@ -0,0 +1,260 @@
+package crdt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Proof shows that a literal, together with its owner and signature, is part of a tree with a
+// known root hash. It contains one step per ancestor, starting with the parent of the literal and
+// ending with the root. Each step lists the hashes of all children of that ancestor except the
+// child on the path, which the verifier computes itself. Deleted siblings are marked, so that array
+// indexes in the path count only the visible items like GetNodeByPath does. The flags are covered by
+// the hash of the parent, so they cannot be changed without changing the root hash.
+
+type ProofSibling struct {
+	Label   string `json:"label"`
+	Hash    string `json:"hash,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+type ProofStep struct {
+	NodeID    NodeID         `json:"nodeid"`
+	IsRoot    bool           `json:"isroot"`
+	IsMap     bool           `json:"ismap"`
+	IsArray   bool           `json:"isarray"`
+	IsDeleted bool           `json:"deleted"`
+	Children  []ProofSibling `json:"children"`
+	Index     int            `json:"index"`
+}
+
+type Proof struct {
+	NodeID    NodeID          `json:"nodeid"`
+	Value     interface{}     `json:"value"`
+	Owner     ClientID        `json:"owner"`
+	Nounce    string          `json:"nounce"`
+	Signature string          `json:"signature"`
+	Approved  *ProposedChange `json:"approved,omitempty"`
+	Pending   *ProposedChange `json:"pending,omitempty"`
+	Steps     []ProofStep     `json:"steps"`
+}
+
+func (c *TreeCRDT) Prove(path string) (*Proof, error) {
+	node, err := c.GetNodeByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if !node.IsLiteral {
+		return nil, fmt.Errorf("Cannot create proof, node at path '%s' is not a literal", path)
+	}
+	if node.IsDeleted {
+		return nil, fmt.Errorf("Cannot create proof, node at path '%s' is deleted", path)
+	}
+
+	proof := &Proof{
+		NodeID:    node.ID,
+		Value:     node.LiteralValue,
+		Owner:     node.Owner,
+		Nounce:    node.Nounce,
+		Signature: node.Signature,
+		Approved:  node.Approved,
+		Pending:   node.Pending,
+		Steps:     make([]ProofStep, 0),
+	}
+
+	current := node
+	for !current.IsRoot {
+		if len(proof.Steps) > len(c.Nodes) {
+			return nil, fmt.Errorf("Cannot create proof, cycle detected at node %s", current.ID)
+		}
+
+		parent, ok := c.Nodes[current.ParentID]
+		if !ok {
+			return nil, fmt.Errorf("Cannot create proof, parent %s not found for node %s", current.ParentID, current.ID)
+		}
+
+		step := ProofStep{
+			NodeID:    parent.ID,
+			IsRoot:    parent.IsRoot,
+			IsMap:     parent.IsMap,
+			IsArray:   parent.IsArray,
+			IsDeleted: parent.IsDeleted,
+			Children:  make([]ProofSibling, 0, len(parent.Edges)),
+			Index:     -1,
+		}
+		for i, edge := range parent.orderedEdges() {
+			sibling := ProofSibling{Label: edge.Label}
+			if edge.To == current.ID {
+				step.Index = i
+				sibling.Deleted = current.IsDeleted
+			} else {
+				child, ok := c.Nodes[edge.To]
+				if !ok {
+					return nil, fmt.Errorf("Cannot create proof, child node %s of %s not found", edge.To, parent.ID)
+				}
+				sibling.Hash, err = child.Hash()
+				if err != nil {
+					return nil, err
+				}
+				sibling.Deleted = child.IsDeleted
+			}
+			step.Children = append(step.Children, sibling)
+		}
+		if step.Index < 0 {
+			return nil, fmt.Errorf("Cannot create proof, node %s is not a child of %s", current.ID, parent.ID)
+		}
+
+		proof.Steps = append(proof.Steps, step)
+		current = parent
+	}
+
+	if current != c.Root {
+		return nil, fmt.Errorf("Cannot create proof, node at path '%s' is not attached to the root", path)
+	}
+
+	return proof, nil
+}
+
+// VerifyProof checks that the literal in the proof is located at the given path in a tree with the
+// given root hash. If the literal is signed, the signature must also match the owner in the proof.
+func VerifyProof(rootHash string, path string, proof *Proof) error {
+	if proof == nil {
+		return fmt.Errorf("Proof is nil")
+	}
+	if len(proof.Steps) == 0 || !proof.Steps[len(proof.Steps)-1].IsRoot {
+		return fmt.Errorf("Proof does not end at a root node")
+	}
+
+	leaf := &NodeCRDT{
+		ID:           proof.NodeID,
+		IsLiteral:    true,
+		LiteralValue: proof.Value,
+		Owner:        proof.Owner,
+		Nounce:       proof.Nounce,
+		Signature:    proof.Signature,
+		Approved:     proof.Approved,
+		Pending:      proof.Pending,
+	}
+
+	if proof.Signature != "" {
+		if _, err := leaf.Verify(); err != nil {
+			return fmt.Errorf("Proof literal signature invalid: %w", err)
+		}
+	}
+
+	if err := verifyProofPath(path, proof.Steps); err != nil {
+		return err
+	}
+
+	content, err := leaf.contentHash()
+	if err != nil {
+		return err
+	}
+	hash := combineMerkleHash(content, nil, nil, nil)
+	pathDeleted := false // Proofs are only created for literals that are not deleted
+
+	for i, step := range proof.Steps {
+		if step.IsRoot != (i == len(proof.Steps)-1) {
+			return fmt.Errorf("Proof step %d has an invalid root flag", i)
+		}
+
+		labels := make([]string, len(step.Children))
+		deleted := make([]bool, len(step.Children))
+		childHashes := make([][]byte, len(step.Children))
+		for j, sibling := range step.Children {
+			labels[j] = sibling.Label
+			deleted[j] = sibling.Deleted
+			if j == step.Index {
+				if sibling.Deleted != pathDeleted {
+					return fmt.Errorf("Proof step %d has an invalid deleted flag for the path child", i)
+				}
+				childHashes[j] = hash
+				continue
+			}
+			childHashes[j], err = hex.DecodeString(sibling.Hash)
+			if err != nil {
+				return fmt.Errorf("Proof step %d has an invalid child hash: %w", i, err)
+			}
+			// Child hashes are not length prefixed, other lengths could shift bytes into the next label
+			if len(childHashes[j]) != merkleHashSize {
+				return fmt.Errorf("Proof step %d has a child hash of %d bytes, expected %d", i, len(childHashes[j]), merkleHashSize)
+			}
+		}
+
+		stepNode := &NodeCRDT{
+			ID:        step.NodeID,
+			IsRoot:    step.IsRoot,
+			IsMap:     step.IsMap,
+			IsArray:   step.IsArray,
+			IsDeleted: step.IsDeleted,
+		}
+		content, err := stepNode.contentHash()
+		if err != nil {
+			return err
+		}
+		hash = combineMerkleHash(content, labels, deleted, childHashes)
+		pathDeleted = step.IsDeleted
+	}
+
+	if hex.EncodeToString(hash) != rootHash {
+		return fmt.Errorf("Proof does not match root hash %s", rootHash)
+	}
+
+	return nil
+}
+
+// verifyProofPath checks that the steps follow the path the same way GetNodeByPath resolves it
+func verifyProofPath(path string, steps []ProofStep) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path must start with a slash: %s", path)
+	}
+
+	parts, err := splitJSONPointer(path)
+	if err != nil {
+		return err
+	}
+	if path == "/" {
+		parts = []string{}
+	}
+
+	consumed := 0
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Index < 0 || step.Index >= len(step.Children) {
+			return fmt.Errorf("Proof step %d has an invalid child index %d", i, step.Index)
+		}
+
+		// The root is skipped when it only wraps a single child
+		if step.IsRoot && len(step.Children) == 1 {
+			continue
+		}
+
+		if consumed >= len(parts) {
+			return fmt.Errorf("Proof is deeper than path '%s'", path)
+		}
+		part := parts[consumed]
+		consumed++
+
+		if step.IsArray {
+			index := 0
+			for _, sibling := range step.Children[:step.Index] {
+				if !sibling.Deleted {
+					index++
+				}
+			}
+			if strconv.Itoa(index) != part {
+				return fmt.Errorf("Proof index %d does not match path part '%s'", index, part)
+			}
+		} else if step.Children[step.Index].Label != part {
+			return fmt.Errorf("Proof label '%s' does not match path part '%s'", step.Children[step.Index].Label, part)
+		}
+	}
+
+	if consumed != len(parts) {
+		return fmt.Errorf("Proof is shallower than path '%s'", path)
+	}
+
+	return nil
+}
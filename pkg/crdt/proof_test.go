@@ -0,0 +1,190 @@
+package crdt
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeProve(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	passportJSON := []byte(`{
+		"product": "battery",
+		"materials": [
+			{"name": "lithium", "origin": "Chile"},
+			{"name": "cobalt", "origin": "Congo"}
+		],
+		"carbon": 61.5
+	}`)
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+
+	_, err = c.ImportJSON(passportJSON, prvKey)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	rootHash, err := c.RootHash()
+	assert.Nil(t, err, "RootHash should not return an error")
+
+	t.Run("Verify proof of nested literal", func(t *testing.T) {
+		proof, err := c.Prove("/materials/1/origin")
+		assert.Nil(t, err, "Prove should not return an error")
+		assert.Equal(t, "Congo", proof.Value)
+		assert.NotEmpty(t, proof.Signature)
+
+		// The proof is handed to a partner as JSON
+		proofJSON, err := json.Marshal(proof)
+		assert.Nil(t, err)
+		received := &Proof{}
+		err = json.Unmarshal(proofJSON, received)
+		assert.Nil(t, err)
+
+		err = VerifyProof(rootHash, "/materials/1/origin", received)
+		assert.Nil(t, err, "VerifyProof should not return an error")
+	})
+
+	t.Run("Verify proof of number literal", func(t *testing.T) {
+		proof, err := c.Prove("/carbon")
+		assert.Nil(t, err, "Prove should not return an error")
+
+		err = VerifyProof(rootHash, "/carbon", proof)
+		assert.Nil(t, err, "VerifyProof should not return an error")
+	})
+
+	t.Run("Reject proof with tampered value", func(t *testing.T) {
+		proof, err := c.Prove("/materials/1/origin")
+		assert.Nil(t, err)
+
+		proof.Value = "Norway"
+		err = VerifyProof(rootHash, "/materials/1/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a tampered value")
+
+		proof.Signature = ""
+		err = VerifyProof(rootHash, "/materials/1/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a tampered value without signature")
+	})
+
+	t.Run("Reject proof with a sibling hash of another length", func(t *testing.T) {
+		proof, err := c.Prove("/product")
+		assert.Nil(t, err)
+
+		// Fold two neighbouring siblings into one long hash, the hashed bytes stay the same
+		step := &proof.Steps[0]
+		assert.Len(t, step.Children, 3)
+		assert.NotEqual(t, 1, step.Index, "The proven key should not be between its siblings")
+		j := 0
+		if step.Index == 0 {
+			j = 1
+		}
+		label := make([]byte, 4)
+		binary.BigEndian.PutUint32(label, uint32(len(step.Children[j+1].Label)))
+		folded := step.Children[j].Hash + hex.EncodeToString(label) + hex.EncodeToString([]byte(step.Children[j+1].Label)) + step.Children[j+1].Hash
+		step.Children[j].Hash = folded
+		step.Children = append(step.Children[:j+1], step.Children[j+2:]...)
+		if step.Index > j {
+			step.Index--
+		}
+
+		err = VerifyProof(rootHash, "/product", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a sibling hash that is not 32 bytes")
+	})
+
+	t.Run("Reject proof for wrong path", func(t *testing.T) {
+		proof, err := c.Prove("/materials/1/origin")
+		assert.Nil(t, err)
+
+		err = VerifyProof(rootHash, "/materials/0/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a different array index")
+
+		err = VerifyProof(rootHash, "/materials/1/name", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a different key")
+
+		err = VerifyProof(rootHash, "/materials/1", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a shorter path")
+	})
+
+	t.Run("Reject proof for stale root hash", func(t *testing.T) {
+		node, err := c.GetNodeByPath("/product")
+		assert.Nil(t, err)
+		err = node.SetLiteral("cell", prvKey)
+		assert.Nil(t, err)
+
+		proof, err := c.Prove("/materials/1/origin")
+		assert.Nil(t, err)
+
+		err = VerifyProof(rootHash, "/materials/1/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail against an old root hash")
+
+		newRootHash, err := c.RootHash()
+		assert.Nil(t, err)
+		err = VerifyProof(newRootHash, "/materials/1/origin", proof)
+		assert.Nil(t, err, "VerifyProof should succeed against the current root hash")
+	})
+
+	t.Run("Reject proof with a forged deleted flag", func(t *testing.T) {
+		currentRootHash, err := c.RootHash()
+		assert.Nil(t, err)
+		proof, err := c.Prove("/materials/1/origin")
+		assert.Nil(t, err)
+		assert.Nil(t, VerifyProof(currentRootHash, "/materials/1/origin", proof))
+
+		// Marking the earlier item deleted would shift the index of the proven item
+		step := &proof.Steps[1]
+		assert.True(t, step.IsArray)
+		assert.Equal(t, 1, step.Index)
+		step.Children[0].Deleted = true
+		err = VerifyProof(currentRootHash, "/materials/0/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a forged deleted flag")
+		err = VerifyProof(currentRootHash, "/materials/1/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for a forged deleted flag")
+	})
+
+	t.Run("Verify proof with a deleted sibling", func(t *testing.T) {
+		// Array indexes in the path only count the visible items
+		tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+		lithium, err := tree.GetNodeByPath("/materials/0")
+		assert.Nil(t, err)
+		assert.Nil(t, lithium.MarkDeleted(ClientID("client1")))
+
+		proof, err := c.Prove("/materials/0/origin")
+		assert.Nil(t, err)
+		assert.Equal(t, "Congo", proof.Value)
+
+		newRootHash, err := c.RootHash()
+		assert.Nil(t, err)
+		err = VerifyProof(newRootHash, "/materials/0/origin", proof)
+		assert.Nil(t, err, "VerifyProof should skip deleted siblings when matching array indexes")
+
+		err = VerifyProof(newRootHash, "/materials/1/origin", proof)
+		assert.NotNil(t, err, "VerifyProof should fail for the stored index")
+	})
+
+	t.Run("Reject proof of non literal", func(t *testing.T) {
+		_, err := c.Prove("/materials/1")
+		assert.NotNil(t, err, "Prove should fail for a map node")
+	})
+}
+
+func TestSecureTreeProveEscapedPath(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"a/b": {"c~d": "value"}, "e": 1}`), prvKey)
+	assert.Nil(t, err)
+
+	rootHash, err := c.RootHash()
+	assert.Nil(t, err)
+	proof, err := c.Prove("/a~1b/c~0d")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", proof.Value)
+
+	err = VerifyProof(rootHash, "/a~1b/c~0d", proof)
+	assert.Nil(t, err, "VerifyProof should unescape the path like GetNodeByPath")
+	err = VerifyProof(rootHash, "/a/b/c~d", proof)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,95 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReconcileJSON updates the value at path to the document with as few changes as possible, unlike
+// ImportJSON the nodes of unchanged parts are kept together with their history. The changes are computed
+// like DiffAsJSONPatch and applied as a JSON patch, arrays keep their longest common subsequence of items.
+// The path is a JSON pointer, "" and "/" refer to the whole document. A missing member is added if its
+// parent exists. The applied operations are returned, an empty list if the value was already up to date.
+func (c *TreeCRDT) ReconcileJSON(path string, doc []byte, identity *crypto.Idendity) ([]*JSONPatchOperation, error) {
+	return c.reconcileJSON(path, doc, identity, nil)
+}
+
+func (c *TreeCRDT) reconcileJSON(path string, doc []byte, identity *crypto.Idendity, check patchCheck) ([]*JSONPatchOperation, error) {
+	if path == "/" {
+		path = ""
+	}
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var desired interface{}
+	if err := json.Unmarshal(doc, &desired); err != nil {
+		return nil, fmt.Errorf("Failed to parse JSON document: %w", err)
+	}
+
+	current, exists, err := c.reconcileTarget(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*JSONPatchOperation, 0)
+	if exists {
+		ops, err = diffJSON(path, current, desired, ops)
+	} else {
+		op := "add"
+		if len(tokens) == 0 {
+			op = "replace"
+		}
+		ops, err = appendValueOp(ops, op, path, desired)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ops) > 0 {
+		if err := c.applyPatch(ops, identity, check); err != nil {
+			return nil, err
+		}
+	}
+
+	log.WithFields(log.Fields{"Path": path, "Operations": len(ops), "ClientID": identity.ID()}).Debug("JSON document reconciled")
+
+	return ops, nil
+}
+
+// reconcileTarget returns the current value at the path, or false if the path does not exist yet
+func (c *TreeCRDT) reconcileTarget(tokens []string) (interface{}, bool, error) {
+	p := &jsonPatcher{tree: c}
+	doc, err := p.document()
+	if err != nil {
+		return nil, false, err
+	}
+	if doc == nil {
+		if len(tokens) > 0 {
+			return nil, false, fmt.Errorf("document is empty, cannot reconcile into /%s", escapeJSONPointer(tokens[0]))
+		}
+		return nil, false, nil
+	}
+
+	node := doc
+	if len(tokens) > 0 {
+		parent, err := p.resolve(tokens[:len(tokens)-1])
+		if err != nil {
+			return nil, false, err
+		}
+		var ok bool
+		if node, ok, err = p.child(parent, tokens[len(tokens)-1]); err != nil || !ok {
+			return nil, false, err
+		}
+	}
+
+	value, err := p.value(node)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
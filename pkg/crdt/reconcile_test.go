@@ -0,0 +1,122 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileJSON(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"name": "svc", "replicas": 2, "ports": [80, 443], "env": {"A": "1", "B": "2"}}`), prvKey)
+	assert.Nil(t, err)
+
+	nodeID := func(path string) NodeID {
+		node, err := c.GetNodeByPath(path)
+		assert.Nil(t, err)
+		return node.ID()
+	}
+	name, replicas, port, env := nodeID("/name"), nodeID("/replicas"), nodeID("/ports/1"), nodeID("/env")
+
+	desired := `{"name": "svc", "replicas": 3, "ports": [8080, 80, 443], "env": {"A": "1", "C": "3"}}`
+	ops, err := c.ReconcileJSON("/", []byte(desired), prvKey)
+	assert.Nil(t, err, "ReconcileJSON should not return an error")
+	assert.Len(t, ops, 4, "Only the changes should be applied")
+	assert.Equal(t, jsonValue(t, desired), exportedValue(t, c))
+
+	assert.Equal(t, name, nodeID("/name"), "Unchanged nodes should be kept")
+	assert.Equal(t, replicas, nodeID("/replicas"), "Updated literals should keep their node")
+	assert.Equal(t, port, nodeID("/ports/2"), "Array items should keep their node when items are inserted before them")
+	assert.Equal(t, env, nodeID("/env"))
+
+	c.Tidy()
+	assert.Nil(t, c.VerifyTree())
+
+	ops, err = c.ReconcileJSON("", []byte(desired), prvKey)
+	assert.Nil(t, err)
+	assert.Empty(t, ops, "Reconciling an unchanged document should not change anything")
+
+	t.Run("Reconcile a subtree", func(t *testing.T) {
+		ops, err := c.ReconcileJSON("/env", []byte(`{"A": "1", "C": "4"}`), prvKey)
+		assert.Nil(t, err)
+		assert.Len(t, ops, 1)
+
+		value, err := c.GetValueByPath("/env/C")
+		assert.Nil(t, err)
+		assert.Equal(t, "4", value)
+	})
+
+	t.Run("Reconcile into a missing member", func(t *testing.T) {
+		_, err := c.ReconcileJSON("/limits", []byte(`{"cpu": 1}`), prvKey)
+		assert.Nil(t, err)
+
+		value, err := c.GetValueByPath("/limits/cpu")
+		assert.Nil(t, err)
+		assert.Equal(t, float64(1), value)
+
+		_, err = c.ReconcileJSON("/missing/cpu", []byte(`1`), prvKey)
+		assert.NotNil(t, err, "The parent of the path must exist")
+	})
+
+	t.Run("Reconcile into an empty tree", func(t *testing.T) {
+		c2, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+
+		_, err = c2.ReconcileJSON("/", []byte(`{"a": [1, 2]}`), prvKey)
+		assert.Nil(t, err)
+		assert.Equal(t, jsonValue(t, `{"a": [1, 2]}`), exportedValue(t, c2))
+		assert.Nil(t, c2.VerifyTree())
+	})
+}
+
+func TestReconcileJSONConcurrent(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"config": {"level": "info", "items": ["a", "b"]}}`), prvKey)
+	assert.Nil(t, err)
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	// Both replicas re-import the same edited document
+	desired := []byte(`{"config": {"level": "debug", "items": ["a", "b"]}}`)
+	_, err = c1.ReconcileJSON("/", desired, prvKey)
+	assert.Nil(t, err)
+	_, err = c2.ReconcileJSON("/", desired, prvKey)
+	assert.Nil(t, err)
+
+	err = c1.Merge(c2, prvKey)
+	assert.Nil(t, err)
+	assert.Equal(t, jsonValue(t, string(desired)), exportedValue(t, c1), "Concurrent re-imports should not duplicate nodes")
+}
+
+func TestDiffArray(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+	}{
+		{`[1, 2, 3]`, `[0, 1, 2, 3]`},
+		{`[1, 2, 3]`, `[1, 3]`},
+		{`[1, 2, 3, 4]`, `[4, 3, 2, 1]`},
+		{`[{"a": 1}, {"b": 2}]`, `[{"a": 2}, {"b": 2}, {"c": 3}]`},
+		{`[]`, `[1, 2]`},
+		{`[1, 2]`, `[]`},
+	}
+
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	for _, test := range tests {
+		c, err := NewSecureTree(prvKey)
+		assert.Nil(t, err)
+		_, err = c.ImportJSON([]byte(`{"arr": `+test.from+`}`), prvKey)
+		assert.Nil(t, err)
+
+		_, err = c.ReconcileJSON("/arr", []byte(test.to), prvKey)
+		assert.Nil(t, err, "%s -> %s", test.from, test.to)
+		assert.Equal(t, jsonValue(t, `{"arr": `+test.to+`}`), exportedValue(t, c), "%s -> %s", test.from, test.to)
+	}
+}
@@ -0,0 +1,460 @@
+package crdt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// An ownerID is the hash of a single public key, so a new key is a new identity. A KeyRotation links
+// an old key to a new key so that both are treated as the same principal: ABAC rules granted to any
+// key of the principal apply to all of its keys, and clock versions continue where the old key stopped.
+//
+// A rotation is signed by the new key and by the old key. If the old key is compromised, the owner can
+// instead sign with a recovery key that was committed to beforehand with a RecoveryCommitment, and such
+// a recovery rotation wins over any rotation of the same key made with the old key. A key should only
+// commit to one recovery key. If replicas bring conflicting commitments, the commitment with the lowest
+// signature wins on every replica and the key is marked as disputed, since the conflict means that
+// someone else may hold the key.
+//
+// The rotation lists the signatures the old key made before the rotation. After the rotation, nodes and
+// policies signed by the old key are only accepted if their signature is in that list, so a rotation
+// should be made after synchronising with the other replicas.
+
+type KeyRotation struct {
+	OldID         string            `json:"oldid"`
+	NewID         string            `json:"newid"`
+	Recovery      bool              `json:"recovery"`
+	Retired       map[NodeID]string `json:"retired"`
+	RetiredPolicy string            `json:"retiredpolicy,omitempty"`
+	OldSignature  string            `json:"oldsignature"`
+	NewSignature  string            `json:"newsignature"`
+}
+
+type RecoveryCommitment struct {
+	ID         string `json:"id"`
+	RecoveryID string `json:"recoveryid"`
+	Signature  string `json:"signature"`
+}
+
+type keyIndex struct {
+	rotatedTo   map[string]*KeyRotation // old ID -> winning rotation
+	rotatedFrom map[string]string       // new ID -> old ID
+	recovery    map[string]string       // ID -> recovery ID of the winning commitment
+	disputed    map[string]bool         // IDs with conflicting commitments
+}
+
+func (kr *KeyRotation) digest() (*crypto.Hash, error) {
+	buf, err := json.Marshal(struct {
+		OldID         string            `json:"oldid"`
+		NewID         string            `json:"newid"`
+		Recovery      bool              `json:"recovery"`
+		Retired       map[NodeID]string `json:"retired"`
+		RetiredPolicy string            `json:"retiredpolicy"`
+	}{kr.OldID, kr.NewID, kr.Recovery, kr.Retired, kr.RetiredPolicy})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal key rotation: %w", err)
+	}
+	return crypto.GenerateHashFromString("keyrotation:" + string(buf)), nil
+}
+
+func (rc *RecoveryCommitment) digest() *crypto.Hash {
+	return crypto.GenerateHashFromString("recoverycommitment:" + rc.ID + ":" + rc.RecoveryID)
+}
+
+func signDigest(digest *crypto.Hash, identity *crypto.Idendity) (string, error) {
+	signature, err := crypto.Sign(digest, identity.PrivateKey())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+func verifySignedBy(digest *crypto.Hash, signature string, expectedID string) error {
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("Failed to decode signature: %w", err)
+	}
+	recoveredID, err := crypto.RecoveredID(digest, signatureBytes)
+	if err != nil {
+		return fmt.Errorf("Failed to recover ID from signature: %w", err)
+	}
+	if recoveredID != expectedID {
+		return fmt.Errorf("Recovered ID %s does not match %s", recoveredID, expectedID)
+	}
+	return nil
+}
+
+func (rc *RecoveryCommitment) verify() error {
+	if err := verifySignedBy(rc.digest(), rc.Signature, rc.ID); err != nil {
+		return fmt.Errorf("Invalid recovery commitment for %s: %w", rc.ID, err)
+	}
+	return nil
+}
+
+func (c *TreeCRDT) verifyKeyRotation(kr *KeyRotation) error {
+	if kr.OldID == kr.NewID {
+		return fmt.Errorf("Invalid key rotation, old and new key are the same")
+	}
+
+	digest, err := kr.digest()
+	if err != nil {
+		return err
+	}
+
+	if err := verifySignedBy(digest, kr.NewSignature, kr.NewID); err != nil {
+		return fmt.Errorf("Invalid key rotation from %s, new key signature: %w", kr.OldID, err)
+	}
+
+	if !kr.Recovery {
+		if err := verifySignedBy(digest, kr.OldSignature, kr.OldID); err != nil {
+			return fmt.Errorf("Invalid key rotation from %s, old key signature: %w", kr.OldID, err)
+		}
+		return nil
+	}
+
+	// A recovery made with the key of a losing commitment stays valid, but it does not win
+	recoveryIDs := c.committedRecoveryIDs(kr.OldID)
+	if len(recoveryIDs) == 0 {
+		return fmt.Errorf("Invalid key recovery of %s, no recovery key has been committed", kr.OldID)
+	}
+	for _, recoveryID := range recoveryIDs {
+		if err = verifySignedBy(digest, kr.OldSignature, recoveryID); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid key rotation from %s, old key signature: %w", kr.OldID, err)
+}
+
+func (c *TreeCRDT) committedRecoveryIDs(id string) []string {
+	var recoveryIDs []string
+	for _, rc := range c.RecoveryCommitments {
+		if rc.ID == id && !containsID(recoveryIDs, rc.RecoveryID) {
+			recoveryIDs = append(recoveryIDs, rc.RecoveryID)
+		}
+	}
+	return recoveryIDs
+}
+
+func (c *TreeCRDT) addRecoveryCommitment(rc *RecoveryCommitment) error {
+	if err := rc.verify(); err != nil {
+		return err
+	}
+	for _, existing := range c.RecoveryCommitments {
+		if existing.ID != rc.ID {
+			continue
+		}
+		if existing.RecoveryID == rc.RecoveryID {
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"ID":         rc.ID,
+			"RecoveryID": rc.RecoveryID,
+			"Existing":   existing.RecoveryID,
+		}).Warn("Conflicting recovery commitment, the key is disputed")
+	}
+	c.RecoveryCommitments = append(c.RecoveryCommitments, rc)
+	c.indexKeys()
+	return nil
+}
+
+func (c *TreeCRDT) addKeyRotation(kr *KeyRotation) error {
+	if err := c.verifyKeyRotation(kr); err != nil {
+		return err
+	}
+	for _, existing := range c.KeyRotations {
+		if existing.OldSignature == kr.OldSignature && existing.NewSignature == kr.NewSignature {
+			return nil
+		}
+	}
+	c.KeyRotations = append(c.KeyRotations, kr)
+	c.indexKeys()
+	return nil
+}
+
+// indexKeys picks the winning recovery commitment and rotation of every key. The commitment with the
+// lowest signature wins. Recovery rotations made with the winning recovery key win over normal rotations,
+// then the rotation to the lowest new ID wins.
+func (c *TreeCRDT) indexKeys() {
+	index := &keyIndex{
+		rotatedTo:   make(map[string]*KeyRotation),
+		rotatedFrom: make(map[string]string),
+		recovery:    make(map[string]string),
+		disputed:    make(map[string]bool),
+	}
+
+	winners := make(map[string]*RecoveryCommitment)
+	for _, rc := range c.RecoveryCommitments {
+		winner, ok := winners[rc.ID]
+		if ok && winner.RecoveryID != rc.RecoveryID {
+			index.disputed[rc.ID] = true
+		}
+		if !ok || rc.Signature < winner.Signature {
+			winners[rc.ID] = rc
+		}
+	}
+	for id, rc := range winners {
+		index.recovery[id] = rc.RecoveryID
+	}
+
+	for _, kr := range c.KeyRotations {
+		if kr.Recovery && !signedByRecoveryKey(kr, index.recovery[kr.OldID]) {
+			continue
+		}
+		winner, ok := index.rotatedTo[kr.OldID]
+		if !ok || (kr.Recovery && !winner.Recovery) || (kr.Recovery == winner.Recovery && kr.NewID < winner.NewID) {
+			index.rotatedTo[kr.OldID] = kr
+		}
+	}
+
+	oldIDs := make([]string, 0, len(index.rotatedTo))
+	for oldID := range index.rotatedTo {
+		oldIDs = append(oldIDs, oldID)
+	}
+	sort.Strings(oldIDs)
+	for _, oldID := range oldIDs {
+		newID := index.rotatedTo[oldID].NewID
+		if _, ok := index.rotatedFrom[newID]; !ok {
+			index.rotatedFrom[newID] = oldID
+		}
+	}
+
+	c.keys = index
+}
+
+func signedByRecoveryKey(kr *KeyRotation, recoveryID string) bool {
+	if recoveryID == "" {
+		return false
+	}
+	digest, err := kr.digest()
+	if err != nil {
+		return false
+	}
+	return verifySignedBy(digest, kr.OldSignature, recoveryID) == nil
+}
+
+// DisputedKeys returns the keys that have committed to more than one recovery key, sorted
+func (c *TreeCRDT) DisputedKeys() []string {
+	if c.keys == nil {
+		return []string{}
+	}
+	ids := make([]string, 0, len(c.keys.disputed))
+	for id := range c.keys.disputed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *TreeCRDT) recoveryID(id string) string {
+	if c.keys == nil {
+		return ""
+	}
+	return c.keys.recovery[id]
+}
+
+// keyChain returns all keys of the principal that id belongs to, from the first to the current key
+func (c *TreeCRDT) keyChain(id string) []string {
+	if c.keys == nil || len(c.keys.rotatedTo) == 0 {
+		return []string{id}
+	}
+
+	first := id
+	for steps := 0; steps <= len(c.keys.rotatedFrom); steps++ {
+		oldID, ok := c.keys.rotatedFrom[first]
+		if !ok || oldID == id {
+			break
+		}
+		first = oldID
+	}
+
+	chain := []string{first}
+	seen := map[string]bool{first: true}
+	for current := first; ; {
+		kr, ok := c.keys.rotatedTo[current]
+		if !ok || seen[kr.NewID] || c.keys.rotatedFrom[kr.NewID] != current {
+			break
+		}
+		current = kr.NewID
+		seen[current] = true
+		chain = append(chain, current)
+	}
+
+	return chain
+}
+
+// policySignerAllowed checks if a policy owned by ownerID may be signed by signerID
+func (c *TreeCRDT) policySignerAllowed(ownerID string, signerID string, signature string) bool {
+	if !containsID(c.keyChain(ownerID), signerID) {
+		return false
+	}
+	return c.checkRetiredSignature(signerID, func(kr *KeyRotation) bool { return kr.RetiredPolicy == signature })
+}
+
+func (c *TreeCRDT) checkRetiredSignature(signerID string, retired func(kr *KeyRotation) bool) bool {
+	if c.keys == nil {
+		return true
+	}
+	kr, ok := c.keys.rotatedTo[signerID]
+	if !ok {
+		return true
+	}
+	return retired(kr)
+}
+
+// verifyRetiredKey checks that a node signed by a rotated key was signed before the rotation
+func (c *TreeCRDT) verifyRetiredKey(node *NodeCRDT, signerID string) error {
+	if !c.checkRetiredSignature(signerID, func(kr *KeyRotation) bool { return kr.Retired[node.ID] == node.Signature }) {
+		return fmt.Errorf("Node %s is signed by %s after the key was rotated", node.ID, signerID)
+	}
+	return nil
+}
+
+// clientVersion returns the latest version of any key of the principal in the clock, so that
+// versions continue where a rotated key stopped
+func (c *TreeCRDT) clientVersion(clock VectorClock, clientID ClientID) int {
+	version := clock[clientID]
+	for _, id := range c.keyChain(string(clientID)) {
+		if clock[ClientID(id)] > version {
+			version = clock[ClientID(id)]
+		}
+	}
+	return version
+}
+
+func containsID(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TreeCRDT) CommitRecoveryKey(identity *crypto.Idendity, recoveryID string) error {
+	recoveryID, err := crypto.ResolveID(recoveryID)
+	if err != nil {
+		return fmt.Errorf("Failed to resolve recovery ID: %w", err)
+	}
+	if existing := c.recoveryID(identity.ID()); existing != "" && existing != recoveryID {
+		return fmt.Errorf("A different recovery key has already been committed for %s", identity.ID())
+	}
+
+	rc := &RecoveryCommitment{ID: identity.ID(), RecoveryID: recoveryID}
+	rc.Signature, err = signDigest(rc.digest(), identity)
+	if err != nil {
+		return fmt.Errorf("Failed to sign recovery commitment: %w", err)
+	}
+
+	return c.addRecoveryCommitment(rc)
+}
+
+func (c *TreeCRDT) RotateKey(oldIdentity *crypto.Idendity, newIdentity *crypto.Idendity) (*KeyRotation, error) {
+	return c.rotateKey(oldIdentity.ID(), oldIdentity, newIdentity, false)
+}
+
+// RecoverKey rotates a compromised key to a new key using the recovery key committed for it
+func (c *TreeCRDT) RecoverKey(recoveryIdentity *crypto.Idendity, oldID string, newIdentity *crypto.Idendity) (*KeyRotation, error) {
+	oldID, err := crypto.ResolveID(oldID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve ID: %w", err)
+	}
+	if c.recoveryID(oldID) != recoveryIdentity.ID() {
+		return nil, fmt.Errorf("%s is not the committed recovery key of %s", recoveryIdentity.ID(), oldID)
+	}
+	return c.rotateKey(oldID, recoveryIdentity, newIdentity, true)
+}
+
+func (c *TreeCRDT) rotateKey(oldID string, oldSigner *crypto.Idendity, newIdentity *crypto.Idendity, recovery bool) (*KeyRotation, error) {
+	if c.keys != nil {
+		if winner, ok := c.keys.rotatedTo[oldID]; ok && (winner.Recovery || !recovery) {
+			return nil, fmt.Errorf("Key %s has already been rotated to %s", oldID, winner.NewID)
+		}
+	}
+
+	kr := &KeyRotation{
+		OldID:    oldID,
+		NewID:    newIdentity.ID(),
+		Recovery: recovery,
+		Retired:  make(map[NodeID]string),
+	}
+
+	for _, node := range c.Nodes {
+		if string(node.Owner) != oldID || node.Signature == "" {
+			continue
+		}
+		if recoveredID, err := node.verifyCached(c.sigCache); err == nil && recoveredID == oldID {
+			kr.Retired[node.ID] = node.Signature
+		}
+	}
+
+	if c.ABACPolicy != nil {
+		if recoveredID, err := c.ABACPolicy.Verify(); err == nil && recoveredID == oldID {
+			kr.RetiredPolicy = c.ABACPolicy.Signature
+		}
+	}
+
+	digest, err := kr.digest()
+	if err != nil {
+		return nil, err
+	}
+	kr.OldSignature, err = signDigest(digest, oldSigner)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign key rotation with old key: %w", err)
+	}
+	kr.NewSignature, err = signDigest(digest, newIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign key rotation with new key: %w", err)
+	}
+
+	if err := c.addKeyRotation(kr); err != nil {
+		return nil, err
+	}
+
+	// The policy is signed with the new key from now on
+	if c.ABACPolicy != nil && c.ABACPolicy.identity != nil && c.ABACPolicy.identity.ID() == oldID {
+		c.ABACPolicy.identity = newIdentity
+	}
+
+	log.WithFields(log.Fields{
+		"OldID":    kr.OldID,
+		"NewID":    kr.NewID,
+		"Recovery": kr.Recovery,
+		"Retired":  len(kr.Retired),
+	}).Debug("Key rotated")
+
+	return kr, nil
+}
+
+// mergeKeys adds the recovery commitments and key rotations of another tree
+func (c *TreeCRDT) mergeKeys(c2 *TreeCRDT) error {
+	for _, rc := range c2.RecoveryCommitments {
+		if err := c.addRecoveryCommitment(rc); err != nil {
+			return err
+		}
+	}
+	for _, kr := range c2.KeyRotations {
+		if err := c.addKeyRotation(kr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *TreeCRDT) verifyKeys() error {
+	for _, rc := range c.RecoveryCommitments {
+		if err := rc.verify(); err != nil {
+			return err
+		}
+	}
+	for _, kr := range c.KeyRotations {
+		if err := c.verifyKeyRotation(kr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
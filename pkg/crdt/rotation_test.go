@@ -0,0 +1,242 @@
+package crdt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeKeyRotation(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	newPrvKey := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+	newIdentity, err := crypto.CreateIdendityFromString(newPrvKey)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"a": {"b": "1"}, "c": "2"}`), prvKey)
+	assert.Nil(t, err)
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	err = c1.RotateKey(prvKey, newPrvKey)
+	assert.Nil(t, err, "RotateKey should not return an error")
+
+	err = c1.RotateKey(prvKey, newPrvKey)
+	assert.NotNil(t, err, "A key can only be rotated once")
+
+	tree := c1.(*AdapterSecureTreeCRDT).treeCrdt
+	assert.Equal(t, []string{identity.ID(), newIdentity.ID()}, tree.keyChain(newIdentity.ID()))
+	assert.Equal(t, []string{identity.ID(), newIdentity.ID()}, tree.keyChain(identity.ID()))
+
+	err = c1.VerifyTree()
+	assert.Nil(t, err, "Nodes signed before the rotation should still be valid")
+
+	assert.True(t, c1.ABAC().IsAllowed(newIdentity.ID(), ActionModify, tree.Root.ID), "The new key should inherit the rules of the old key")
+	assert.False(t, c1.ABAC().IsAllowed(identity.ID(), ActionModify, tree.Root.ID), "The old key should not be allowed to make changes")
+
+	t.Run("New key continues the clock of the old key", func(t *testing.T) {
+		clock := VectorClock{ClientID(identity.ID()): 5}
+		assert.Equal(t, 5, tree.clientVersion(clock, ClientID(newIdentity.ID())))
+	})
+
+	t.Run("Changes with the new key", func(t *testing.T) {
+		node, err := c1.GetNodeByPath("/a/b")
+		assert.Nil(t, err)
+		err = node.SetLiteral("3", newPrvKey)
+		assert.Nil(t, err, "SetLiteral with the new key should not return an error")
+
+		err = c1.ABAC().Allow("*", ActionRead, tree.Root.ID, true)
+		assert.Nil(t, err, "The policy should be signed with the new key")
+
+		err = c1.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should accept changes made with the new key")
+	})
+
+	t.Run("Merge rotation into another replica", func(t *testing.T) {
+		err := c2.Merge(c1, newPrvKey)
+		assert.Nil(t, err, "Merge should not return an error")
+
+		value, err := c2.GetValueByPath("/a/b")
+		assert.Nil(t, err)
+		assert.Equal(t, "3", value)
+
+		err = c2.VerifyTree()
+		assert.Nil(t, err)
+	})
+
+	t.Run("Save and Load keep rotations", func(t *testing.T) {
+		data, err := c1.Save()
+		assert.Nil(t, err)
+
+		c3, err := NewSecureTree(newPrvKey)
+		assert.Nil(t, err)
+		err = c3.Load(data)
+		assert.Nil(t, err)
+		err = c3.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should succeed after Load")
+
+		tampered := strings.Replace(string(data), identity.ID(), strings.Repeat("0", 64), 1)
+		c4, err := NewSecureTree(newPrvKey)
+		assert.Nil(t, err)
+		err = c4.Load([]byte(tampered))
+		assert.NotNil(t, err, "Load should reject a tampered key rotation")
+	})
+
+	t.Run("Reject changes signed by the old key after rotation", func(t *testing.T) {
+		node, err := tree.GetNodeByPath("/c")
+		assert.Nil(t, err)
+		err = node.SetLiteral("4", ClientID(identity.ID()))
+		assert.Nil(t, err)
+		err = node.Sign(identity)
+		assert.Nil(t, err)
+
+		err = c1.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should reject nodes signed by a rotated key")
+		assert.Contains(t, err.Error(), "after the key was rotated")
+	})
+}
+
+func TestSecureTreeKeyRecovery(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"a": "1"}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	user, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	recovery, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	attacker, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	newUser, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+	err = c.ABAC().Allow(user.DID(), ActionModify, tree.Root.ID, true)
+	assert.Nil(t, err)
+
+	err = c.CommitRecoveryKey(user.PrivateKeyAsHex(), recovery.DID())
+	assert.Nil(t, err, "CommitRecoveryKey should not return an error")
+
+	err = c.CommitRecoveryKey(user.PrivateKeyAsHex(), attacker.ID())
+	assert.NotNil(t, err, "A committed recovery key cannot be replaced")
+
+	// The attacker has stolen the key of the user and rotates it to a key of their own
+	err = c.RotateKey(user.PrivateKeyAsHex(), attacker.PrivateKeyAsHex())
+	assert.Nil(t, err)
+	assert.True(t, c.ABAC().IsAllowed(attacker.ID(), ActionModify, tree.Root.ID))
+
+	err = c.RecoverKey(attacker.PrivateKeyAsHex(), user.ID(), attacker.PrivateKeyAsHex())
+	assert.NotNil(t, err, "Only the committed recovery key can recover a key")
+
+	err = c.RecoverKey(recovery.PrivateKeyAsHex(), user.DID(), newUser.PrivateKeyAsHex())
+	assert.Nil(t, err, "RecoverKey should not return an error")
+
+	assert.True(t, c.ABAC().IsAllowed(newUser.ID(), ActionModify, tree.Root.ID), "The recovered key should inherit the rules of the user")
+	assert.False(t, c.ABAC().IsAllowed(attacker.ID(), ActionModify, tree.Root.ID), "The recovery should win over the rotation of the attacker")
+	assert.False(t, c.ABAC().IsAllowed(user.ID(), ActionModify, tree.Root.ID))
+
+	err = c.VerifyTree()
+	assert.Nil(t, err)
+}
+
+func TestSecureTreeKeyRecoveryRace(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"a": "1"}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	user, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	recovery, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	attacker, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	newUser, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	newAttacker, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	tree1 := c1.(*AdapterSecureTreeCRDT).treeCrdt
+	err = c1.ABAC().Allow(user.ID(), ActionModify, tree1.Root.ID, true)
+	assert.Nil(t, err)
+
+	// The attacker steals the key on a replica that has not seen the recovery commitment yet
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	tree2 := c2.(*AdapterSecureTreeCRDT).treeCrdt
+	err = c1.CommitRecoveryKey(user.PrivateKeyAsHex(), recovery.ID())
+	assert.Nil(t, err)
+	err = c2.CommitRecoveryKey(user.PrivateKeyAsHex(), attacker.ID())
+	assert.Nil(t, err)
+	err = c2.RecoverKey(attacker.PrivateKeyAsHex(), user.ID(), newAttacker.PrivateKeyAsHex())
+	assert.Nil(t, err)
+
+	err = c1.Merge(c2, ownerPrvKey)
+	assert.Nil(t, err, "A conflicting recovery commitment should not fail the merge")
+	err = c2.Merge(c1, ownerPrvKey)
+	assert.Nil(t, err)
+
+	// Both replicas pick the commitment with the lowest signature and mark the key as disputed
+	winner, loser := recovery, attacker
+	if tree1.RecoveryCommitments[1].Signature < tree1.RecoveryCommitments[0].Signature {
+		winner, loser = attacker, recovery
+	}
+	for _, c := range []SecureTree{c1, c2} {
+		tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+		assert.Equal(t, winner.ID(), tree.recoveryID(user.ID()))
+		assert.Equal(t, []string{user.ID()}, c.DisputedKeys())
+		assert.Len(t, tree.RecoveryCommitments, 2)
+		assert.Nil(t, c.VerifyTree())
+	}
+	assert.Equal(t, tree1.keyChain(user.ID()), tree2.keyChain(user.ID()))
+
+	// The recovery made with the losing key stays valid but does not take effect
+	assert.Equal(t, winner == attacker, c1.ABAC().IsAllowed(newAttacker.ID(), ActionModify, tree1.Root.ID))
+
+	err = c1.RecoverKey(loser.PrivateKeyAsHex(), user.ID(), newUser.PrivateKeyAsHex())
+	assert.NotNil(t, err, "Only the winning recovery key can recover the key")
+	if winner == recovery {
+		err = c1.RecoverKey(recovery.PrivateKeyAsHex(), user.ID(), newUser.PrivateKeyAsHex())
+		assert.Nil(t, err, "The winning recovery key should recover the key")
+		assert.True(t, c1.ABAC().IsAllowed(newUser.ID(), ActionModify, tree1.Root.ID))
+	}
+
+	// The conflict is resolved the same way when the tree is loaded
+	loaded, err := c1.Clone()
+	assert.Nil(t, err)
+	assert.Equal(t, winner.ID(), loaded.(*AdapterSecureTreeCRDT).treeCrdt.recoveryID(user.ID()))
+	assert.Equal(t, []string{user.ID()}, loaded.DisputedKeys())
+	assert.Nil(t, loaded.VerifyTree())
+}
+
+func TestTreeCRDTMergeIsAtomic(t *testing.T) {
+	user, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	recovery, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	c1 := newTreeCRDT()
+	c2 := newTreeCRDT()
+	err = c2.CommitRecoveryKey(user, recovery.ID())
+	assert.Nil(t, err)
+
+	// A tampered key rotation fails the merge after the recovery commitment has been read
+	c2.KeyRotations = append(c2.KeyRotations, &KeyRotation{OldID: user.ID(), NewID: recovery.ID(), OldSignature: "00", NewSignature: "00"})
+
+	err = c1.Merge(c2)
+	assert.NotNil(t, err)
+	assert.Empty(t, c1.RecoveryCommitments, "A failed merge should not change the tree")
+	assert.Equal(t, "", c1.recoveryID(user.ID()))
+}
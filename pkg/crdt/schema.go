@@ -0,0 +1,488 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// A SchemaAttachment binds a JSON Schema (draft 2020-12) to the value at a JSON pointer path of the exported
+// document. Attachments are signed and replicated with the tree, if the tree has an ABAC policy they must be
+// signed by its owner. The attachment with the highest version wins for a path, an empty schema detaches it.
+// Schemas are self-contained, references to remote schemas are never loaded.
+
+type SchemaAttachment struct {
+	Path      string `json:"path"`
+	Schema    string `json:"schema,omitempty"`
+	Version   int    `json:"version"`
+	SignerID  string `json:"signerid"`
+	Signature string `json:"signature"`
+}
+
+// SchemaViolation is a value of the document that does not match an attached schema
+type SchemaViolation struct {
+	Pointer         string `json:"pointer"`         // Location of the value in the document
+	SchemaPath      string `json:"schemaPath"`      // Path of the attachment
+	KeywordLocation string `json:"keywordLocation"` // Location of the failing keyword in the schema
+	Message         string `json:"message"`
+}
+
+// SchemaError is returned when a change makes the document invalid
+type SchemaError struct {
+	Violations []*SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		pointer := v.Pointer
+		if pointer == "" {
+			pointer = "/"
+		}
+		messages[i] = pointer + ": " + v.Message
+	}
+	return "document does not match schema: " + strings.Join(messages, "; ")
+}
+
+// SchemaMergeMode selects what SecureMerge does with remote changes that make the document invalid
+type SchemaMergeMode int
+
+const (
+	SchemaMergeReject     SchemaMergeMode = iota // The merge fails with a *SchemaError, the default
+	SchemaMergeQuarantine                        // The remote tree is kept aside unmerged, see Quarantined
+	SchemaMergeAllow                             // The remote changes are merged and the violations logged
+)
+
+// QuarantinedMerge is a remote tree held back by SecureMerge because it made the document invalid
+type QuarantinedMerge struct {
+	Violations []*SchemaViolation
+	Received   time.Time
+	tree       *TreeCRDT
+}
+
+func (sa *SchemaAttachment) digest() (*crypto.Hash, error) {
+	buf, err := json.Marshal(struct {
+		Path     string `json:"path"`
+		Schema   string `json:"schema"`
+		Version  int    `json:"version"`
+		SignerID string `json:"signerid"`
+	}{sa.Path, sa.Schema, sa.Version, sa.SignerID})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal schema attachment: %w", err)
+	}
+	return crypto.GenerateHashFromString("schema:" + string(buf)), nil
+}
+
+func (c *TreeCRDT) verifySchemaAttachment(sa *SchemaAttachment) error {
+	if _, err := splitJSONPointer(sa.Path); err != nil {
+		return fmt.Errorf("Invalid schema attachment path %s: %w", sa.Path, err)
+	}
+
+	digest, err := sa.digest()
+	if err != nil {
+		return err
+	}
+	if err := verifySignedBy(digest, sa.Signature, sa.SignerID); err != nil {
+		return fmt.Errorf("Invalid schema attachment at %s: %w", sa.Path, err)
+	}
+
+	if c.ABACPolicy != nil && c.ABACPolicy.OwnerID != "" && !c.keySet(c.ABACPolicy.OwnerID)[ClientID(sa.SignerID)] {
+		return fmt.Errorf("Invalid schema attachment at %s, %s is not the owner of the tree", sa.Path, sa.SignerID)
+	}
+
+	if sa.Schema != "" {
+		if _, err := c.compileSchema(sa); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addSchemaAttachment keeps the attachment if it is newer than the one at the same path
+func (c *TreeCRDT) addSchemaAttachment(sa *SchemaAttachment) error {
+	if err := c.verifySchemaAttachment(sa); err != nil {
+		return err
+	}
+	for i, existing := range c.Schemas {
+		if existing.Path != sa.Path {
+			continue
+		}
+		if sa.Version > existing.Version || (sa.Version == existing.Version && sa.Signature < existing.Signature) {
+			c.Schemas[i] = sa
+		}
+		return nil
+	}
+	c.Schemas = append(c.Schemas, sa)
+	sort.Slice(c.Schemas, func(i, j int) bool { return c.Schemas[i].Path < c.Schemas[j].Path })
+	return nil
+}
+
+// compileSchema compiles the schema of an attachment, compiled schemas are cached by signature
+func (c *TreeCRDT) compileSchema(sa *SchemaAttachment) (*jsonschema.Schema, error) {
+	if schema, ok := c.schemaCache[sa.Signature]; ok {
+		return schema, nil
+	}
+
+	url := "synctree:///schemas" + sa.Path
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("loading schema %s is not allowed", s)
+	}
+	if err := compiler.AddResource(url, strings.NewReader(sa.Schema)); err != nil {
+		return nil, fmt.Errorf("Failed to parse schema at %s: %w", sa.Path, err)
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compile schema at %s: %w", sa.Path, err)
+	}
+
+	if c.schemaCache == nil {
+		c.schemaCache = make(map[string]*jsonschema.Schema)
+	}
+	c.schemaCache[sa.Signature] = schema
+	return schema, nil
+}
+
+// AttachSchema attaches a JSON Schema to the value at the JSON pointer path, replacing an existing attachment
+func (c *TreeCRDT) AttachSchema(path string, schema []byte, identity *crypto.Idendity) (*SchemaAttachment, error) {
+	if len(bytes.TrimSpace(schema)) == 0 {
+		return nil, fmt.Errorf("Schema must not be empty, use DetachSchema to remove it")
+	}
+	return c.signSchemaAttachment(path, string(schema), identity)
+}
+
+// DetachSchema removes the schema attached to the path
+func (c *TreeCRDT) DetachSchema(path string, identity *crypto.Idendity) (*SchemaAttachment, error) {
+	if existing := c.schemaAttachment(normalizeSchemaPath(path)); existing == nil || existing.Schema == "" {
+		return nil, fmt.Errorf("No schema attached to %s", path)
+	}
+	return c.signSchemaAttachment(path, "", identity)
+}
+
+func (c *TreeCRDT) signSchemaAttachment(path string, schema string, identity *crypto.Idendity) (*SchemaAttachment, error) {
+	path = normalizeSchemaPath(path)
+	sa := &SchemaAttachment{Path: path, Schema: schema, Version: 1, SignerID: identity.ID()}
+	if existing := c.schemaAttachment(path); existing != nil {
+		sa.Version = existing.Version + 1
+	}
+
+	digest, err := sa.digest()
+	if err != nil {
+		return nil, err
+	}
+	sa.Signature, err = signDigest(digest, identity)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sign schema attachment: %w", err)
+	}
+
+	if err := c.addSchemaAttachment(sa); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"Path": path, "Version": sa.Version, "Detached": schema == ""}).Debug("Schema attached")
+
+	return sa, nil
+}
+
+func normalizeSchemaPath(path string) string {
+	if path == "/" {
+		return ""
+	}
+	return path
+}
+
+func (c *TreeCRDT) schemaAttachment(path string) *SchemaAttachment {
+	for _, sa := range c.Schemas {
+		if sa.Path == path {
+			return sa
+		}
+	}
+	return nil
+}
+
+// AttachedSchemas returns the schemas attached to the tree by path
+func (c *TreeCRDT) AttachedSchemas() map[string][]byte {
+	schemas := make(map[string][]byte)
+	for _, sa := range c.Schemas {
+		if sa.Schema != "" {
+			schemas[sa.Path] = []byte(sa.Schema)
+		}
+	}
+	return schemas
+}
+
+func (c *TreeCRDT) hasSchemas() bool {
+	for _, sa := range c.Schemas {
+		if sa.Schema != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSchemas validates the exported document against the attached schemas and returns a *SchemaError
+// listing every violation. Attachments whose path does not exist are skipped.
+func (c *TreeCRDT) ValidateSchemas() error {
+	violations, err := c.schemaViolations()
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+func (c *TreeCRDT) schemaViolations() ([]*SchemaViolation, error) {
+	if !c.hasSchemas() {
+		return make([]*SchemaViolation, 0), nil
+	}
+	return c.schemaViolationsWith(c.Schemas)
+}
+
+// schemaViolationsWith validates the exported document against the given attachments
+func (c *TreeCRDT) schemaViolationsWith(schemas []*SchemaAttachment) ([]*SchemaViolation, error) {
+	violations := make([]*SchemaViolation, 0)
+
+	var doc interface{}
+	if len(c.Root.Edges) > 0 {
+		exported, err := c.ExportJSON()
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(exported))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("Failed to decode exported document: %w", err)
+		}
+	}
+
+	for _, sa := range schemas {
+		if sa.Schema == "" {
+			continue
+		}
+		value, ok := valueAtPointer(doc, sa.Path)
+		if !ok {
+			continue
+		}
+		schema, err := c.compileSchema(sa)
+		if err != nil {
+			return nil, err
+		}
+		err = schema.Validate(value)
+		if err == nil {
+			continue
+		}
+		ve, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return nil, fmt.Errorf("Failed to validate %s: %w", sa.Path, err)
+		}
+		violations = append(violations, schemaLeaves(sa.Path, ve)...)
+	}
+
+	return violations, nil
+}
+
+// schemaLeaves returns the innermost errors of a validation error, they name the values that failed
+func schemaLeaves(path string, ve *jsonschema.ValidationError) []*SchemaViolation {
+	if len(ve.Causes) == 0 {
+		return []*SchemaViolation{{
+			Pointer:         path + ve.InstanceLocation,
+			SchemaPath:      path,
+			KeywordLocation: ve.KeywordLocation,
+			Message:         ve.Message,
+		}}
+	}
+	var violations []*SchemaViolation
+	for _, cause := range ve.Causes {
+		violations = append(violations, schemaLeaves(path, cause)...)
+	}
+	return violations
+}
+
+func valueAtPointer(doc interface{}, pointer string) (interface{}, bool) {
+	tokens, err := splitJSONPointer(pointer)
+	if err != nil || doc == nil {
+		return nil, false
+	}
+	for _, token := range tokens {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			child, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// newSchemaViolations returns the violations of the changed tree the tree does not have, so documents that
+// are already invalid can still be fixed step by step. Both trees are validated against the schemas of the
+// changed tree, and violations are matched by the node they point to, so values that only moved to another
+// array index are not reported again.
+func (c *TreeCRDT) newSchemaViolations(changed *TreeCRDT) ([]*SchemaViolation, error) {
+	if !changed.hasSchemas() {
+		return nil, nil
+	}
+	before, err := c.schemaViolationsWith(changed.Schemas)
+	if err != nil {
+		return nil, err
+	}
+	after, err := changed.schemaViolations()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool)
+	for _, v := range before {
+		existing[c.schemaViolationKey(v)] = true
+	}
+	var added []*SchemaViolation
+	for _, v := range after {
+		if !existing[changed.schemaViolationKey(v)] {
+			added = append(added, v)
+		}
+	}
+	return added, nil
+}
+
+// schemaViolationKey identifies a violation by the node it points to, the schema and the failing keyword
+func (c *TreeCRDT) schemaViolationKey(v *SchemaViolation) string {
+	target := v.Pointer
+	if chain, err := c.pointerChain(v.Pointer); err == nil && len(chain) > 0 {
+		target = string(chain[len(chain)-1].ID)
+	}
+	return target + "#" + v.SchemaPath + "#" + v.KeywordLocation
+}
+
+// checkSchemaChange returns a *SchemaError if the changed tree, a clone of the tree, has new violations
+func (c *TreeCRDT) checkSchemaChange(changed *TreeCRDT) error {
+	violations, err := c.newSchemaViolations(changed)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+// checkSchemas runs a write on a clone of the tree and returns a *SchemaError if it adds violations. Without
+// attached schemas nothing is cloned.
+func (c *TreeCRDT) checkSchemas(write func(trial *TreeCRDT) error) error {
+	if !c.hasSchemas() {
+		return nil
+	}
+	trial, err := c.Clone()
+	if err != nil {
+		return fmt.Errorf("Failed to clone tree: %w", err)
+	}
+	if err := write(trial); err != nil {
+		return err
+	}
+	return c.checkSchemaChange(trial)
+}
+
+// SetSchemaMergeMode selects what SecureMerge does with remote changes that make the document invalid
+func (c *TreeCRDT) SetSchemaMergeMode(mode SchemaMergeMode) {
+	c.schemaMergeMode = mode
+}
+
+// Quarantined returns the remote trees held back by SecureMerge, oldest first. They are not saved.
+func (c *TreeCRDT) Quarantined() []*QuarantinedMerge {
+	return c.quarantine
+}
+
+// ReleaseQuarantined merges a quarantined tree regardless of the schemas, e.g. after the schema was fixed
+func (c *TreeCRDT) ReleaseQuarantined(index int, prvKey string) error {
+	if index < 0 || index >= len(c.quarantine) {
+		return fmt.Errorf("No quarantined merge at index %d", index)
+	}
+	q := c.quarantine[index]
+
+	mode := c.schemaMergeMode
+	c.schemaMergeMode = SchemaMergeAllow
+	err := c.SecureMerge(q.tree, prvKey)
+	c.schemaMergeMode = mode
+	if err != nil {
+		return err
+	}
+
+	c.quarantine = append(c.quarantine[:index:index], c.quarantine[index+1:]...)
+	return nil
+}
+
+// DropQuarantined discards a quarantined tree
+func (c *TreeCRDT) DropQuarantined(index int) error {
+	if index < 0 || index >= len(c.quarantine) {
+		return fmt.Errorf("No quarantined merge at index %d", index)
+	}
+	c.quarantine = append(c.quarantine[:index:index], c.quarantine[index+1:]...)
+	return nil
+}
+
+// checkMergeSchemas applies the schema merge mode to a merged clone, it returns true if the merge should go on
+func (c *TreeCRDT) checkMergeSchemas(merged *TreeCRDT, c2 *TreeCRDT) (bool, error) {
+	violations, err := c.newSchemaViolations(merged)
+	if err != nil {
+		return false, err
+	}
+	if len(violations) == 0 {
+		return true, nil
+	}
+
+	switch c.schemaMergeMode {
+	case SchemaMergeAllow:
+		log.WithFields(log.Fields{"Violations": len(violations)}).Warning("Merged remote changes that do not match the schema")
+		return true, nil
+	case SchemaMergeQuarantine:
+		held, err := c2.Clone()
+		if err != nil {
+			return false, fmt.Errorf("Failed to clone remote tree for quarantine: %w", err)
+		}
+		c.quarantine = append(c.quarantine, &QuarantinedMerge{Violations: violations, Received: time.Now(), tree: held})
+		log.WithFields(log.Fields{"Violations": len(violations)}).Warning("Quarantined remote changes that do not match the schema")
+		return false, nil
+	default:
+		return false, &SchemaError{Violations: violations}
+	}
+}
+
+func (c *TreeCRDT) mergeSchemas(c2 *TreeCRDT) error {
+	for _, sa := range c2.Schemas {
+		if err := c.addSchemaAttachment(sa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *TreeCRDT) verifySchemas() error {
+	for _, sa := range c.Schemas {
+		if err := c.verifySchemaAttachment(sa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package crdt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const controllerSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"setpoint": {"type": "number", "minimum": 0},
+		"mode": {"enum": ["auto", "manual"]}
+	},
+	"required": ["setpoint"]
+}`
+
+func TestSecureTreeSchemaLocalWrites(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"controllers": [{"setpoint": 21, "mode": "auto"}], "name": "hall"}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	err = c.AttachSchema("/controllers/0", []byte(`{"type": `), ownerPrvKey)
+	assert.NotNil(t, err, "Invalid schemas should be rejected")
+	err = c.AttachSchema("/controllers/0", []byte(`{"$ref": "https://example.com/schema.json"}`), ownerPrvKey)
+	assert.NotNil(t, err, "Remote references should not be loaded")
+
+	err = c.AttachSchema("/controllers/0", []byte(controllerSchema), ownerPrvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c.ValidateSchemas())
+	assert.Contains(t, c.AttachedSchemas(), "/controllers/0")
+
+	_, err = c.SetByPath("/controllers/0/setpoint", "warm", ownerPrvKey)
+	var schemaErr *SchemaError
+	assert.True(t, errors.As(err, &schemaErr), "Writing a string where a number is expected should fail")
+	assert.Equal(t, 1, len(schemaErr.Violations))
+	assert.Equal(t, "/controllers/0/setpoint", schemaErr.Violations[0].Pointer)
+	assert.Equal(t, "/properties/setpoint/type", schemaErr.Violations[0].KeywordLocation)
+
+	value, err := c.GetValueByPath("/controllers/0/setpoint")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(21), value, "Rejected writes should not change the tree")
+
+	err = c.ApplyJSONPatch([]byte(`[{"op": "remove", "path": "/controllers/0/setpoint"}]`), ownerPrvKey)
+	assert.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, "/controllers/0", schemaErr.Violations[0].Pointer)
+
+	node, err := c.GetNodeByPath("/controllers/0")
+	assert.Nil(t, err)
+	_, err = node.SetKeyValue("mode", "off", ownerPrvKey)
+	assert.True(t, errors.As(err, &schemaErr), "Node writes should be validated")
+	_, err = node.SetKeyValue("mode", "manual", ownerPrvKey)
+	assert.Nil(t, err)
+
+	setpoint, err := c.GetNodeByPath("/controllers/0/setpoint")
+	assert.Nil(t, err)
+	assert.NotNil(t, setpoint.SetLiteral(-1, ownerPrvKey))
+	assert.Nil(t, setpoint.SetLiteral(19.5, ownerPrvKey))
+
+	_, err = c.SetByPath("/name", 42, ownerPrvKey)
+	assert.Nil(t, err, "Values outside the attached path are not validated")
+
+	err = c.DetachSchema("/controllers/0", ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.SetByPath("/controllers/0/setpoint", "warm", ownerPrvKey)
+	assert.Nil(t, err, "Detached schemas should not be enforced")
+	assert.Empty(t, c.AttachedSchemas())
+}
+
+func TestSecureTreeSchemaStructuralWrites(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	owner, err := crypto.CreateIdendityFromString(ownerPrvKey)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"controllers": [{"setpoint": 21}]}`), ownerPrvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c.AttachSchema("/controllers", []byte(`{"type": "array", "maxItems": 1}`), ownerPrvKey))
+	assert.Nil(t, c.AttachSchema("/controllers/0", []byte(controllerSchema), ownerPrvKey))
+	before := exportedValue(t, c)
+
+	controllers, err := c.GetNodeByPath("/controllers")
+	assert.Nil(t, err)
+	controller, err := c.GetNodeByPath("/controllers/0")
+	assert.Nil(t, err)
+	setpoint, err := c.GetNodeByPath("/controllers/0/setpoint")
+	assert.Nil(t, err)
+	item, err := c.CreateNode("map", Map, ownerPrvKey)
+	assert.Nil(t, err)
+	mode, err := c.CreateNode("lit", Literal, ownerPrvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, mode.SetLiteral("off", ownerPrvKey))
+
+	var schemaErr *SchemaError
+	writes := map[string]func() error{
+		"AddEdge":         func() error { return c.AddEdge(controller.ID(), mode.ID(), "mode", ownerPrvKey) },
+		"RemoveEdge":      func() error { return c.RemoveEdge(controller.ID(), setpoint.ID(), ownerPrvKey) },
+		"AppendEdge":      func() error { return c.AppendEdge(controllers.ID(), item.ID(), "", ownerPrvKey) },
+		"PrependEdge":     func() error { return c.PrependEdge(controllers.ID(), item.ID(), "", ownerPrvKey) },
+		"InsertEdgeLeft":  func() error { return c.InsertEdgeLeft(controllers.ID(), item.ID(), "", controller.ID(), ownerPrvKey) },
+		"InsertEdgeRight": func() error { return c.InsertEdgeRight(controllers.ID(), item.ID(), "", controller.ID(), ownerPrvKey) },
+		"CreateAttachedNode": func() error {
+			_, err := c.CreateAttachedNode("map", Map, controllers.ID(), ownerPrvKey)
+			return err
+		},
+		"CreateMapNode": func() error {
+			_, err := controllers.CreateMapNode(ownerPrvKey)
+			return err
+		},
+	}
+	for name, write := range writes {
+		assert.True(t, errors.As(write(), &schemaErr), "%s should be validated", name)
+	}
+	assert.Equal(t, before, exportedValue(t, c), "Rejected writes should not change the tree")
+
+	// Proposals and approvals that reach the threshold promote the value
+	approver, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	assert.Nil(t, c.ABAC().RequireApproval(setpoint.ID(), []string{owner.ID(), approver.ID()}, 2, false))
+	assert.Nil(t, setpoint.ProposeLiteral("warm", ownerPrvKey), "Pending proposals do not change the value")
+	assert.True(t, errors.As(setpoint.ApproveLiteral(approver.PrivateKeyAsHex()), &schemaErr), "Approvals should be validated")
+	assert.Nil(t, c.ABAC().RequireApproval(setpoint.ID(), []string{owner.ID()}, 1, false))
+	assert.True(t, errors.As(setpoint.ProposeLiteral("cold", ownerPrvKey), &schemaErr), "Proposals that are approved at once should be validated")
+	value, err := c.GetValueByPath("/controllers/0/setpoint")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(21), value)
+}
+
+func TestSecureTreeSchemaExistingViolations(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"setpoint": "warm", "mode": "off"}`), ownerPrvKey)
+	assert.Nil(t, err)
+	err = c.AttachSchema("", []byte(controllerSchema), ownerPrvKey)
+	assert.Nil(t, err)
+
+	err = c.ValidateSchemas()
+	var schemaErr *SchemaError
+	assert.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, 2, len(schemaErr.Violations))
+
+	_, err = c.SetByPath("/setpoint", 20, ownerPrvKey)
+	assert.Nil(t, err, "Writes that do not add violations should be allowed in an invalid document")
+	_, err = c.SetByPath("/mode", "auto", ownerPrvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c.ValidateSchemas())
+}
+
+func TestSecureTreeSchemaReplication(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	stranger, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"setpoint": 21}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	err = c1.AttachSchema("", []byte(controllerSchema), stranger.PrivateKeyAsHex())
+	assert.NotNil(t, err, "Only the owner should be able to attach schemas")
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	err = c1.AttachSchema("", []byte(controllerSchema), ownerPrvKey)
+	assert.Nil(t, err)
+
+	for _, format := range []Format{FormatJSON, FormatBinary} {
+		saved, err := c1.SaveAs(format)
+		assert.Nil(t, err)
+		loaded, err := NewSecureTree(ownerPrvKey)
+		assert.Nil(t, err)
+		assert.Nil(t, loaded.Load(saved))
+		assert.Equal(t, c1.AttachedSchemas(), loaded.AttachedSchemas())
+		_, err = loaded.SetByPath("/setpoint", "warm", ownerPrvKey)
+		assert.NotNil(t, err, "Loaded schemas should be enforced")
+	}
+
+	assert.Nil(t, c2.Merge(c1, ownerPrvKey))
+	assert.Equal(t, c1.AttachedSchemas(), c2.AttachedSchemas(), "Schemas should be merged")
+
+	tree := c1.(*AdapterSecureTreeCRDT).treeCrdt
+	tree.Schemas[0].Schema = `{"type": "string"}`
+	assert.NotNil(t, tree.VerifyTree(), "Tampered schemas should fail verification")
+}
+
+func TestSecureTreeSchemaMerge(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"setpoint": 21}`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	// A peer that has not seen the schema writes a string
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	_, err = c2.SetByPath("/setpoint", "warm", ownerPrvKey)
+	assert.Nil(t, err)
+
+	err = c1.AttachSchema("", []byte(controllerSchema), ownerPrvKey)
+	assert.Nil(t, err)
+
+	err = c1.Merge(c2, ownerPrvKey)
+	var schemaErr *SchemaError
+	assert.True(t, errors.As(err, &schemaErr), "Merges that make the document invalid should be rejected")
+	assert.Equal(t, "/setpoint", schemaErr.Violations[0].Pointer)
+	value, err := c1.GetValueByPath("/setpoint")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(21), value)
+
+	c1.SetSchemaMergeMode(SchemaMergeQuarantine)
+	assert.Nil(t, c1.Merge(c2, ownerPrvKey))
+	assert.Equal(t, 1, len(c1.Quarantined()))
+	assert.Equal(t, "/setpoint", c1.Quarantined()[0].Violations[0].Pointer)
+	value, err = c1.GetValueByPath("/setpoint")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(21), value, "Quarantined changes should not be merged")
+
+	// Merges that keep the document valid are not affected
+	c3, err := c1.Clone()
+	assert.Nil(t, err)
+	_, err = c3.SetByPath("/setpoint", 18, ownerPrvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Merge(c3, ownerPrvKey))
+	assert.Equal(t, 1, len(c1.Quarantined()))
+
+	assert.Nil(t, c1.ReleaseQuarantined(0, ownerPrvKey))
+	assert.Empty(t, c1.Quarantined())
+	assert.NotNil(t, c1.ValidateSchemas(), "Released changes are merged regardless of the schema")
+
+	assert.NotNil(t, c1.DropQuarantined(0))
+}
+
+func TestSecureTreeSchemaMergeExistingViolations(t *testing.T) {
+	ownerPrvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	// The remote attaches a schema the local document already violates
+	c1, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"setpoint": "warm"}`), ownerPrvKey)
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	err = c2.AttachSchema("", []byte(controllerSchema), ownerPrvKey)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c1.Merge(c2, ownerPrvKey), "Violations that exist under the merged schemas are not new")
+	assert.Equal(t, c2.AttachedSchemas(), c1.AttachedSchemas())
+
+	// An invalid item moves to another index
+	c3, err := NewSecureTree(ownerPrvKey)
+	assert.Nil(t, err)
+	_, err = c3.ImportJSON([]byte(`{"items": [1, "bad"]}`), ownerPrvKey)
+	assert.Nil(t, err)
+	err = c3.AttachSchema("/items", []byte(`{"type": "array", "items": {"type": "number"}}`), ownerPrvKey)
+	assert.Nil(t, err)
+	c4, err := c3.Clone()
+	assert.Nil(t, err)
+	err = c4.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/items/0", "value": 0}]`), ownerPrvKey)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c3.Merge(c4, ownerPrvKey), "A violation that only moved should not be reported again")
+	exported, err := c3.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"items": [0, 1, "bad"]}`, string(exported))
+}
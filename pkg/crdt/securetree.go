@@ -7,6 +7,11 @@ type SecureNode interface {
 	// Literal operations
 	SetLiteral(value interface{}, prvKey string) error
 	GetLiteral() (interface{}, error)
+	SetEncryptedLiteral(value interface{}, recipients []string, prvKey string) error
+	AddReaders(readers []string, prvKey string) error
+	IsEncrypted() bool
+	ProposeLiteral(value interface{}, prvKey string) error
+	ApproveLiteral(prvKey string) error
 
 	// Map operations
 	CreateMapNode(prvKey string) (SecureNode, error)
@@ -30,6 +35,18 @@ type SecureTree interface {
 	GetValueByPath(path string) (interface{}, error)
 	GetNodeByPath(path string) (SecureNode, error)
 	GetStringValueByPath(path string) (string, error)
+	Query(expr string, prvKey string) ([]*QueryResult, error)
+	CreateIndex(name, path, field string) error
+	DropIndex(name string)
+	FindByIndex(name string, value interface{}) ([]SecureNode, error)
+	NodesByOwner(id string) ([]*NodeChange, error)
+	PathsTouchedBy(id string) ([]*NodeChange, error)
+	ChangedSince(clock VectorClock) ([]*NodeChange, error)
+	Clock() VectorClock
+	SetByPath(path string, value interface{}, prvKey string) (NodeID, error)
+	InsertByPath(path string, value interface{}, prvKey string) (NodeID, error)
+	DeleteByPath(path string, prvKey string) error
+	EnsurePath(path string, prvKey string) (NodeID, error)
 
 	// Edge operations
 	AddEdge(from, to NodeID, label string, prvKey string) error
@@ -45,14 +62,54 @@ type SecureTree interface {
 	Merge(c2 SecureTree, prvKey string) error
 
 	// Serialization
+	SetIDScheme(scheme IDScheme)
 	ImportJSON(rawJSON []byte, prvKey string) (NodeID, error)
 	ImportJSONToMap(rawJSON []byte, parentID NodeID, key string, prvKey string) (NodeID, error)
 	ImportJSONToArray(rawJSON []byte, parentID NodeID, prvKey string) (NodeID, error)
 	ExportJSON() ([]byte, error)
+	ExportAnnotatedJSON() ([]byte, error)
+	ExportPath(path string) ([]byte, error)
+	ExtractSubtree(path string, prvKey string) (SecureTree, error)
+	ImportYAML(rawYAML []byte, prvKey string) (NodeID, error)
+	ImportTOML(rawTOML []byte, prvKey string) (NodeID, error)
+	ExportYAML() ([]byte, error)
+	ExportTOML() ([]byte, error)
+	ApplyJSONPatch(patch []byte, prvKey string) error
+	ReconcileJSON(path string, doc []byte, prvKey string) ([]*JSONPatchOperation, error)
+	DiffAsJSONPatch(other SecureTree) ([]byte, error)
 	Load(data []byte) error
 	Save() ([]byte, error)
+	SaveAs(format Format) ([]byte, error)
+	Migrate(data []byte, prvKey string) (*MigrationReport, error)
 	Clone() (SecureTree, error)
 
+	// Merkle hashing
+	RootHash() (string, error)
+	SignCheckpoint(prvKey string) (*Checkpoint, error)
+	VerifyCheckpoint(checkpoint *Checkpoint) error
+	Prove(path string) (*Proof, error)
+
+	// Key rotation
+	CommitRecoveryKey(prvKey string, recoveryID string) error
+	RotateKey(oldPrvKey string, newPrvKey string) error
+	RecoverKey(recoveryPrvKey string, oldID string, newPrvKey string) error
+	DisputedKeys() []string
+
+	// Device keys
+	LinkDevice(masterPrvKey string, devicePrvKey string, index uint32) error
+	UnlinkDevice(masterPrvKey string, deviceID string) error
+	Devices(masterID string) []string
+
+	// JSON Schema validation
+	AttachSchema(path string, schema []byte, prvKey string) error
+	DetachSchema(path string, prvKey string) error
+	AttachedSchemas() map[string][]byte
+	ValidateSchemas() error
+	SetSchemaMergeMode(mode SchemaMergeMode)
+	Quarantined() []*QuarantinedMerge
+	ReleaseQuarantined(index int, prvKey string) error
+	DropQuarantined(index int) error
+
 	// Utility functions
 	Tidy()
 	VerifyTree() error
@@ -35,6 +35,9 @@ func performSecureAction(
 			}).Error("Not allowed to perform action on target")
 			return fmt.Errorf("identity %s not allowed to perform %s on %s", id, action, target)
 		}
+		if abac != nil && action == ActionModify && abac.RequiresApproval(target) {
+			return fmt.Errorf("%s is protected by an approval rule, changes must be proposed and approved", target)
+		}
 	}
 
 	node, err := actionFn(ClientID(id))
@@ -47,16 +50,18 @@ func performSecureAction(
 		return fmt.Errorf("failed to sign node: %w", err)
 	}
 
-	// TODO: This is only required if the parent node was modified.
 	if node.ParentID != "" {
 		parentNode, ok := node.tree.GetNode(node.ParentID)
 		if !ok {
 			return fmt.Errorf("parent node %s not found for node %s", node.ParentID, node.ID)
 		}
 
-		// Sign the parent node with the same identity
-		if err := parentNode.Sign(identity); err != nil {
-			return fmt.Errorf("failed to sign parent node: %w", err)
+		// Sign the parent node with the same identity. A modified parent is always owned by the identity,
+		// a parent owned by someone else was not modified and its signature is still valid.
+		if parentNode.Owner == ClientID(id) {
+			if err := parentNode.Sign(identity); err != nil {
+				return fmt.Errorf("failed to sign parent node: %w", err)
+			}
 		}
 	}
 
@@ -68,6 +73,13 @@ func (n *AdapterSecureNodeCRDT) ID() NodeID {
 }
 
 func (n *AdapterSecureNodeCRDT) SetLiteral(value interface{}, prvKey string) error { // Tested
+	err := n.checkSchemas(prvKey, func(node *NodeCRDT, clientID ClientID) error {
+		return node.SetLiteral(value, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		if err := n.nodeCrdt.SetLiteral(value, clientID); err != nil {
 			return nil, fmt.Errorf("failed to set literal: %w", err)
@@ -88,11 +100,104 @@ func (n *AdapterSecureNodeCRDT) SetLiteral(value interface{}, prvKey string) err
 		secureAction)
 }
 
+// SetEncryptedLiteral encrypts the value to the recipients, given as did:key DIDs or hex encoded public
+// keys. The writer is always added as a recipient.
+func (n *AdapterSecureNodeCRDT) SetEncryptedLiteral(value interface{}, recipients []string, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	recipients = append([]string{identity.PublicKeyAsHex()}, recipients...)
+
+	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
+		if err := n.nodeCrdt.SetEncryptedLiteral(value, recipients, clientID); err != nil {
+			return nil, fmt.Errorf("failed to set encrypted literal: %w", err)
+		}
+		return n.nodeCrdt, nil
+	}
+
+	return performSecureAction(
+		n.nodeCrdt.ParentID != "",
+		prvKey,
+		ActionModify,
+		n.nodeCrdt.ID,
+		n.nodeCrdt.tree.ABACPolicy,
+		secureAction)
+}
+
+func (n *AdapterSecureNodeCRDT) AddReaders(readers []string, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
+		if err := n.nodeCrdt.AddReaders(readers, identity); err != nil {
+			return nil, fmt.Errorf("failed to add readers: %w", err)
+		}
+		return n.nodeCrdt, nil
+	}
+
+	return performSecureAction(
+		n.nodeCrdt.ParentID != "",
+		prvKey,
+		ActionModify,
+		n.nodeCrdt.ID,
+		n.nodeCrdt.tree.ABACPolicy,
+		secureAction)
+}
+
+func (n *AdapterSecureNodeCRDT) IsEncrypted() bool {
+	return n.nodeCrdt.IsEncrypted()
+}
+
 func (n *AdapterSecureNodeCRDT) GetLiteral() (interface{}, error) {
 	return n.nodeCrdt.GetLiteral()
 }
 
+func (n *AdapterSecureNodeCRDT) ProposeLiteral(value interface{}, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	// The approval of the proposer may already promote the value
+	err = n.nodeCrdt.tree.checkSchemas(func(trial *TreeCRDT) error {
+		return trial.ProposeLiteral(n.nodeCrdt.ID, value, identity)
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.nodeCrdt.tree.ProposeLiteral(n.nodeCrdt.ID, value, identity)
+}
+
+func (n *AdapterSecureNodeCRDT) ApproveLiteral(prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	// The approval may promote the pending value
+	err = n.nodeCrdt.tree.checkSchemas(func(trial *TreeCRDT) error {
+		return trial.ApproveLiteral(n.nodeCrdt.ID, identity)
+	})
+	if err != nil {
+		return err
+	}
+
+	return n.nodeCrdt.tree.ApproveLiteral(n.nodeCrdt.ID, identity)
+}
+
 func (n *AdapterSecureNodeCRDT) CreateMapNode(prvKey string) (SecureNode, error) { // Tested
+	err := n.checkSchemas(prvKey, func(node *NodeCRDT, clientID ClientID) error {
+		_, err := node.CreateMapNode(clientID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	var newNode *NodeCRDT
 
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
@@ -104,7 +209,7 @@ func (n *AdapterSecureNodeCRDT) CreateMapNode(prvKey string) (SecureNode, error)
 		return newNode, nil
 	}
 
-	err := performSecureAction(
+	err = performSecureAction(
 		true,
 		prvKey,
 		ActionModify,
@@ -119,6 +224,18 @@ func (n *AdapterSecureNodeCRDT) CreateMapNode(prvKey string) (SecureNode, error)
 }
 
 func (n *AdapterSecureNodeCRDT) SetKeyValue(key string, value interface{}, prvKey string) (NodeID, error) { // Tested
+	if err := n.checkKeyApproval(key); err != nil {
+		return "", err
+	}
+
+	err := n.checkSchemas(prvKey, func(node *NodeCRDT, clientID ClientID) error {
+		_, err := node.SetKeyValue(key, value, clientID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
 	var newNodeID NodeID
 
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
@@ -134,7 +251,7 @@ func (n *AdapterSecureNodeCRDT) SetKeyValue(key string, value interface{}, prvKe
 		return newNode, nil
 	}
 
-	err := performSecureAction(
+	err = performSecureAction(
 		true,
 		prvKey,
 		ActionModify,
@@ -157,6 +274,17 @@ func (n *AdapterSecureNodeCRDT) GetNodeForKey(key string) (SecureNode, bool, err
 }
 
 func (n *AdapterSecureNodeCRDT) RemoveKeyValue(key string, prvKey string) error { // Tested
+	if err := n.checkKeyApproval(key); err != nil {
+		return err
+	}
+
+	err := n.checkSchemas(prvKey, func(node *NodeCRDT, clientID ClientID) error {
+		return node.RemoveKeyValue(key, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		if err := n.nodeCrdt.RemoveKeyValue(key, clientID); err != nil {
 			return nil, fmt.Errorf("failed to remove key-value: %w", err)
@@ -174,6 +302,42 @@ func (n *AdapterSecureNodeCRDT) RemoveKeyValue(key string, prvKey string) error
 	)
 }
 
+// checkKeyApproval returns an error if the value at key, which is about to be replaced or removed, is or
+// contains a literal protected by an approval rule
+func (n *AdapterSecureNodeCRDT) checkKeyApproval(key string) error {
+	if !n.nodeCrdt.IsMap {
+		return nil
+	}
+	child, ok, err := n.nodeCrdt.GetNodeForKey(key)
+	if err != nil || !ok {
+		return err
+	}
+	if protected, ok := n.nodeCrdt.tree.protectedSubtree(child.ID); ok {
+		return fmt.Errorf("%s is protected by an approval rule, changes must be proposed and approved", protected)
+	}
+	return nil
+}
+
+// checkSchemas runs a write on the node in a clone of the tree and returns a *SchemaError if it adds
+// violations of the attached schemas
+func (n *AdapterSecureNodeCRDT) checkSchemas(prvKey string, write func(node *NodeCRDT, clientID ClientID) error) error {
+	tree := n.nodeCrdt.tree
+	if tree == nil || !tree.hasSchemas() {
+		return nil
+	}
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return tree.checkSchemas(func(trial *TreeCRDT) error {
+		node, ok := trial.Nodes[n.nodeCrdt.ID]
+		if !ok {
+			return fmt.Errorf("node %s not found", n.nodeCrdt.ID)
+		}
+		return write(node, ClientID(identity.ID()))
+	})
+}
+
 type AdapterSecureTreeCRDT struct {
 	treeCrdt *TreeCRDT
 }
@@ -200,6 +364,14 @@ func (c *AdapterSecureTreeCRDT) ABAC() *ABACPolicy {
 }
 
 func (c *AdapterSecureTreeCRDT) CreateAttachedNode(name string, nodeType NodeType, parentID NodeID, prvKey string) (SecureNode, error) { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		trial.CreateAttachedNode(name, nodeType, parentID, clientID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	var newNode *NodeCRDT
 
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
@@ -208,7 +380,7 @@ func (c *AdapterSecureTreeCRDT) CreateAttachedNode(name string, nodeType NodeTyp
 		return newNode, nil
 	}
 
-	err := performSecureAction(
+	err = performSecureAction(
 		true,
 		prvKey,
 		ActionModify,
@@ -283,7 +455,95 @@ func (c *AdapterSecureTreeCRDT) GetStringValueByPath(path string) (string, error
 	return c.treeCrdt.GetStringValueByPath(path)
 }
 
+// Query evaluates a JSONPath query, with a private key only the nodes the identity may read are returned
+func (c *AdapterSecureTreeCRDT) Query(expr string, prvKey string) ([]*QueryResult, error) {
+	if prvKey == "" {
+		return c.treeCrdt.Query(expr, nil)
+	}
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.Query(expr, identity)
+}
+
+// CreateIndex declares a secondary index on the field of the items of the array or map at path
+func (c *AdapterSecureTreeCRDT) CreateIndex(name, path, field string) error {
+	return c.treeCrdt.CreateIndex(name, path, field)
+}
+
+func (c *AdapterSecureTreeCRDT) DropIndex(name string) {
+	c.treeCrdt.DropIndex(name)
+}
+
+func (c *AdapterSecureTreeCRDT) FindByIndex(name string, value interface{}) ([]SecureNode, error) {
+	nodes, err := c.treeCrdt.FindByIndex(name, value)
+	if err != nil {
+		return nil, err
+	}
+	secureNodes := make([]SecureNode, 0, len(nodes))
+	for _, node := range nodes {
+		secureNodes = append(secureNodes, &AdapterSecureNodeCRDT{nodeCrdt: node})
+	}
+	return secureNodes, nil
+}
+
+func (c *AdapterSecureTreeCRDT) NodesByOwner(id string) ([]*NodeChange, error) {
+	return c.treeCrdt.NodesByOwner(id)
+}
+
+func (c *AdapterSecureTreeCRDT) PathsTouchedBy(id string) ([]*NodeChange, error) {
+	return c.treeCrdt.PathsTouchedBy(id)
+}
+
+func (c *AdapterSecureTreeCRDT) ChangedSince(clock VectorClock) ([]*NodeChange, error) {
+	return c.treeCrdt.ChangedSince(clock)
+}
+
+func (c *AdapterSecureTreeCRDT) Clock() VectorClock {
+	return c.treeCrdt.Clock()
+}
+
+func (c *AdapterSecureTreeCRDT) SetByPath(path string, value interface{}, prvKey string) (NodeID, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.setByPath(path, value, identity, abacPatchCheck(identity.ID()))
+}
+
+func (c *AdapterSecureTreeCRDT) InsertByPath(path string, value interface{}, prvKey string) (NodeID, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.insertByPath(path, value, identity, abacPatchCheck(identity.ID()))
+}
+
+func (c *AdapterSecureTreeCRDT) DeleteByPath(path string, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.deleteByPath(path, identity, abacPatchCheck(identity.ID()))
+}
+
+func (c *AdapterSecureTreeCRDT) EnsurePath(path string, prvKey string) (NodeID, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.ensurePath(path, identity, abacPatchCheck(identity.ID()))
+}
+
 func (c *AdapterSecureTreeCRDT) AddEdge(from, to NodeID, label string, prvKey string) error { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.AddEdge(from, to, label, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		// Perform the actual edge addition
 		node, ok := c.treeCrdt.GetNode(from)
@@ -310,6 +570,13 @@ func (c *AdapterSecureTreeCRDT) AddEdge(from, to NodeID, label string, prvKey st
 }
 
 func (c *AdapterSecureTreeCRDT) RemoveEdge(from, to NodeID, prvKey string) error { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.RemoveEdge(from, to, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		node, ok := c.treeCrdt.GetNode(from)
 		if !ok {
@@ -333,6 +600,13 @@ func (c *AdapterSecureTreeCRDT) RemoveEdge(from, to NodeID, prvKey string) error
 }
 
 func (c *AdapterSecureTreeCRDT) AppendEdge(from, to NodeID, label string, prvKey string) error { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.AppendEdge(from, to, label, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		node, ok := c.treeCrdt.GetNode(from)
 		if !ok {
@@ -356,6 +630,13 @@ func (c *AdapterSecureTreeCRDT) AppendEdge(from, to NodeID, label string, prvKey
 }
 
 func (c *AdapterSecureTreeCRDT) PrependEdge(from, to NodeID, label string, prvKey string) error { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.PrependEdge(from, to, label, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		node, ok := c.treeCrdt.GetNode(from)
 		if !ok {
@@ -379,6 +660,13 @@ func (c *AdapterSecureTreeCRDT) PrependEdge(from, to NodeID, label string, prvKe
 }
 
 func (c *AdapterSecureTreeCRDT) InsertEdgeLeft(from, to NodeID, label string, sibling NodeID, prvKey string) error { // Tested
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.InsertEdgeLeft(from, to, label, sibling, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		node, ok := c.treeCrdt.Nodes[from]
 		if !ok {
@@ -402,6 +690,13 @@ func (c *AdapterSecureTreeCRDT) InsertEdgeLeft(from, to NodeID, label string, si
 }
 
 func (c *AdapterSecureTreeCRDT) InsertEdgeRight(from, to NodeID, label string, sibling NodeID, prvKey string) error {
+	err := c.checkSchemas(prvKey, func(trial *TreeCRDT, clientID ClientID) error {
+		return trial.InsertEdgeRight(from, to, label, sibling, clientID)
+	})
+	if err != nil {
+		return err
+	}
+
 	secureAction := func(clientID ClientID) (*NodeCRDT, error) {
 		node, ok := c.treeCrdt.Nodes[from]
 		if !ok {
@@ -424,6 +719,21 @@ func (c *AdapterSecureTreeCRDT) InsertEdgeRight(from, to NodeID, label string, s
 	)
 }
 
+// checkSchemas runs a write on a clone of the tree and returns a *SchemaError if it adds violations of
+// the attached schemas
+func (c *AdapterSecureTreeCRDT) checkSchemas(prvKey string, write func(trial *TreeCRDT, clientID ClientID) error) error {
+	if !c.treeCrdt.hasSchemas() {
+		return nil
+	}
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return c.treeCrdt.checkSchemas(func(trial *TreeCRDT) error {
+		return write(trial, ClientID(identity.ID()))
+	})
+}
+
 func (c *AdapterSecureTreeCRDT) Merge(c2 SecureTree, prvKey string) error { // TODO: test
 	adapter, ok := c2.(*AdapterSecureTreeCRDT)
 	if !ok {
@@ -432,6 +742,10 @@ func (c *AdapterSecureTreeCRDT) Merge(c2 SecureTree, prvKey string) error { // T
 	return c.treeCrdt.SecureMerge(adapter.treeCrdt, prvKey)
 }
 
+func (c *AdapterSecureTreeCRDT) SetIDScheme(scheme IDScheme) {
+	c.treeCrdt.SetIDScheme(scheme)
+}
+
 func (c *AdapterSecureTreeCRDT) ImportJSON(rawJSON []byte, prvKey string) (NodeID, error) { // Tested
 	identity, err := crypto.CreateIdendityFromString(prvKey)
 	if err != nil {
@@ -443,6 +757,17 @@ func (c *AdapterSecureTreeCRDT) ImportJSON(rawJSON []byte, prvKey string) (NodeI
 	if !c.treeCrdt.ABACPolicy.IsAllowed(id, ActionModify, c.treeCrdt.Root.ID) {
 		return "", fmt.Errorf("identity %s is not allowed to import under root", id)
 	}
+	if c.treeCrdt.ABACPolicy.RequiresApproval(c.treeCrdt.Root.ID) {
+		return "", fmt.Errorf("root is protected by an approval rule, cannot import")
+	}
+
+	err = c.treeCrdt.checkSchemas(func(trial *TreeCRDT) error {
+		_, err := trial.ImportJSON(rawJSON, ClientID(id))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 
 	return c.treeCrdt.SecureImportJSON(rawJSON, identity)
 }
@@ -458,6 +783,17 @@ func (c *AdapterSecureTreeCRDT) ImportJSONToMap(rawJSON []byte, parentID NodeID,
 	if !c.treeCrdt.ABACPolicy.IsAllowed(id, ActionModify, parentID) {
 		return "", fmt.Errorf("identity %s is not allowed to import under parent %s", id, parentID)
 	}
+	if c.treeCrdt.ABACPolicy.RequiresApproval(parentID) {
+		return "", fmt.Errorf("parent %s is protected by an approval rule, cannot import", parentID)
+	}
+
+	err = c.treeCrdt.checkSchemas(func(trial *TreeCRDT) error {
+		_, err := trial.ImportJSONToMap(rawJSON, parentID, key, ClientID(id))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 
 	return c.treeCrdt.SecureImportJSONToMap(rawJSON, parentID, key, identity)
 }
@@ -473,6 +809,17 @@ func (c *AdapterSecureTreeCRDT) ImportJSONToArray(rawJSON []byte, parentID NodeI
 	if !c.treeCrdt.ABACPolicy.IsAllowed(id, ActionModify, parentID) {
 		return "", fmt.Errorf("identity %s is not allowed to import under parent %s", id, parentID)
 	}
+	if c.treeCrdt.ABACPolicy.RequiresApproval(parentID) {
+		return "", fmt.Errorf("parent %s is protected by an approval rule, cannot import", parentID)
+	}
+
+	err = c.treeCrdt.checkSchemas(func(trial *TreeCRDT) error {
+		_, err := trial.ImportJSONToArray(rawJSON, parentID, ClientID(id))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 
 	return c.treeCrdt.SecureImportJSONToArray(rawJSON, parentID, identity)
 }
@@ -498,34 +845,168 @@ func (c *AdapterSecureTreeCRDT) ExportJSON() ([]byte, error) {
 	return c.treeCrdt.ExportJSON()
 }
 
+func (c *AdapterSecureTreeCRDT) ExportPath(path string) ([]byte, error) {
+	return c.treeCrdt.ExportPath(path)
+}
+
+func (c *AdapterSecureTreeCRDT) ExtractSubtree(path string, prvKey string) (SecureTree, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity from string: %w", err)
+	}
+
+	extracted, err := c.treeCrdt.ExtractSubtree(path, identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract subtree: %w", err)
+	}
+	return &AdapterSecureTreeCRDT{treeCrdt: extracted}, nil
+}
+
+func (c *AdapterSecureTreeCRDT) ExportAnnotatedJSON() ([]byte, error) {
+	return c.treeCrdt.ExportAnnotatedJSON()
+}
+
+func (c *AdapterSecureTreeCRDT) ImportYAML(rawYAML []byte, prvKey string) (NodeID, error) {
+	rawJSON, err := YAMLToJSON(rawYAML)
+	if err != nil {
+		return "", err
+	}
+	return c.ImportJSON(rawJSON, prvKey)
+}
+
+func (c *AdapterSecureTreeCRDT) ImportTOML(rawTOML []byte, prvKey string) (NodeID, error) {
+	rawJSON, err := TOMLToJSON(rawTOML)
+	if err != nil {
+		return "", err
+	}
+	return c.ImportJSON(rawJSON, prvKey)
+}
+
+func (c *AdapterSecureTreeCRDT) ExportYAML() ([]byte, error) {
+	return c.treeCrdt.ExportYAML()
+}
+
+func (c *AdapterSecureTreeCRDT) ExportTOML() ([]byte, error) {
+	return c.treeCrdt.ExportTOML()
+}
+
+// ApplyJSONPatch applies an RFC 6902 patch, every changed node is checked against the ABAC policy and signed
+func (c *AdapterSecureTreeCRDT) ApplyJSONPatch(patch []byte, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity from string: %w", err)
+	}
+
+	return c.treeCrdt.applyJSONPatch(patch, identity, abacPatchCheck(identity.ID()))
+}
+
+// ReconcileJSON updates the value at path to the document with as few changes as possible, every changed
+// node is checked against the ABAC policy and signed
+func (c *AdapterSecureTreeCRDT) ReconcileJSON(path string, doc []byte, prvKey string) ([]*JSONPatchOperation, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	return c.treeCrdt.reconcileJSON(path, doc, identity, abacPatchCheck(identity.ID()))
+}
+
+func abacPatchCheck(id string) patchCheck {
+	return func(tree *TreeCRDT, action ABACAction, target NodeID) error {
+		abac := tree.ABACPolicy
+		if abac == nil {
+			return nil
+		}
+		if !abac.IsAllowed(id, action, target) {
+			return fmt.Errorf("identity %s not allowed to perform %s on %s", id, action, target)
+		}
+		if action == ActionModify && abac.RequiresApproval(target) {
+			return fmt.Errorf("%s is protected by an approval rule, changes must be proposed and approved", target)
+		}
+		return nil
+	}
+}
+
+func (c *AdapterSecureTreeCRDT) DiffAsJSONPatch(other SecureTree) ([]byte, error) {
+	adapter, ok := other.(*AdapterSecureTreeCRDT)
+	if !ok {
+		return nil, fmt.Errorf("DiffAsJSONPatch: tree must be of type *AdapterSecureTreeCRDT")
+	}
+	return c.treeCrdt.DiffAsJSONPatch(adapter.treeCrdt)
+}
+
+// Load verifies the ABAC policy of the data before replacing the tree, so the tree is left unchanged if
+// the data is invalid
 func (c *AdapterSecureTreeCRDT) Load(data []byte) error {
+	loaded, _, err := c.decode(data)
+	if err != nil {
+		return err
+	}
+	c.treeCrdt.replaceWith(loaded)
+	return nil
+}
+
+// Migrate loads data saved in an older format version and re-signs the nodes owned by prvKey whose
+// signature digest changed. Nodes owned by others are listed as unresolved in the report.
+func (c *AdapterSecureTreeCRDT) Migrate(data []byte, prvKey string) (*MigrationReport, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity from string: %w", err)
+	}
+
+	loaded, report, err := c.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if report.Resigned, report.Unresolved, err = loaded.resign(report.Resign, identity); err != nil {
+		return nil, err
+	}
+	c.treeCrdt.replaceWith(loaded)
+
+	log.WithFields(log.Fields{
+		"FromVersion": report.FromVersion,
+		"ToVersion":   report.ToVersion,
+		"Resigned":    len(report.Resigned),
+		"Unresolved":  len(report.Unresolved),
+	}).Info("Migrated tree")
+
+	return report, nil
+}
+
+func (c *AdapterSecureTreeCRDT) decode(data []byte) (*TreeCRDT, *MigrationReport, error) {
 	identity := c.treeCrdt.ABACPolicy.identity
-	err := c.treeCrdt.Load(data)
+	loaded, report, err := c.treeCrdt.decode(data)
 	if err != nil {
-		return fmt.Errorf("failed to load tree data: %w", err)
+		return nil, nil, fmt.Errorf("failed to load tree data: %w", err)
 	}
-	c.treeCrdt.ABACPolicy.tree = c.treeCrdt
-	c.treeCrdt.ABACPolicy.identity = identity
-	recoveredID, err := c.treeCrdt.ABACPolicy.Verify()
+	if loaded.ABACPolicy == c.treeCrdt.ABACPolicy {
+		return nil, nil, fmt.Errorf("failed to load tree data: missing ABAC policy")
+	}
+	loaded.ABACPolicy.tree = loaded
+	loaded.ABACPolicy.identity = identity
+	recoveredID, err := loaded.ABACPolicy.Verify()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Identity":    identity.ID(),
 			"Action":      "Load",
-			"Owner":       c.treeCrdt.ABACPolicy.OwnerID,
+			"Owner":       loaded.ABACPolicy.OwnerID,
 			"RecoveredID": recoveredID,
 			"Error":       err,
 		}).Error("Failed to verify ABAC policy after loading, recovered ID does not match owner ID")
 
-		return fmt.Errorf("failed to verify ABAC policy after loading: %w", err)
+		return nil, nil, fmt.Errorf("failed to verify ABAC policy after loading: %w", err)
 	}
 
-	return nil
+	return loaded, report, nil
 }
 
 func (c *AdapterSecureTreeCRDT) Save() ([]byte, error) {
 	return c.treeCrdt.Save()
 }
 
+func (c *AdapterSecureTreeCRDT) SaveAs(format Format) ([]byte, error) {
+	return c.treeCrdt.SaveAs(format)
+}
+
 func (c *AdapterSecureTreeCRDT) Subscribe(path string, ch chan NodeEvent) {
 	c.treeCrdt.Subscribe(path, ch)
 }
@@ -537,3 +1018,139 @@ func (c *AdapterSecureTreeCRDT) Tidy() {
 func (c *AdapterSecureTreeCRDT) VerifyTree() error {
 	return c.treeCrdt.VerifyTree()
 }
+
+func (c *AdapterSecureTreeCRDT) RootHash() (string, error) {
+	return c.treeCrdt.RootHash()
+}
+
+func (c *AdapterSecureTreeCRDT) SignCheckpoint(prvKey string) (*Checkpoint, error) {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity from string: %w", err)
+	}
+
+	return c.treeCrdt.SignCheckpoint(identity)
+}
+
+func (c *AdapterSecureTreeCRDT) VerifyCheckpoint(checkpoint *Checkpoint) error {
+	return c.treeCrdt.VerifyCheckpoint(checkpoint)
+}
+
+func (c *AdapterSecureTreeCRDT) Prove(path string) (*Proof, error) {
+	return c.treeCrdt.Prove(path)
+}
+
+func (c *AdapterSecureTreeCRDT) CommitRecoveryKey(prvKey string, recoveryID string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity from string: %w", err)
+	}
+
+	return c.treeCrdt.CommitRecoveryKey(identity, recoveryID)
+}
+
+func (c *AdapterSecureTreeCRDT) RotateKey(oldPrvKey string, newPrvKey string) error {
+	oldIdentity, err := crypto.CreateIdendityFromString(oldPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create old identity from string: %w", err)
+	}
+	newIdentity, err := crypto.CreateIdendityFromString(newPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create new identity from string: %w", err)
+	}
+
+	_, err = c.treeCrdt.RotateKey(oldIdentity, newIdentity)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) RecoverKey(recoveryPrvKey string, oldID string, newPrvKey string) error {
+	recoveryIdentity, err := crypto.CreateIdendityFromString(recoveryPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create recovery identity from string: %w", err)
+	}
+	newIdentity, err := crypto.CreateIdendityFromString(newPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create new identity from string: %w", err)
+	}
+
+	_, err = c.treeCrdt.RecoverKey(recoveryIdentity, oldID, newIdentity)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) DisputedKeys() []string {
+	return c.treeCrdt.DisputedKeys()
+}
+
+func (c *AdapterSecureTreeCRDT) LinkDevice(masterPrvKey string, devicePrvKey string, index uint32) error {
+	masterIdentity, err := crypto.CreateIdendityFromString(masterPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create master identity from string: %w", err)
+	}
+	deviceIdentity, err := crypto.CreateIdendityFromString(devicePrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create device identity from string: %w", err)
+	}
+
+	_, err = c.treeCrdt.LinkDevice(masterIdentity, deviceIdentity, index)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) UnlinkDevice(masterPrvKey string, deviceID string) error {
+	masterIdentity, err := crypto.CreateIdendityFromString(masterPrvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create master identity from string: %w", err)
+	}
+
+	_, err = c.treeCrdt.UnlinkDevice(masterIdentity, deviceID)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) Devices(masterID string) []string {
+	masterID, err := crypto.ResolveID(masterID)
+	if err != nil {
+		return []string{}
+	}
+	return c.treeCrdt.Devices(masterID)
+}
+
+func (c *AdapterSecureTreeCRDT) AttachSchema(path string, schema []byte, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	_, err = c.treeCrdt.AttachSchema(path, schema, identity)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) DetachSchema(path string, prvKey string) error {
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return fmt.Errorf("failed to create identity from string: %w", err)
+	}
+	_, err = c.treeCrdt.DetachSchema(path, identity)
+	return err
+}
+
+func (c *AdapterSecureTreeCRDT) AttachedSchemas() map[string][]byte {
+	return c.treeCrdt.AttachedSchemas()
+}
+
+func (c *AdapterSecureTreeCRDT) ValidateSchemas() error {
+	return c.treeCrdt.ValidateSchemas()
+}
+
+func (c *AdapterSecureTreeCRDT) SetSchemaMergeMode(mode SchemaMergeMode) {
+	c.treeCrdt.SetSchemaMergeMode(mode)
+}
+
+func (c *AdapterSecureTreeCRDT) Quarantined() []*QuarantinedMerge {
+	return c.treeCrdt.Quarantined()
+}
+
+func (c *AdapterSecureTreeCRDT) ReleaseQuarantined(index int, prvKey string) error {
+	return c.treeCrdt.ReleaseQuarantined(index, prvKey)
+}
+
+func (c *AdapterSecureTreeCRDT) DropQuarantined(index int) error {
+	return c.treeCrdt.DropQuarantined(index)
+}
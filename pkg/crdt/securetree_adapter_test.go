@@ -613,6 +613,36 @@ func TestSecureTreeAdapterMergeABAC(t *testing.T) {
 	assert.Nil(t, err, "Merge should not return an error after restoring the signature")
 }
 
+func TestSecureTreeAdapterSharedWrites(t *testing.T) {
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(prvKey1)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"a": {"b": "1"}}`), prvKey1)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.ABAC().Allow(identity2.ID(), ActionModify, "root", true))
+
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	// Writing a literal does not change its parent, so the parent keeps the signature of its owner
+	node, err := c2.GetNodeByPath("/a/b")
+	assert.Nil(t, err)
+	assert.Nil(t, node.SetLiteral("2", prvKey2))
+	assert.Nil(t, c2.VerifyTree(), "The parent of a literal written by another identity should still verify")
+
+	// The merged literal is owned and signed by the writer of the winning value, not by the lowest owner
+	assert.Nil(t, c1.Merge(c2, prvKey1))
+	assert.Nil(t, c1.VerifyTree())
+	value, err := c1.GetValueByPath("/a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", value)
+}
+
 func TestSecureTreeAdapterMergeComplexJSONABAC(t *testing.T) {
 	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
 	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
@@ -727,6 +757,28 @@ func (t *DummyTree) isDescendant(root NodeID, target NodeID) bool {
 	return false
 }
 
+func (t *DummyTree) keyChain(id string) []string {
+	return []string{id}
+}
+
+func (t *DummyTree) dropUnprotectedProposals() {}
+
+func (t *DummyTree) protectedValues() map[NodeID]interface{} {
+	return nil
+}
+
+func (t *DummyTree) releaseValues(values map[NodeID]interface{}, identity *crypto.Idendity) error {
+	return nil
+}
+
+func (t *DummyTree) policySignerAllowed(ownerID string, signerID string, signature string) bool {
+	return ownerID == signerID
+}
+
+func (t *DummyTree) deviceMaster(id string) string {
+	return ""
+}
+
 func TestABACPolicyMerge_LWW(t *testing.T) {
 	// Setup identities
 	identityA, err := crypto.CreateIdendity()
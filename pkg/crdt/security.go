@@ -143,6 +143,7 @@ func (n *NodeCRDT) Sign(identity *crypto.Idendity) error {
 
 	signatureStr := hex.EncodeToString(signature)
 	n.Signature = signatureStr
+	n.invalidateHash()
 
 	return nil
 }
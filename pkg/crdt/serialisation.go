@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strconv"
 
 	"github.com/eislab-cps/synctree/internal/crypto"
 	"github.com/iancoleman/orderedmap"
@@ -123,10 +125,28 @@ func (c *TreeCRDT) importJSON(rawJSON []byte, parentID NodeID, edgeLabel string,
 func (c *TreeCRDT) secureImportRecursive(v interface{}, parent *NodeCRDT, edgeLabel string, idx int, nodeType NodeType, clientID ClientID, secure bool, identity *crypto.Idendity) (NodeID, error) {
 	version := 1
 
+	// Derived IDs need the parent, detached values always get random IDs
+	parentID, key := NodeID(""), edgeLabel
+	if parent != nil {
+		parentID = parent.ID
+		if nodeType == Array {
+			key = strconv.Itoa(idx)
+		}
+	}
+	nodeID := func(label string) (NodeID, error) {
+		if parent == nil {
+			return generateRandomNodeID(label), nil
+		}
+		return c.importNodeID(label, parentID, key, clientID)
+	}
+
 	switch val := v.(type) {
 	case map[string]interface{}:
 		// Map node
-		mapNodeID := generateRandomNodeID("map")
+		mapNodeID, err := nodeID("map")
+		if err != nil {
+			return "", err
+		}
 		mapNode := c.getOrCreateNode(mapNodeID, Map, clientID, version)
 		if secure {
 			err := mapNode.Sign(identity)
@@ -170,7 +190,10 @@ func (c *TreeCRDT) secureImportRecursive(v interface{}, parent *NodeCRDT, edgeLa
 
 	case []interface{}:
 		// Array node
-		arrayNodeID := generateRandomNodeID("arr")
+		arrayNodeID, err := nodeID("arr")
+		if err != nil {
+			return "", err
+		}
 		arrayNode := c.getOrCreateNode(arrayNodeID, Array, clientID, version)
 		if secure {
 			err := arrayNode.Sign(identity)
@@ -214,9 +237,12 @@ func (c *TreeCRDT) secureImportRecursive(v interface{}, parent *NodeCRDT, edgeLa
 
 	default:
 		// Literal node
-		literalID := generateRandomNodeID("lit")
+		literalID, err := nodeID("lit")
+		if err != nil {
+			return "", err
+		}
 		literalNode := c.getOrCreateNode(literalID, Literal, clientID, version)
-		err := literalNode.setLiteralWithVersion(val, clientID, version)
+		err = literalNode.setLiteralWithVersion(val, clientID, version)
 		if err != nil {
 			return "", err
 		}
@@ -268,24 +294,32 @@ func (c *TreeCRDT) Save() ([]byte, error) {
 			}
 		}
 
-		nodes[string(id)] = map[string]interface{}{
-			"id":            string(node.ID),
-			"isroot":        node.IsRoot,
-			"deleted":       node.IsDeleted,
-			"parentid":      string(node.ParentID),
-			"isarray":       node.IsArray,
-			"ispromoted":    node.IsPromoted,
-			"ismap":         node.IsMap,
-			"isliteral":     node.IsLiteral,
-			"litteralValue": node.LiteralValue,
-			"owner":         string(node.Owner),
-			"clock":         node.Clock,
-			"signature":     node.Signature,
-			"nounce":        node.Nounce,
-			"edges":         edges,
+		nodeMap := map[string]interface{}{
+			"id":           string(node.ID),
+			"isroot":       node.IsRoot,
+			"deleted":      node.IsDeleted,
+			"parentid":     string(node.ParentID),
+			"isarray":      node.IsArray,
+			"ispromoted":   node.IsPromoted,
+			"ismap":        node.IsMap,
+			"isliteral":    node.IsLiteral,
+			"literalValue": node.LiteralValue,
+			"owner":        string(node.Owner),
+			"clock":        node.Clock,
+			"signature":    node.Signature,
+			"nounce":       node.Nounce,
+			"edges":        edges,
+		}
+		if node.Approved != nil {
+			nodeMap["approved"] = node.Approved
 		}
+		if node.Pending != nil {
+			nodeMap["pending"] = node.Pending
+		}
+		nodes[string(id)] = nodeMap
 	}
 
+	exportable["formatVersion"] = FormatVersion
 	exportable["root"] = string(c.Root.ID)
 	exportable["secure"] = c.Secure
 	exportable["nodes"] = nodes
@@ -298,97 +332,574 @@ func (c *TreeCRDT) Save() ([]byte, error) {
 		exportable["abac"] = json.RawMessage(abacJSON)
 	}
 
+	if len(c.RecoveryCommitments) > 0 {
+		exportable["recoverycommitments"] = c.RecoveryCommitments
+	}
+	if len(c.KeyRotations) > 0 {
+		exportable["keyrotations"] = c.KeyRotations
+	}
+	if len(c.DeviceLinks) > 0 {
+		exportable["devicelinks"] = c.DeviceLinks
+	}
+	if len(c.Schemas) > 0 {
+		exportable["schemas"] = c.Schemas
+	}
+
 	return json.MarshalIndent(exportable, "", "  ")
 }
 
+// Limits enforced by Load, so that data from untrusted peers cannot exhaust memory
+var (
+	MaxLoadSize     = 256 << 20
+	MaxLoadNodes    = 1 << 20
+	MaxLoadEdges    = 1 << 16 // Per node
+	MaxLSEQDepth    = 256
+	maxClockVersion = float64(1 << 53)
+	maxLSEQPosition = float64(1 << 31)
+)
+
+// Load replaces the tree with the saved data in JSON or binary format. The data is fully decoded and
+// validated before the tree is modified, missing or mistyped fields are reported with their position.
 func (c *TreeCRDT) Load(data []byte) error {
-	var raw map[string]interface{}
-	if err := json.Unmarshal(data, &raw); err != nil {
+	loaded, _, err := c.decode(data)
+	if err != nil {
 		return err
 	}
+	c.replaceWith(loaded)
+	return nil
+}
 
-	nodesRaw, ok := raw["nodes"].(map[string]interface{})
-	if !ok {
-		return errors.New("missing or invalid 'nodes' field")
+// Migrate loads data saved in an older format version and re-signs the nodes owned by the identity whose
+// signature digest changed
+func (c *TreeCRDT) Migrate(data []byte, identity *crypto.Idendity) (*MigrationReport, error) {
+	loaded, report, err := c.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if report.Resigned, report.Unresolved, err = loaded.resign(report.Resign, identity); err != nil {
+		return nil, err
+	}
+	c.replaceWith(loaded)
+	return report, nil
+}
+
+func (c *TreeCRDT) decode(data []byte) (*TreeCRDT, *MigrationReport, error) {
+	if len(data) > MaxLoadSize {
+		return nil, nil, fmt.Errorf("data size %d exceeds the limit of %d bytes", len(data), MaxLoadSize)
 	}
 
-	c.Nodes = make(map[NodeID]*NodeCRDT)
+	var raw map[string]interface{}
+	if isBinary(data) {
+		var err error
+		if raw, err = decodeBinary(data); err != nil {
+			return nil, nil, err
+		}
+	} else if err := json.Unmarshal(data, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, nil, fmt.Errorf("invalid JSON at offset %d: %w", syntaxErr.Offset, err)
+		}
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	} else if raw == nil {
+		return nil, nil, errors.New("invalid JSON: expected object, got null")
+	}
+
+	report, err := migrate(raw, migrations, FormatVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loaded := &TreeCRDT{
+		Nodes:      make(map[NodeID]*NodeCRDT),
+		ABACPolicy: c.ABACPolicy,
+		Secure:     c.Secure,
+		sigCache:   c.sigCache,
+	}
+
+	nodesRaw, err := objectField(raw, "nodes", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nodesRaw) > MaxLoadNodes {
+		return nil, nil, fmt.Errorf("nodes: %d nodes exceeds the limit of %d", len(nodesRaw), MaxLoadNodes)
+	}
 
 	for idStr, val := range nodesRaw {
-		nodeMap := val.(map[string]interface{})
-		node := &NodeCRDT{
-			ID:           NodeID(idStr),
-			Edges:        []*EdgeCRDT{},
-			Clock:        make(VectorClock),
-			IsRoot:       nodeMap["isroot"].(bool),
-			ParentID:     NodeID(nodeMap["parentid"].(string)),
-			IsArray:      nodeMap["isarray"].(bool),
-			IsPromoted:   nodeMap["ispromoted"].(bool),
-			IsMap:        nodeMap["ismap"].(bool),
-			IsDeleted:    nodeMap["deleted"].(bool),
-			IsLiteral:    nodeMap["isliteral"].(bool),
-			LiteralValue: nodeMap["litteralValue"],
-			Owner:        ClientID(nodeMap["owner"].(string)),
-			Signature:    nodeMap["signature"].(string),
-			Nounce:       nodeMap["nounce"].(string),
+		path := fmt.Sprintf("nodes[%q]", idStr)
+		nodeMap, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: expected object, got %s", path, jsonType(val))
 		}
-		node.tree = c
+		node, err := decodeNode(NodeID(idStr), nodeMap, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		node.tree = loaded
+		loaded.Nodes[node.ID] = node
+	}
 
-		if clockMap, ok := nodeMap["clock"].(map[string]interface{}); ok {
-			for k, v := range clockMap {
-				if floatVal, ok := v.(float64); ok {
-					node.Clock[ClientID(k)] = int(floatVal)
-				}
+	// Edges may only point to nodes in the file
+	for _, node := range loaded.Nodes {
+		for i, edge := range node.Edges {
+			if _, ok := loaded.Nodes[edge.To]; !ok {
+				return nil, nil, fmt.Errorf("nodes[%q].edges[%d].to: dangling edge to missing node %s", node.ID, i, edge.To)
 			}
 		}
+	}
 
-		c.Nodes[node.ID] = node
+	rootStr, err := stringField(raw, "root", "")
+	if err != nil {
+		return nil, nil, err
 	}
+	root, ok := loaded.Nodes[NodeID(rootStr)]
+	if !ok {
+		return nil, nil, fmt.Errorf("root: root node %s not found", rootStr)
+	}
+	if !root.IsRoot {
+		return nil, nil, fmt.Errorf("root: node %s is not marked as root", rootStr)
+	}
+	loaded.Root = root
 
-	for idStr, val := range nodesRaw {
-		node := c.Nodes[NodeID(idStr)]
-		edgeArr := val.(map[string]interface{})["edges"].([]interface{})
-		for _, e := range edgeArr {
-			em := e.(map[string]interface{})
-			edge := &EdgeCRDT{
-				From:         NodeID(em["from"].(string)),
-				To:           NodeID(em["to"].(string)),
-				Label:        em["label"].(string),
-				LSEQPosition: []int{},
+	if err := loaded.checkStructure(); err != nil {
+		return nil, nil, err
+	}
+
+	if v, ok := raw["secure"]; ok {
+		secure, ok := v.(bool)
+		if !ok {
+			return nil, nil, fmt.Errorf("secure: expected bool, got %s", jsonType(v))
+		}
+		loaded.Secure = secure
+	}
+
+	if v, ok := raw["abac"]; ok && v != nil {
+		abacObj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("abac: expected object, got %s", jsonType(v))
+		}
+		abacBytes, err := json.Marshal(abacObj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("abac: failed to re-marshal ABAC policy: %w", err)
+		}
+
+		policy := &ABACPolicy{}
+		if err := policy.UnmarshalJSON(abacBytes); err != nil {
+			return nil, nil, fmt.Errorf("abac: failed to parse ABAC policy: %w", err)
+		}
+		if policy.Rules == nil {
+			policy.Rules = make(map[string]map[ABACAction]map[NodeID]ABACRule)
+		}
+		if policy.Clock == nil {
+			policy.Clock = make(VectorClock)
+		}
+		loaded.ABACPolicy = policy
+	}
+
+	if err := loaded.loadKeys(raw); err != nil {
+		return nil, nil, err
+	}
+
+	if err := loaded.loadDevices(raw); err != nil {
+		return nil, nil, err
+	}
+
+	if err := loaded.loadSchemas(raw); err != nil {
+		return nil, nil, err
+	}
+
+	return loaded, report, nil
+}
+
+// checkStructure makes sure the decoded nodes form a tree, traversals such as RootHash and
+// ExportJSON do not terminate on cycles. Unlinked nodes are allowed, they are kept until Tidy.
+func (c *TreeCRDT) checkStructure() error {
+	parents := make(map[NodeID]NodeID)
+	for _, node := range c.Nodes {
+		for i, edge := range node.Edges {
+			if edge.To == c.Root.ID {
+				return fmt.Errorf("nodes[%q].edges[%d].to: edge to the root node", node.ID, i)
 			}
-			for _, pos := range em["lseqposition"].([]interface{}) {
-				edge.LSEQPosition = append(edge.LSEQPosition, int(pos.(float64)))
+			if parent, ok := parents[edge.To]; ok && parent != node.ID {
+				return fmt.Errorf("nodes[%q].edges[%d].to: node %s has multiple parents: %s and %s", node.ID, i, edge.To, parent, node.ID)
 			}
-			node.Edges = append(node.Edges, edge)
+			parents[edge.To] = node.ID
 		}
 	}
 
-	if rootStr, ok := raw["root"].(string); ok {
-		c.Root = c.Nodes[NodeID(rootStr)]
-	} else {
-		return errors.New("missing root node ID")
+	// With a single parent per node every chain of parents must end at a node without one
+	acyclic := make(map[NodeID]bool)
+	for id := range c.Nodes {
+		chain := make(map[NodeID]bool)
+		for current := id; !acyclic[current]; {
+			if chain[current] {
+				return fmt.Errorf("nodes[%q]: cycle detected", current)
+			}
+			chain[current] = true
+			parent, ok := parents[current]
+			if !ok {
+				break
+			}
+			current = parent
+		}
+		for node := range chain {
+			acyclic[node] = true
+		}
 	}
 
-	if secure, ok := raw["secure"].(bool); ok {
-		c.Secure = secure
+	return nil
+}
+
+// replaceWith moves the state of a decoded tree into c
+func (c *TreeCRDT) replaceWith(loaded *TreeCRDT) {
+	c.Root = loaded.Root
+	c.Nodes = loaded.Nodes
+	c.ABACPolicy = loaded.ABACPolicy
+	c.Secure = loaded.Secure
+	c.KeyRotations = loaded.KeyRotations
+	c.RecoveryCommitments = loaded.RecoveryCommitments
+	c.DeviceLinks = loaded.DeviceLinks
+	c.Schemas = loaded.Schemas
+	c.schemaCache = loaded.schemaCache
+	c.keys = loaded.keys
+	c.devices = loaded.devices
+	c.invalidateIndex()
+
+	for _, node := range c.Nodes {
+		node.tree = c
+	}
+	if c.ABACPolicy != nil {
+		c.ABACPolicy.tree = c // Set the tree reference for ABACPolicy
 	}
+}
 
-	if abacObj, ok := raw["abac"].(map[string]interface{}); ok {
-		abacBytes, err := json.Marshal(abacObj)
+func decodeNode(id NodeID, nodeMap map[string]interface{}, path string) (*NodeCRDT, error) {
+	literal, err := field(nodeMap, "literalValue", path)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &NodeCRDT{
+		ID:           id,
+		Edges:        []*EdgeCRDT{},
+		Clock:        make(VectorClock),
+		LiteralValue: literal,
+	}
+
+	boolFields := []struct {
+		key    string
+		target *bool
+	}{
+		{"isroot", &node.IsRoot},
+		{"deleted", &node.IsDeleted},
+		{"isarray", &node.IsArray},
+		{"ispromoted", &node.IsPromoted},
+		{"ismap", &node.IsMap},
+		{"isliteral", &node.IsLiteral},
+	}
+	for _, f := range boolFields {
+		if *f.target, err = boolField(nodeMap, f.key, path); err != nil {
+			return nil, err
+		}
+	}
+
+	var parentID, owner string
+	stringFields := []struct {
+		key    string
+		target *string
+	}{
+		{"parentid", &parentID},
+		{"owner", &owner},
+		{"signature", &node.Signature},
+		{"nounce", &node.Nounce},
+	}
+	for _, f := range stringFields {
+		if *f.target, err = stringField(nodeMap, f.key, path); err != nil {
+			return nil, err
+		}
+	}
+	node.ParentID = NodeID(parentID)
+	node.Owner = ClientID(owner)
+
+	var clockMap map[string]interface{}
+	if v, ok := nodeMap["clock"]; !ok || v != nil { // The root of a new tree has no clock
+		if clockMap, err = objectField(nodeMap, "clock", path); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range clockMap {
+		version, err := intValue(v, fmt.Sprintf("%s.clock[%q]", path, k), maxClockVersion)
 		if err != nil {
-			return fmt.Errorf("failed to re-marshal ABAC policy: %w", err)
+			return nil, err
 		}
+		node.Clock[ClientID(k)] = version
+	}
+
+	if node.Approved, err = loadProposedChange(nodeMap["approved"]); err != nil {
+		return nil, fmt.Errorf("%s.approved: failed to load approved change: %w", path, err)
+	}
+	if node.Pending, err = loadProposedChange(nodeMap["pending"]); err != nil {
+		return nil, fmt.Errorf("%s.pending: failed to load pending change: %w", path, err)
+	}
 
-		c.ABACPolicy = &ABACPolicy{}
-		if err := c.ABACPolicy.UnmarshalJSON(abacBytes); err != nil {
-			return fmt.Errorf("failed to parse ABAC policy: %w", err)
+	edgeArr, err := arrayField(nodeMap, "edges", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(edgeArr) > MaxLoadEdges {
+		return nil, fmt.Errorf("%s.edges: %d edges exceeds the limit of %d", path, len(edgeArr), MaxLoadEdges)
+	}
+	for i, e := range edgeArr {
+		edgePath := fmt.Sprintf("%s.edges[%d]", path, i)
+		em, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected object, got %s", edgePath, jsonType(e))
+		}
+		edge, err := decodeEdge(em, edgePath)
+		if err != nil {
+			return nil, err
+		}
+		if edge.From != id {
+			return nil, fmt.Errorf("%s.from: edge from %s stored in node %s", edgePath, edge.From, id)
+		}
+		node.Edges = append(node.Edges, edge)
+	}
+
+	return node, nil
+}
+
+func decodeEdge(em map[string]interface{}, path string) (*EdgeCRDT, error) {
+	from, err := stringField(em, "from", path)
+	if err != nil {
+		return nil, err
+	}
+	to, err := stringField(em, "to", path)
+	if err != nil {
+		return nil, err
+	}
+	label, err := stringField(em, "label", path)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := arrayField(em, "lseqposition", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(positions) > MaxLSEQDepth {
+		return nil, fmt.Errorf("%s.lseqposition: depth %d exceeds the limit of %d", path, len(positions), MaxLSEQDepth)
+	}
+
+	edge := &EdgeCRDT{
+		From:         NodeID(from),
+		To:           NodeID(to),
+		Label:        label,
+		LSEQPosition: make([]int, 0, len(positions)),
+	}
+	for i, pos := range positions {
+		p, err := intValue(pos, fmt.Sprintf("%s.lseqposition[%d]", path, i), maxLSEQPosition)
+		if err != nil {
+			return nil, err
+		}
+		edge.LSEQPosition = append(edge.LSEQPosition, p)
+	}
+
+	return edge, nil
+}
+
+func fieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func field(m map[string]interface{}, key string, path string) (interface{}, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: missing field", fieldPath(path, key))
+	}
+	return v, nil
+}
+
+func boolField(m map[string]interface{}, key string, path string) (bool, error) {
+	v, err := field(m, key, path)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: expected bool, got %s", fieldPath(path, key), jsonType(v))
+	}
+	return b, nil
+}
+
+func stringField(m map[string]interface{}, key string, path string) (string, error) {
+	v, err := field(m, key, path)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected string, got %s", fieldPath(path, key), jsonType(v))
+	}
+	return s, nil
+}
+
+func objectField(m map[string]interface{}, key string, path string) (map[string]interface{}, error) {
+	v, err := field(m, key, path)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected object, got %s", fieldPath(path, key), jsonType(v))
+	}
+	return o, nil
+}
+
+func arrayField(m map[string]interface{}, key string, path string) ([]interface{}, error) {
+	v, err := field(m, key, path)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected array, got %s", fieldPath(path, key), jsonType(v))
+	}
+	return a, nil
+}
+
+func intValue(v interface{}, path string, max float64) (int, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s: expected number, got %s", path, jsonType(v))
+	}
+	if f != math.Trunc(f) || f < 0 || f > max {
+		return 0, fmt.Errorf("%s: expected integer between 0 and %.0f, got %v", path, max, f)
+	}
+	return int(f), nil
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func (c *TreeCRDT) loadDevices(raw map[string]interface{}) error {
+	c.DeviceLinks = nil
+	c.devices = nil
+
+	var links []*DeviceLink
+	if err := remarshal(raw["devicelinks"], &links); err != nil {
+		return fmt.Errorf("devicelinks: failed to parse device links: %w", err)
+	}
+	for i, dl := range links {
+		if dl == nil {
+			return fmt.Errorf("devicelinks[%d]: expected object, got null", i)
+		}
+		if err := c.addDeviceLink(dl); err != nil {
+			return fmt.Errorf("devicelinks[%d]: failed to load device link: %w", i, err)
 		}
-		c.ABACPolicy.tree = c // Set the tree reference for ABACPolicy
 	}
 
 	return nil
 }
 
+func (c *TreeCRDT) loadSchemas(raw map[string]interface{}) error {
+	c.Schemas = nil
+
+	var attachments []*SchemaAttachment
+	if err := remarshal(raw["schemas"], &attachments); err != nil {
+		return fmt.Errorf("schemas: failed to parse schema attachments: %w", err)
+	}
+	for i, sa := range attachments {
+		if sa == nil {
+			return fmt.Errorf("schemas[%d]: expected object, got null", i)
+		}
+		if err := c.addSchemaAttachment(sa); err != nil {
+			return fmt.Errorf("schemas[%d]: failed to load schema attachment: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *TreeCRDT) loadKeys(raw map[string]interface{}) error {
+	c.RecoveryCommitments = nil
+	c.KeyRotations = nil
+	c.keys = nil
+
+	var commitments []*RecoveryCommitment
+	if err := remarshal(raw["recoverycommitments"], &commitments); err != nil {
+		return fmt.Errorf("recoverycommitments: failed to parse recovery commitments: %w", err)
+	}
+	for i, rc := range commitments {
+		if rc == nil {
+			return fmt.Errorf("recoverycommitments[%d]: expected object, got null", i)
+		}
+		if err := c.addRecoveryCommitment(rc); err != nil {
+			return fmt.Errorf("recoverycommitments[%d]: failed to load recovery commitment: %w", i, err)
+		}
+	}
+
+	var rotations []*KeyRotation
+	if err := remarshal(raw["keyrotations"], &rotations); err != nil {
+		return fmt.Errorf("keyrotations: failed to parse key rotations: %w", err)
+	}
+	for i, kr := range rotations {
+		if kr == nil {
+			return fmt.Errorf("keyrotations[%d]: expected object, got null", i)
+		}
+		if err := c.addKeyRotation(kr); err != nil {
+			return fmt.Errorf("keyrotations[%d]: failed to load key rotation: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func remarshal(raw interface{}, v interface{}) error {
+	if raw == nil {
+		return nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func loadProposedChange(raw interface{}) (*ProposedChange, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	change := &ProposedChange{}
+	if err := json.Unmarshal(buf, change); err != nil {
+		return nil, err
+	}
+	if change.Approvals == nil {
+		change.Approvals = make(map[ClientID]string)
+	}
+	return change, nil
+}
+
 func (c *TreeCRDT) ExportJSON() ([]byte, error) {
 	exported, err := c.export()
 	if err != nil {
@@ -445,9 +956,9 @@ func (c *TreeCRDT) export() (interface{}, error) {
 	}
 }
 
+// ExportJSON exports the value of the node, see TreeCRDT.ExportPath
 func (n *NodeCRDT) ExportJSON(crdt *TreeCRDT) ([]byte, error) {
-	visited := make(map[NodeID]bool)
-	result, err := exportNodeRecursive(n, crdt, visited)
+	result, err := crdt.exportNodeOrdered(n.ID, make(map[NodeID]bool))
 	if err != nil {
 		return nil, err
 	}
@@ -455,75 +966,6 @@ func (n *NodeCRDT) ExportJSON(crdt *TreeCRDT) ([]byte, error) {
 	return json.MarshalIndent(result, "", "  ")
 }
 
-func exportNodeRecursive(node *NodeCRDT, crdt *TreeCRDT, visited map[NodeID]bool) (interface{}, error) {
-	if visited[node.ID] {
-		return nil, fmt.Errorf("cycle detected at node %s", node.ID)
-	}
-	visited[node.ID] = true
-
-	if node.IsLiteral {
-		return node.LiteralValue, nil
-	}
-
-	obj := orderedmap.New()
-
-	// Group edges by label (like keys in a JSON object)
-	edgeGroups := make(map[string][]*EdgeCRDT)
-	for _, edge := range node.Edges {
-		edgeGroups[edge.Label] = append(edgeGroups[edge.Label], edge)
-	}
-
-	for field, edges := range edgeGroups {
-		if len(edges) == 0 {
-			continue
-		}
-
-		isArray := false
-		for _, e := range edges {
-			if child, ok := crdt.Nodes[e.To]; ok && child.IsArray {
-				isArray = true
-				break
-			}
-		}
-
-		if isArray {
-			// Export as array
-			var arrayItems []interface{}
-			for _, e := range edges {
-				child, ok := crdt.Nodes[e.To]
-				fmt.Println("Exporting child node:", e.To, "IsDeleted:", child.IsDeleted)
-				if !ok {
-					return nil, fmt.Errorf("missing child node %s", e.To)
-				}
-				if !child.IsDeleted {
-					exportedChild, err := exportNodeRecursive(child, crdt, visited)
-					if err != nil {
-						return nil, err
-					}
-					arrayItems = append(arrayItems, exportedChild)
-					obj.Set(field, arrayItems)
-				}
-			}
-		} else {
-			// Export as single value
-			child, ok := crdt.Nodes[edges[0].To]
-			if !ok {
-				return nil, fmt.Errorf("missing child node %s", edges[0].To)
-			}
-			fmt.Println("Exporting child node:", edges[0].To, "IsDeleted:", child.IsDeleted)
-			if !child.IsDeleted {
-				exportedChild, err := exportNodeRecursive(child, crdt, visited)
-				if err != nil {
-					return nil, err
-				}
-				obj.Set(field, exportedChild)
-			}
-		}
-	}
-
-	return obj, nil
-}
-
 func (c *TreeCRDT) exportNodeOrdered(id NodeID, visited map[NodeID]bool) (interface{}, error) {
 	if visited[id] {
 		return nil, fmt.Errorf("cycle detected at node %s", id)
@@ -541,13 +983,13 @@ func (c *TreeCRDT) exportNodeOrdered(id NodeID, visited map[NodeID]bool) (interf
 		return nil, nil
 	}
 	if node.IsLiteral {
-		return node.LiteralValue, nil
+		return node.effectiveValue(), nil
 	}
 
 	// Array node
 	if node.IsArray {
 		sortEdgesByLSEQ(node.Edges)
-		var arrayItems []interface{}
+		arrayItems := make([]interface{}, 0)
 		for _, edge := range node.Edges {
 			childNode := c.Nodes[edge.To]
 			if !childNode.IsDeleted {
@@ -593,6 +1035,8 @@ func (c *TreeCRDT) Clone() (*TreeCRDT, error) {
 	if err := newTreeCRDT.Load(safeCopy); err != nil {
 		return nil, err
 	}
+	newTreeCRDT.sigCache = c.sigCache // Verified signatures stay valid in the clone
+	newTreeCRDT.idScheme = c.idScheme
 	return newTreeCRDT, nil
 }
 
@@ -2,6 +2,7 @@ package crdt
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -21,10 +22,27 @@ const (
 	EventMarkedDeleted
 )
 
+// EventOrigin tells whether a change was made on this replica or pulled from another one by a merge
+type EventOrigin int
+
+const (
+	OriginLocal EventOrigin = iota
+	OriginRemote
+)
+
+// NodeEvent describes a change. For literals OldValue and NewValue are the values before and after the
+// change, decrypted if the tree can read them, for edge changes they are nil. Owner is the writer, the
+// proposer for approved changes, and Clock the clock of the node after the change. For edge changes the
+// node is the parent.
 type NodeEvent struct {
-	NodeID NodeID
-	Path   string
-	Type   NodeEventType
+	NodeID   NodeID
+	Path     string
+	Type     NodeEventType
+	OldValue interface{}
+	NewValue interface{}
+	Owner    ClientID
+	Clock    VectorClock
+	Origin   EventOrigin
 }
 
 func (c *TreeCRDT) Subscribe(path string, ch chan NodeEvent) {
@@ -49,64 +67,90 @@ func (c *TreeCRDT) computePath(nodeID NodeID) (string, error) {
 	var pathParts []string
 	current := node
 	for current != nil && !current.IsRoot {
-		parentID := current.ParentID
-		parent, exists := c.Nodes[parentID]
+		edge, ok := c.parentEdge(current.ID)
+		if !ok {
+			return "", fmt.Errorf("Could not compute path part for node %s", current.ID)
+		}
+		parent, exists := c.Nodes[edge.From]
 		if !exists {
-			return "", fmt.Errorf("Parent %s not found for node %s", parentID, current.ID)
+			return "", fmt.Errorf("Parent %s not found for node %s", edge.From, current.ID)
 		}
 
-		found := false
-		for _, edge := range parent.Edges {
-			if edge.To == current.ID {
-				if parent.IsArray {
-					// Array → index
-					index := -1
-					for i, e := range parent.Edges {
-						if e.To == current.ID {
-							index = i
-							break
-						}
-					}
-					if index >= 0 {
-						pathParts = append([]string{fmt.Sprintf("%d", index)}, pathParts...)
-					}
-				} else {
-					// Map → label
-					if len(edge.Label) > 0 {
-						pathParts = append([]string{edge.Label}, pathParts...)
-					}
+		if parent.IsArray {
+			// Array → index
+			for i, e := range parent.Edges {
+				if e == edge {
+					pathParts = append(pathParts, fmt.Sprintf("%d", i))
+					break
 				}
-				found = true
-				break
 			}
-		}
-
-		if !found {
-			return "", fmt.Errorf("Could not compute path part for node %s", current.ID)
+		} else if len(edge.Label) > 0 {
+			// Map → label
+			pathParts = append(pathParts, edge.Label)
 		}
 
 		current = parent
 	}
 
+	// The parts were collected from the node up to the root
+	for i, j := 0, len(pathParts)-1; i < j; i, j = i+1, j-1 {
+		pathParts[i], pathParts[j] = pathParts[j], pathParts[i]
+	}
+
 	path := "/" + strings.Join(pathParts, "/")
 	return path, nil
 }
 
 func (c *TreeCRDT) notifySubscribers(nodeID NodeID, eventType NodeEventType) {
-	nodePath, err := c.computePath(nodeID)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"NodeID": nodeID,
-			"Error":  err,
-		}).Error("Failed to compute path for notifySubscribers")
+	c.notify(NodeEvent{NodeID: nodeID, Type: eventType})
+}
+
+// notifyValueChange notifies the subscribers that the value of a literal changed, the values are the
+// effective values before and after the change and are only decrypted if someone is subscribed
+func (c *TreeCRDT) notifyValueChange(nodeID NodeID, oldValue interface{}, newValue interface{}) {
+	c.notify(NodeEvent{NodeID: nodeID, Type: EventUpdated, OldValue: oldValue, NewValue: newValue})
+}
+
+func (c *TreeCRDT) notify(evt NodeEvent) {
+	nodeID := evt.NodeID
+	eventType := evt.Type
+	if eventType == EventUpdated {
+		c.valueIndexNode(nodeID) // Edge changes are followed by the edge index
+	}
+
+	// Merges notify their changes once they have committed
+	if len(c.subscribers) == 0 || c.muteEvents {
 		return
 	}
+	c.deliver(evt)
+}
 
-	evt := NodeEvent{
-		NodeID: nodeID,
-		Path:   nodePath,
-		Type:   eventType,
+// deliver sends an event to the subscribers of its path, the path is computed if it is not set
+func (c *TreeCRDT) deliver(evt NodeEvent) {
+	nodeID := evt.NodeID
+	eventType := evt.Type
+	if evt.Path == "" {
+		nodePath, err := c.computePath(nodeID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"NodeID": nodeID,
+				"Error":  err,
+			}).Error("Failed to compute path for notifySubscribers")
+			return
+		}
+		evt.Path = nodePath
+	}
+	nodePath := evt.Path
+	if node, ok := c.Nodes[nodeID]; ok {
+		if evt.Owner == "" {
+			evt.Owner = node.Owner
+		}
+		if evt.Clock == nil {
+			evt.Clock = copyClock(node.Clock)
+		}
 	}
+	evt.OldValue = c.eventValue(evt.OldValue)
+	evt.NewValue = c.eventValue(evt.NewValue)
 
 	for _, sub := range c.subscribers {
 		if sub.path == nodePath || strings.HasPrefix(nodePath, sub.path) {
@@ -127,3 +171,96 @@ func (c *TreeCRDT) notifySubscribers(nodeID NodeID, eventType NodeEventType) {
 		}
 	}
 }
+
+// eventValue decrypts a literal for an event, values the tree cannot read are left out
+func (c *TreeCRDT) eventValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	decrypted, err := c.decryptLiteral(value)
+	if err != nil {
+		return nil
+	}
+	return decrypted
+}
+
+// snapshotEntry is a visible value of the document before a merge
+type snapshotEntry struct {
+	path   string
+	parent NodeID
+	kind   string
+	value  interface{}
+}
+
+type eventSnapshot struct {
+	entries map[NodeID]*snapshotEntry
+	order   []NodeID // Document order
+}
+
+// eventSnapshot records the visible values of the document, so the changes of a merge can be notified once
+// it has committed. Without subscribers nothing is recorded.
+func (c *TreeCRDT) eventSnapshot() *eventSnapshot {
+	if len(c.subscribers) == 0 {
+		return nil
+	}
+	snapshot := &eventSnapshot{entries: make(map[NodeID]*snapshotEntry)}
+	err := c.walkDocument(func(tokens []string, node *NodeCRDT, parent NodeID) {
+		entry := &snapshotEntry{path: "/" + strings.Join(tokens, "/"), parent: parent, kind: nodeKind(node)}
+		if node.IsLiteral {
+			entry.value = node.effectiveValue()
+		}
+		snapshot.entries[node.ID] = entry
+		snapshot.order = append(snapshot.order, node.ID)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"Error": err}).Error("Failed to record document for merge events")
+	}
+	return snapshot
+}
+
+// notifyMerged compares the document with the snapshot taken before a merge and sends one remote event per
+// change: the top of every removed or added subtree, and every literal whose value changed
+func (c *TreeCRDT) notifyMerged(before *eventSnapshot) {
+	if before == nil {
+		return
+	}
+	after := c.eventSnapshot()
+	if after == nil {
+		return
+	}
+
+	for _, id := range before.order {
+		entry := before.entries[id]
+		if _, ok := after.entries[id]; ok {
+			continue
+		}
+		if _, ok := before.entries[entry.parent]; ok {
+			if _, ok := after.entries[entry.parent]; !ok {
+				continue // Part of a removed subtree
+			}
+		}
+		evt := NodeEvent{NodeID: id, Path: entry.path, Type: EventRemoved, OldValue: entry.value, Origin: OriginRemote}
+		if node, ok := c.Nodes[id]; !ok || !node.IsDeleted {
+			// Removed by an edge change, the writer is the one of the parent
+			if parent, ok := c.Nodes[entry.parent]; ok {
+				evt.Owner = parent.Owner
+				evt.Clock = copyClock(parent.Clock)
+			}
+		}
+		c.deliver(evt)
+	}
+
+	for _, id := range after.order {
+		entry := after.entries[id]
+		old, existed := before.entries[id]
+		switch {
+		case !existed:
+			if _, ok := before.entries[entry.parent]; !ok && entry.parent != c.Root.ID {
+				continue // Part of an added subtree
+			}
+			c.deliver(NodeEvent{NodeID: id, Path: entry.path, Type: EventAdded, NewValue: entry.value, Origin: OriginRemote})
+		case old.kind != entry.kind || !reflect.DeepEqual(old.value, entry.value):
+			c.deliver(NodeEvent{NodeID: id, Path: entry.path, Type: EventUpdated, OldValue: old.value, NewValue: entry.value, Origin: OriginRemote})
+		}
+	}
+}
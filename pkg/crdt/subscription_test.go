@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/eislab-cps/synctree/internal/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -198,3 +199,157 @@ func TestSecureTreeAdapterSubscribe_Subpath_MultipleEvents(t *testing.T) {
 	event := <-events
 	assert.Equal(t, "/friends/1/name", event.Path, "Event path should match")
 }
+
+func TestSecureTreeAdapterSubscribe_EventDetails(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	identity, err := crypto.CreateIdendityFromString(prvKey)
+	assert.Nil(t, err)
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"lights": {"hall": "off"}, "temp": 20}`), prvKey)
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	events := make(chan NodeEvent, 10)
+	c1.Subscribe("/", events)
+
+	_, err = c1.SetByPath("/lights/hall", "on", prvKey)
+	assert.Nil(t, err)
+
+	event := <-events
+	assert.Equal(t, "/lights/hall", event.Path)
+	assert.Equal(t, EventUpdated, event.Type)
+	assert.Equal(t, "off", event.OldValue)
+	assert.Equal(t, "on", event.NewValue)
+	assert.Equal(t, ClientID(identity.ID()), event.Owner)
+	assert.Equal(t, OriginLocal, event.Origin)
+	node, err := c1.GetNodeByPath("/lights/hall")
+	assert.Nil(t, err)
+	assert.Equal(t, node.(*AdapterSecureNodeCRDT).nodeCrdt.Clock, event.Clock, "The event should carry the new clock")
+
+	_, err = c2.SetByPath("/temp", 22, prvKey)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Merge(c2, prvKey))
+
+	event = <-events
+	assert.Equal(t, "/temp", event.Path)
+	assert.Equal(t, float64(20), event.OldValue)
+	assert.Equal(t, float64(22), event.NewValue)
+	assert.Equal(t, OriginRemote, event.Origin, "Changes pulled by a merge should be marked as remote")
+
+	_, err = c1.SetByPath("/temp", 23, prvKey)
+	assert.Nil(t, err)
+	event = <-events
+	assert.Equal(t, OriginLocal, event.Origin)
+}
+
+func drainEvents(events chan NodeEvent) []NodeEvent {
+	var drained []NodeEvent
+	for {
+		select {
+		case event := <-events:
+			drained = append(drained, event)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestSecureTreeAdapterSubscribe_MergeEvents(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	c1, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+	_, err = c1.ImportJSON([]byte(`{"lights": {"hall": "off", "porch": "on"}, "scenes": ["day"], "temp": 20}`), prvKey)
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	events := make(chan NodeEvent, 100)
+	c1.Subscribe("/lights", events)
+
+	_, err = c2.SetByPath("/lights/kitchen", map[string]interface{}{"state": "on", "level": 80}, prvKey)
+	assert.Nil(t, err)
+	_, err = c2.SetByPath("/lights/hall", "on", prvKey)
+	assert.Nil(t, err)
+	_, err = c2.SetByPath("/temp", 22, prvKey)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c1.Merge(c2, prvKey))
+
+	received := drainEvents(events)
+	byPath := make(map[string]NodeEvent)
+	for _, event := range received {
+		assert.Equal(t, OriginRemote, event.Origin, "Merge events should be marked as remote")
+		_, duplicate := byPath[event.Path]
+		assert.False(t, duplicate, "Every change should be notified once: %s", event.Path)
+		byPath[event.Path] = event
+	}
+	assert.Equal(t, 2, len(received), "Only the changes under /lights should be notified")
+
+	assert.Equal(t, EventAdded, byPath["/lights/kitchen"].Type, "New subtrees should be notified once at their top")
+	assert.Equal(t, EventUpdated, byPath["/lights/hall"].Type)
+	assert.Equal(t, "off", byPath["/lights/hall"].OldValue)
+	assert.Equal(t, "on", byPath["/lights/hall"].NewValue)
+
+	t.Run("Array items", func(t *testing.T) {
+		scenes := make(chan NodeEvent, 10)
+		c1.Subscribe("/scenes", scenes)
+		_, err := c2.InsertByPath("/scenes/-", "night", prvKey)
+		assert.Nil(t, err)
+		assert.Nil(t, c1.Merge(c2, prvKey))
+
+		received := drainEvents(scenes)
+		assert.Equal(t, 1, len(received))
+		assert.Equal(t, "/scenes/1", received[0].Path)
+		assert.Equal(t, EventAdded, received[0].Type)
+		assert.Equal(t, "night", received[0].NewValue)
+	})
+
+	t.Run("Merging the same state again is silent", func(t *testing.T) {
+		drainEvents(events)
+		assert.Nil(t, c1.Merge(c2, prvKey))
+		assert.Empty(t, drainEvents(events))
+	})
+
+	t.Run("Failed merges are silent", func(t *testing.T) {
+		_, err := c2.SetByPath("/lights/hall", 1, prvKey)
+		assert.Nil(t, err)
+		err = c1.AttachSchema("/lights", []byte(`{"additionalProperties": {"type": ["string", "object"]}}`), prvKey)
+		assert.Nil(t, err)
+		drainEvents(events)
+
+		assert.NotNil(t, c1.Merge(c2, prvKey))
+		assert.Empty(t, drainEvents(events), "Rejected merges should not notify")
+	})
+}
+
+func TestTreeCRDTSubscribe_MergeDeletion(t *testing.T) {
+	c1 := newTreeCRDT()
+	_, err := c1.ImportJSON([]byte(`{"lights": {"hall": "off", "porch": "on"}}`), "client1")
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+
+	events := make(chan NodeEvent, 10)
+	c1.Subscribe("/lights", events)
+
+	porch, err := c2.GetNodeByPath("/lights/porch")
+	assert.Nil(t, err)
+	assert.Nil(t, porch.MarkDeleted("client2"))
+
+	assert.Nil(t, c1.Merge(c2))
+	exported, err := c1.ExportJSON()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(exported), "porch", "The deletion should be merged")
+
+	received := drainEvents(events)
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, "/lights/porch", received[0].Path)
+	assert.Equal(t, EventRemoved, received[0].Type)
+	assert.Equal(t, "on", received[0].OldValue)
+	assert.Equal(t, ClientID("client2"), received[0].Owner)
+	assert.Equal(t, OriginRemote, received[0].Origin)
+}
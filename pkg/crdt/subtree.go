@@ -0,0 +1,251 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportPath exports the value at the JSON pointer path, like ExportJSON exports the whole document.
+// Array indexes count the visible items in LSEQ order, "" and "/" export the whole document.
+func (c *TreeCRDT) ExportPath(path string) ([]byte, error) {
+	chain, err := c.pointerChain(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return c.ExportJSON()
+	}
+
+	exported, err := c.exportNodeOrdered(chain[len(chain)-1].ID, make(map[NodeID]bool))
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(exported, "", "  ")
+}
+
+// ExtractSubtree returns a standalone copy of the tree with only the value at the JSON pointer path, e.g.
+// to hand a slice of the tree to a partner. The ancestors of the value are kept with a single edge each, so
+// the copy can be merged with the full tree. Map keys on the path are unchanged, but an array ancestor only
+// keeps the item on the path, which is then at index 0 (/items/3/name becomes /items/0/name). Signatures do
+// not cover edges and stay valid.
+//
+// The ABAC policy only keeps the rules, approval rules and pinned values of the extracted nodes and is
+// re-signed with the key of the owner, so the partner does not learn the grants of the rest of the tree.
+// Key rotations, recovery commitments and device links are only kept for the identities that appear in the
+// copy, and schemas only if they are attached at or below the path and the path is unchanged.
+func (c *TreeCRDT) ExtractSubtree(path string, identity *crypto.Idendity) (*TreeCRDT, error) {
+	if path == "/" {
+		path = ""
+	}
+	chain, err := c.pointerChain(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	extracted, err := c.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to clone tree: %w", err)
+	}
+
+	// The path is unchanged unless an array ancestor moves the item on the path to index 0
+	samePath := true
+	for i := 0; i < len(chain)-1; i++ {
+		if chain[i].IsArray && tokens[i] != "0" {
+			samePath = false
+		}
+	}
+
+	// Cut every edge that leaves the path from the root to the value
+	chain = append([]*NodeCRDT{c.Root}, chain...)
+	for i := 0; i < len(chain)-1; i++ {
+		ancestor := extracted.Nodes[chain[i].ID]
+		for _, edge := range ancestor.Edges {
+			if edge.To == chain[i+1].ID {
+				ancestor.Edges = []*EdgeCRDT{edge}
+				break
+			}
+		}
+		ancestor.invalidateHash()
+	}
+
+	reachable := make(map[NodeID]bool)
+	stack := []NodeID{extracted.Root.ID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node, ok := extracted.Nodes[id]
+		if !ok || reachable[id] {
+			continue
+		}
+		reachable[id] = true
+		for _, edge := range node.Edges {
+			stack = append(stack, edge.To)
+		}
+	}
+	for id := range extracted.Nodes {
+		if !reachable[id] {
+			delete(extracted.Nodes, id)
+		}
+	}
+	extracted.invalidateIndex()
+
+	if err := extracted.pruneExtracted(c, path, samePath, identity); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"Path": path, "Nodes": len(extracted.Nodes)}).Debug("Subtree extracted")
+
+	return extracted, nil
+}
+
+// pruneExtracted drops the parts of the policy and the key records that do not apply to the extracted
+// nodes, and re-signs the policy
+func (c *TreeCRDT) pruneExtracted(original *TreeCRDT, path string, samePath bool, identity *crypto.Idendity) error {
+	ids := make(map[string]bool)
+	add := func(id string) {
+		for _, key := range original.keyChain(id) {
+			ids[key] = true
+		}
+	}
+	deviceMasters := make(map[string]bool)
+
+	policy := c.ABACPolicy
+	if policy != nil {
+		for id, actions := range policy.Rules {
+			for action, nodes := range actions {
+				for nodeID, rule := range nodes {
+					if _, ok := c.Nodes[nodeID]; !ok && nodeID != "*" {
+						delete(nodes, nodeID)
+					} else if rule.Devices {
+						deviceMasters[id] = true
+					}
+				}
+				if len(nodes) == 0 {
+					delete(actions, action)
+				}
+			}
+			if len(actions) == 0 {
+				delete(policy.Rules, id)
+			} else {
+				add(id)
+			}
+		}
+		for nodeID, rule := range policy.Approvals {
+			if _, ok := c.Nodes[nodeID]; !ok {
+				delete(policy.Approvals, nodeID)
+				continue
+			}
+			for _, approver := range rule.Approvers {
+				add(approver)
+			}
+		}
+		for nodeID := range policy.Pinned {
+			if _, ok := c.Nodes[nodeID]; !ok {
+				delete(policy.Pinned, nodeID)
+			}
+		}
+		add(policy.OwnerID)
+	}
+
+	for _, node := range c.Nodes {
+		add(string(node.Owner))
+		for clientID := range node.Clock {
+			add(string(clientID))
+		}
+	}
+
+	schemas := make([]*SchemaAttachment, 0)
+	for _, sa := range c.Schemas {
+		if samePath && (path == "" || sa.Path == path || strings.HasPrefix(sa.Path, path+"/")) {
+			schemas = append(schemas, sa)
+			add(sa.SignerID)
+		}
+	}
+	c.Schemas = schemas
+
+	links := make([]*DeviceLink, 0)
+	for _, dl := range c.DeviceLinks {
+		if ids[dl.DeviceID] || deviceMasters[dl.MasterID] {
+			links = append(links, dl)
+		}
+	}
+	for _, dl := range links {
+		add(dl.MasterID)
+	}
+	c.DeviceLinks = links
+	c.indexDevices()
+
+	rotations := make([]*KeyRotation, 0)
+	for _, kr := range c.KeyRotations {
+		if ids[kr.OldID] {
+			rotations = append(rotations, kr)
+		}
+	}
+	c.KeyRotations = rotations
+	commitments := make([]*RecoveryCommitment, 0)
+	for _, rc := range c.RecoveryCommitments {
+		if ids[rc.ID] {
+			commitments = append(commitments, rc)
+		}
+	}
+	c.RecoveryCommitments = commitments
+	c.indexKeys()
+
+	if policy == nil {
+		return nil
+	}
+	policy.identity = identity
+	if err := policy.Sign(); err != nil {
+		return err
+	}
+	if _, err := policy.Verify(); err != nil {
+		return fmt.Errorf("Failed to sign the extracted ABAC policy, %s is not the owner: %w", identity.ID(), err)
+	}
+	return nil
+}
+
+// pointerChain resolves a JSON pointer and returns the nodes from the top level value down to the target,
+// an empty chain for the whole document
+func (c *TreeCRDT) pointerChain(path string) ([]*NodeCRDT, error) {
+	if path == "/" {
+		path = ""
+	}
+	tokens, err := splitJSONPointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return []*NodeCRDT{}, nil
+	}
+
+	p := &jsonPatcher{tree: c}
+	node, err := p.document()
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("document is empty")
+	}
+
+	chain := []*NodeCRDT{node}
+	for _, token := range tokens {
+		child, ok, err := p.child(node, token)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("path not found at '%s'", token)
+		}
+		node = child
+		chain = append(chain, node)
+	}
+	return chain, nil
+}
@@ -0,0 +1,155 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportPath(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"a": {"b": [1, 2, 3]}, "c": "x", "d/e": {"f~g": true}}`), "client1")
+	assert.Nil(t, err)
+
+	exported, err := c.ExportPath("/a/b")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[1, 2, 3]`, string(exported))
+
+	exported, err = c.ExportPath("/d~1e/f~0g")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `true`, string(exported))
+
+	exported, err = c.ExportPath("/")
+	assert.Nil(t, err)
+	full, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(full), string(exported))
+
+	// Indexes count visible items only
+	identity, err := crypto.CreateIdendityFromString("d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6")
+	assert.Nil(t, err)
+	err = c.ApplyJSONPatch([]byte(`[{"op": "remove", "path": "/a/b/0"}]`), identity)
+	assert.Nil(t, err)
+
+	exported, err = c.ExportPath("/a/b/0")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `2`, string(exported))
+
+	_, err = c.ExportPath("/missing")
+	assert.NotNil(t, err)
+	_, err = c.ExportPath("/a/b/5")
+	assert.NotNil(t, err)
+}
+
+func TestNodeExportJSON(t *testing.T) {
+	c := newTreeCRDT()
+	_, err := c.ImportJSON([]byte(`{"list": ["a", "b", "c"], "obj": {"k": "v"}}`), "client1")
+	assert.Nil(t, err)
+
+	node, err := c.GetNodeByPath("/list")
+	assert.Nil(t, err)
+	exported, err := node.ExportJSON(c)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `["a", "b", "c"]`, string(exported), "Arrays should be exported in LSEQ order")
+
+	node, err = c.GetNodeByPath("/obj")
+	assert.Nil(t, err)
+	exported, err = node.ExportJSON(c)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"k": "v"}`, string(exported))
+}
+
+func TestExtractSubtree(t *testing.T) {
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey1)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"site": {"name": "plant", "devices": [{"id": 1}, {"id": 2}]}, "billing": {"plan": "gold"}}`), prvKey1)
+	assert.Nil(t, err)
+
+	// A partner is allowed to modify the site and does so
+	site, err := c.GetNodeByPath("/site")
+	assert.Nil(t, err)
+	err = c.ABAC().Allow(identity2.ID(), ActionModify, site.ID(), true)
+	assert.Nil(t, err)
+	err = c.ApplyJSONPatch([]byte(`[{"op": "replace", "path": "/site/name", "value": "plant 2"}]`), prvKey2)
+	assert.Nil(t, err)
+
+	// Grants, keys and schemas of the rest of the tree
+	billingUser, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	rotatedUser, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	device, err := crypto.CreateIdendity()
+	assert.Nil(t, err)
+	billing, err := c.GetNodeByPath("/billing")
+	assert.Nil(t, err)
+	err = c.ABAC().AllowWithDevices(billingUser.ID(), ActionModify, billing.ID(), true)
+	assert.Nil(t, err)
+	err = c.LinkDevice(billingUser.PrivateKeyAsHex(), device.PrivateKeyAsHex(), 0)
+	assert.Nil(t, err)
+	err = c.RotateKey(billingUser.PrivateKeyAsHex(), rotatedUser.PrivateKeyAsHex())
+	assert.Nil(t, err)
+	err = c.AttachSchema("/billing", []byte(`{"type": "object"}`), prvKey1)
+	assert.Nil(t, err)
+	err = c.AttachSchema("/site", []byte(`{"type": "object"}`), prvKey1)
+	assert.Nil(t, err)
+
+	before, err := c.ExportJSON()
+	assert.Nil(t, err)
+
+	_, err = c.ExtractSubtree("/site", prvKey2)
+	assert.NotNil(t, err, "Only the owner can sign the policy of the extracted tree")
+
+	extracted, err := c.ExtractSubtree("/site", prvKey1)
+	assert.Nil(t, err)
+	assert.Nil(t, extracted.VerifyTree(), "The extracted tree should still verify")
+
+	// Only the parts that apply to the extracted nodes are kept
+	extractedTree := extracted.(*AdapterSecureTreeCRDT).treeCrdt
+	assert.True(t, extracted.ABAC().IsAllowed(identity2.ID(), ActionModify, site.ID()))
+	assert.NotContains(t, extractedTree.ABACPolicy.Rules, billingUser.ID(), "Rules of other nodes should be dropped")
+	assert.NotContains(t, extractedTree.ABACPolicy.Rules, rotatedUser.ID())
+	assert.Empty(t, extractedTree.KeyRotations)
+	assert.Empty(t, extractedTree.DeviceLinks)
+	assert.Len(t, extractedTree.Schemas, 1)
+	assert.Equal(t, "/site", extractedTree.Schemas[0].Path)
+
+	exported, err := extracted.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"site": {"name": "plant 2", "devices": [{"id": 1}, {"id": 2}]}}`, string(exported))
+
+	_, err = extracted.GetNodeByPath("/billing")
+	assert.NotNil(t, err, "Values outside the subtree should not be extracted")
+
+	// Array ancestors only keep the item on the path
+	siteDevice, err := c.ExtractSubtree("/site/devices/1", prvKey1)
+	assert.Nil(t, err)
+	exported, err = siteDevice.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"site": {"devices": [{"id": 2}]}}`, string(exported))
+	assert.Nil(t, siteDevice.VerifyTree())
+	assert.Empty(t, siteDevice.(*AdapterSecureTreeCRDT).treeCrdt.Schemas, "Schemas should be dropped when the path changes")
+
+	after, err := c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(before), string(after), "Extracting should not change the tree")
+	assert.Nil(t, c.VerifyTree())
+
+	// The partner changes the slice and it is merged back into the full tree
+	err = extracted.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/site/devices/-", "value": {"id": 3}}]`), prvKey2)
+	assert.Nil(t, err)
+	err = c.Merge(extracted, prvKey1)
+	assert.Nil(t, err)
+
+	exported, err = c.ExportJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"site": {"name": "plant 2", "devices": [{"id": 1}, {"id": 2}, {"id": 3}]}, "billing": {"plan": "gold"}}`, string(exported))
+	assert.Nil(t, c.VerifyTree())
+	assert.True(t, c.ABAC().IsAllowed(rotatedUser.ID(), ActionModify, billing.ID()), "The pruned policy should not replace the full policy")
+	assert.True(t, c.ABAC().IsAllowed(device.ID(), ActionModify, billing.ID()))
+}
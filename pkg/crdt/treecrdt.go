@@ -7,6 +7,7 @@ import (
 
 	"github.com/eislab-cps/synctree/internal/crypto"
 	"github.com/eislab-cps/synctree/pkg/random"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,20 +23,24 @@ const (
 
 type NodeCRDT struct {
 	tree         *TreeCRDT
-	ID           NodeID      `json:"id"`
-	ParentID     NodeID      `json:"parentid"`
-	Edges        []*EdgeCRDT `json:"edges"`
-	Clock        VectorClock `json:"clock"`
-	Owner        ClientID    `json:"owner"`
-	IsRoot       bool        `json:"isroot"`
-	IsMap        bool        `json:"ismap"`
-	IsArray      bool        `json:"isarray"`
-	IsPromoted   bool        `json:"ispromoted"`
-	IsLiteral    bool        `json:"isliteral"`
-	LiteralValue interface{} `json:"litteralValue"`
-	Nounce       string      `json:"nounce"`
-	Signature    string      `json:"signature"`
-	IsDeleted    bool        `json:"deleted"`
+	ID           NodeID          `json:"id"`
+	ParentID     NodeID          `json:"parentid"`
+	Edges        []*EdgeCRDT     `json:"edges"`
+	Clock        VectorClock     `json:"clock"`
+	Owner        ClientID        `json:"owner"`
+	IsRoot       bool            `json:"isroot"`
+	IsMap        bool            `json:"ismap"`
+	IsArray      bool            `json:"isarray"`
+	IsPromoted   bool            `json:"ispromoted"`
+	IsLiteral    bool            `json:"isliteral"`
+	LiteralValue interface{}     `json:"litteralValue"`
+	Nounce       string          `json:"nounce"`
+	Signature    string          `json:"signature"`
+	IsDeleted    bool            `json:"deleted"`
+	Approved     *ProposedChange `json:"approved,omitempty"`
+	Pending      *ProposedChange `json:"pending,omitempty"`
+	hash         []byte
+	edgesSorted  bool // Edges are known to be in LSEQ order
 }
 
 type EdgeCRDT struct {
@@ -46,13 +51,37 @@ type EdgeCRDT struct {
 }
 
 type TreeCRDT struct {
-	Root        *NodeCRDT            `json:"root"`
-	Nodes       map[NodeID]*NodeCRDT `json:"nodes"`
-	ABACPolicy  *ABACPolicy          `json:"abac"`
-	Secure      bool                 `json:"secure"`
-	subscribers []subscriber
+	Root                *NodeCRDT             `json:"root"`
+	Nodes               map[NodeID]*NodeCRDT  `json:"nodes"`
+	ABACPolicy          *ABACPolicy           `json:"abac"`
+	Secure              bool                  `json:"secure"`
+	KeyRotations        []*KeyRotation        `json:"keyrotations,omitempty"`
+	RecoveryCommitments []*RecoveryCommitment `json:"recoverycommitments,omitempty"`
+	DeviceLinks         []*DeviceLink         `json:"devicelinks,omitempty"`
+	Schemas             []*SchemaAttachment   `json:"schemas,omitempty"`
+	subscribers         []subscriber
+	sigCache            *signatureCache
+	keys                *keyIndex
+	devices             map[string]string // device ID -> master ID
+	idScheme            IDScheme
+	edgeIndex           *edgeIndex
+	valueIndexes        map[string]*valueIndex
+	muteEvents          bool                          // Set during merges, their changes are notified once they have committed
+	schemaCache         map[string]*jsonschema.Schema // Attachment signature -> compiled schema
+	schemaMergeMode     SchemaMergeMode
+	quarantine          []*QuarantinedMerge
 }
 
+// IDScheme selects how imports assign node IDs. With a derived scheme replicas that import the same JSON
+// independently create the same nodes, so their trees merge without duplicating the document.
+type IDScheme int
+
+const (
+	IDSchemeRandom      IDScheme = iota // Random IDs, the default
+	IDSchemePath                        // Derived from the parent ID and the key or array index
+	IDSchemeCreatorPath                 // Derived from the parent ID, the key or array index and the creator
+)
+
 func newTreeCRDT() *TreeCRDT {
 	rootID := "root"
 	root := &NodeCRDT{
@@ -68,6 +97,7 @@ func newTreeCRDT() *TreeCRDT {
 	root.tree = c
 	c.ABACPolicy = nil
 	c.Secure = false
+	c.sigCache = newSignatureCache()
 
 	return c
 }
@@ -128,6 +158,29 @@ func generateRandomNodeID(label string) NodeID {
 	return NodeID(id)
 }
 
+func (c *TreeCRDT) SetIDScheme(scheme IDScheme) {
+	c.idScheme = scheme
+}
+
+// importNodeID returns the ID of a node created by an import, a derived ID must not exist in the tree
+func (c *TreeCRDT) importNodeID(label string, parentID NodeID, key string, clientID ClientID) (NodeID, error) {
+	var seed string
+	switch c.idScheme {
+	case IDSchemePath:
+		seed = fmt.Sprintf("%s\x00%s", parentID, key)
+	case IDSchemeCreatorPath:
+		seed = fmt.Sprintf("%s\x00%s\x00%s", parentID, key, clientID)
+	default:
+		return generateRandomNodeID(label), nil
+	}
+
+	id := NodeID(label + "-" + crypto.GenerateHashFromString(seed).String())
+	if _, exists := c.Nodes[id]; exists {
+		return "", fmt.Errorf("node %s already exists, use ReconcileJSON to update an imported document", id)
+	}
+	return id, nil
+}
+
 // This functions only appends a new node to the tree, no need for conflict resolution
 func (n *NodeCRDT) CreateMapNode(clientID ClientID) (*NodeCRDT, error) {
 	mapNode := n.tree.CreateNode("map", Map, clientID)
@@ -251,9 +304,12 @@ func (c *TreeCRDT) addEdgeWithVersion(from, to NodeID, label string, clientID Cl
 	if clocksEqual(winningClock, newClock) && (clientID == winningOwner) {
 		edge := &EdgeCRDT{From: from, To: to, Label: label, LSEQPosition: make([]int, 0)}
 		fromNode.Edges = append(fromNode.Edges, edge)
+		fromNode.edgesSorted = false
+		c.indexEdge(edge)
 		fromNode.Clock = newClock
 		fromNode.Owner = clientID
 		toNode.ParentID = from
+		fromNode.invalidateHash()
 
 		c.notifySubscribers(fromNode.ID, EventAdded)
 
@@ -275,7 +331,7 @@ func (c *TreeCRDT) AddEdge(from, to NodeID, label string, clientID ClientID) err
 		return errors.New("Cannot add edge, from node not found: " + string(from))
 	}
 
-	latestVersion := fromNode.Clock[clientID]
+	latestVersion := c.clientVersion(fromNode.Clock, clientID)
 	newVersion := latestVersion + 1
 
 	return c.addEdgeWithVersion(from, to, label, clientID, newVersion)
@@ -305,7 +361,7 @@ func (c *TreeCRDT) appendEdge(from, to NodeID, label string, clientID ClientID,
 		lastSibling = ""
 	}
 
-	newVersion := fromNode.Clock[clientID] + 1
+	newVersion := c.clientVersion(fromNode.Clock, clientID) + 1
 	return c.insertEdgeWithVersion(from, to, label, lastSibling, false, clientID, newVersion)
 }
 
@@ -329,7 +385,7 @@ func (c *TreeCRDT) PrependEdge(from, to NodeID, label string, clientID ClientID)
 		firstSibling = ""
 	}
 
-	newVersion := node.Clock[clientID] + 1
+	newVersion := c.clientVersion(node.Clock, clientID) + 1
 	return c.insertEdgeWithVersion(from, to, label, firstSibling, true /* left */, clientID, newVersion)
 }
 
@@ -342,7 +398,7 @@ func (c *TreeCRDT) InsertEdgeLeft(from, to NodeID, label string, sibling NodeID,
 	if !ok {
 		return fmt.Errorf("InsertEdge: parent node %s not found", from)
 	}
-	latestVersion := node.Clock[clientID]
+	latestVersion := c.clientVersion(node.Clock, clientID)
 	newVersion := latestVersion + 1
 
 	return c.insertEdgeWithVersion(from, to, label, sibling, true, clientID, newVersion)
@@ -357,7 +413,7 @@ func (c *TreeCRDT) InsertEdgeRight(from, to NodeID, label string, sibling NodeID
 	if !ok {
 		return fmt.Errorf("InsertEdge: parent node %s not found", from)
 	}
-	latestVersion := node.Clock[clientID]
+	latestVersion := c.clientVersion(node.Clock, clientID)
 	newVersion := latestVersion + 1
 
 	return c.insertEdgeWithVersion(from, to, label, sibling, false, clientID, newVersion)
@@ -372,46 +428,34 @@ func (c *TreeCRDT) insertEdgeWithVersion(from, to NodeID, label string, sibling
 	newClock := copyClock(node.Clock)
 	newClock[clientID] = newVersion
 
-	// Sort edges for position lookup
-	sorted := make([]*EdgeCRDT, len(node.Edges))
-	copy(sorted, node.Edges)
-	sortEdgesByLSEQ(sorted)
+	// Edges are kept in LSEQ order, so siblings are found by binary search instead of sorting on every insert
+	if !node.edgesSorted {
+		sortEdgesByLSEQ(node.Edges)
+		node.edgesSorted = true
+	}
+	sorted := node.Edges
 
-	var leftPos, rightPos Position
-	found := false
+	// Insert at beginning unless the sibling is found
+	leftPos := Position{}
+	rightPos := Position{Base}
 
-	if sibling == "" || len(sorted) == 0 {
-		// Insert at beginning
-		leftPos = []int{}
-		rightPos = []int{Base}
-	} else {
-		for i, e := range sorted {
-			if e.To == sibling {
-				found = true
-				if left {
-					// Insert to the left of sibling
-					if i > 0 {
-						leftPos = sorted[i-1].LSEQPosition
-					} else {
-						leftPos = []int{}
-					}
-					rightPos = e.LSEQPosition
-				} else {
-					// Insert to the right of sibling
-					leftPos = e.LSEQPosition
-					if i+1 < len(sorted) {
-						rightPos = sorted[i+1].LSEQPosition
-					} else {
-						rightPos = []int{Base}
-					}
+	if e, ok := c.parentEdge(sibling); ok && sibling != "" && e.From == from {
+		i := sort.Search(len(sorted), func(i int) bool { return !lessEdgeLSEQ(sorted[i], e) })
+		if i < len(sorted) && sorted[i] == e {
+			if left {
+				// Insert to the left of sibling
+				if i > 0 {
+					leftPos = sorted[i-1].LSEQPosition
+				}
+				rightPos = e.LSEQPosition
+			} else {
+				// Insert to the right of sibling
+				leftPos = e.LSEQPosition
+				if i+1 < len(sorted) {
+					rightPos = sorted[i+1].LSEQPosition
 				}
-				break
 			}
 		}
-		if !found {
-			leftPos = []int{}
-			rightPos = []int{Base}
-		}
 	}
 
 	newPos := generatePositionBetweenLSEQ(leftPos, rightPos)
@@ -422,8 +466,11 @@ func (c *TreeCRDT) insertEdgeWithVersion(from, to NodeID, label string, sibling
 		Label:        label,
 		LSEQPosition: newPos,
 	}
-	node.Edges = append(node.Edges, edge)
-	sortEdgesByLSEQ(node.Edges)
+	i := sort.Search(len(node.Edges), func(i int) bool { return lessEdgeLSEQ(edge, node.Edges[i]) })
+	node.Edges = append(node.Edges, nil)
+	copy(node.Edges[i+1:], node.Edges[i:])
+	node.Edges[i] = edge
+	c.indexEdge(edge)
 
 	node.Clock = newClock
 	node.Owner = clientID
@@ -433,6 +480,7 @@ func (c *TreeCRDT) insertEdgeWithVersion(from, to NodeID, label string, sibling
 		return fmt.Errorf("Cannot add edge, child node %s not found", to)
 	}
 	child.ParentID = from
+	node.invalidateHash()
 
 	c.notifySubscribers(from, EventAdded)
 
@@ -481,9 +529,9 @@ func (c *TreeCRDT) removeEdgeWithVersion(from, to NodeID, clientID ClientID, new
 	if !ok {
 		return fmt.Errorf("Cannot remove edge, from node %s not found", from)
 	}
-	toNode, ok := c.Nodes[from]
+	toNode, ok := c.Nodes[to]
 	if !ok {
-		return fmt.Errorf("Cannot remove edge, to node %s not found", from)
+		return fmt.Errorf("Cannot remove edge, to node %s not found", to)
 	}
 
 	// Prepare the new clock
@@ -496,16 +544,23 @@ func (c *TreeCRDT) removeEdgeWithVersion(from, to NodeID, clientID ClientID, new
 	if clocksEqual(winningClock, newClock) || ignoreConflicts {
 		// New clock wins -> allow edge removal
 		newEdges := []*EdgeCRDT{}
+		removed := []*EdgeCRDT{}
 		for _, edge := range fromNode.Edges {
 			if !(edge.To == to) {
 				newEdges = append(newEdges, edge)
+			} else {
+				removed = append(removed, edge)
 			}
 		}
 		fromNode.Edges = newEdges
+		for _, edge := range removed {
+			c.unindexEdge(edge)
+		}
 		fromNode.Clock = newClock
 		fromNode.Owner = clientID
 
 		toNode.ParentID = "" // Unlink child node from parent
+		fromNode.invalidateHash()
 
 		c.notifySubscribers(fromNode.ID, EventRemoved)
 
@@ -531,7 +586,7 @@ func (c *TreeCRDT) RemoveEdge(from, to NodeID, clientID ClientID) error {
 	if !ok {
 		return fmt.Errorf("Cannot remove edge, from node %s not found", from)
 	}
-	latestVersion := fromNode.Clock[clientID]
+	latestVersion := c.clientVersion(fromNode.Clock, clientID)
 	newVersion := latestVersion + 1
 
 	return c.removeEdgeWithVersion(from, to, clientID, newVersion, false)
@@ -541,7 +596,10 @@ func (n *NodeCRDT) GetLiteral() (interface{}, error) {
 	if !n.IsLiteral {
 		return nil, fmt.Errorf("GetLiteral: node %s is not a literal", n.ID)
 	}
-	return n.LiteralValue, nil
+	if n.tree == nil {
+		return n.effectiveValue(), nil
+	}
+	return n.tree.decryptLiteral(n.effectiveValue())
 }
 
 func (n *NodeCRDT) SetLiteral(value interface{}, clientID ClientID) error {
@@ -566,10 +624,12 @@ func (n *NodeCRDT) setLiteralWithVersion(value interface{}, clientID ClientID, v
 	winningClock, winningOwner := resolveConflict(currentClock, newClock, n.Owner, clientID, false)
 
 	if clocksEqual(winningClock, newClock) && winningOwner == clientID {
+		oldValue := n.effectiveValue()
 		n.IsLiteral = true
 		n.LiteralValue = value
 		n.Clock = newClock
 		n.Owner = clientID
+		n.invalidateHash()
 		log.WithFields(log.Fields{
 			"NodeID":       n.ID,
 			"NodeClock":    currentClock,
@@ -581,7 +641,7 @@ func (n *NodeCRDT) setLiteralWithVersion(value interface{}, clientID ClientID, v
 
 		// XXX: We cannot notify subscribers if node does not have a parent, this will happen when using CreateNode
 		if n.ParentID != "" {
-			n.tree.notifySubscribers(n.ID, EventUpdated)
+			n.tree.notifyValueChange(n.ID, oldValue, n.effectiveValue())
 		} else {
 			//		panic("SetLiteral called on a node without parent, this should not happen")
 		}
@@ -621,10 +681,12 @@ func (n *NodeCRDT) markDeletedWithVersion(clientID ClientID, version int) error
 	winningClock, winningOwner := resolveConflict(currentClock, newClock, n.Owner, clientID, false)
 
 	if clocksEqual(winningClock, newClock) && winningOwner == clientID {
-		n.IsLiteral = true
+		oldValue := n.effectiveValue()
+		n.IsLiteral = !n.IsMap && !n.IsArray // Maps and arrays keep their type, e.g. when moved by a JSON patch
 		n.Clock = newClock
 		n.Owner = clientID
 		n.IsDeleted = true
+		n.invalidateHash()
 		log.WithFields(log.Fields{
 			"NodeID":               n.ID,
 			"NodeClock":            currentClock,
@@ -634,7 +696,7 @@ func (n *NodeCRDT) markDeletedWithVersion(clientID ClientID, version int) error
 			"AttemptedDeleteValue": true,
 			"ClientID":             clientID}).Debug("Set deleted flag")
 
-		n.tree.notifySubscribers(n.ID, EventUpdated)
+		n.tree.notifyValueChange(n.ID, oldValue, nil)
 	} else {
 		log.WithFields(log.Fields{
 			"NodeID":               n.ID,
@@ -664,23 +726,28 @@ func (n *NodeCRDT) markDeletedWithVersion(clientID ClientID, version int) error
 //
 // This helps keep the CRDT tree compact without risking consistency.
 func (c *TreeCRDT) Tidy() {
-	referenced := make(map[NodeID]bool)
-
-	// Mark all referenced nodes (target of edges)
-	for _, node := range c.Nodes {
-		for _, edge := range node.Edges {
-			referenced[edge.To] = true
+	// Repeat until stable, the children of a purged node become unreferenced
+	for purged := true; purged; {
+		purged = false
+		referenced := make(map[NodeID]bool)
+
+		// Mark all referenced nodes (target of edges)
+		for _, node := range c.Nodes {
+			for _, edge := range node.Edges {
+				referenced[edge.To] = true
+			}
 		}
-	}
 
-	// Always preserve the root node
-	referenced[c.Root.ID] = true
+		// Always preserve the root node
+		referenced[c.Root.ID] = true
 
-	// Now delete all nodes that are unreferenced
-	for id := range c.Nodes {
-		if !referenced[id] {
-			delete(c.Nodes, id)
-			log.WithFields(log.Fields{"NodeID": id}).Debug("Purged unreferenced node")
+		// Now delete all nodes that are unreferenced
+		for id := range c.Nodes {
+			if !referenced[id] {
+				delete(c.Nodes, id)
+				purged = true
+				log.WithFields(log.Fields{"NodeID": id}).Debug("Purged unreferenced node")
+			}
 		}
 	}
 
@@ -703,6 +770,9 @@ func (c *TreeCRDT) Tidy() {
 		node.Edges = newEdges
 	}
 
+	c.invalidateAllHashes()
+	c.invalidateIndex()
+
 	// Delete all deleted nodes
 	for id, node := range c.Nodes {
 		if node.IsDeleted {
@@ -714,7 +784,21 @@ func (c *TreeCRDT) Tidy() {
 }
 
 func (c *TreeCRDT) Merge(c2 *TreeCRDT) error {
-	return c.merge(c2, false, "")
+	// Merge into a clone first so that a failed merge leaves the tree unchanged
+	cCopy, err := c.Clone()
+	if err != nil {
+		return fmt.Errorf("Failed to clone CRDT tree for merge: %w", err)
+	}
+	if err := cCopy.merge(c2, false, ""); err != nil {
+		return err
+	}
+
+	before := c.eventSnapshot()
+	if err := c.merge(c2, false, ""); err != nil {
+		return err
+	}
+	c.notifyMerged(before)
+	return nil
 }
 
 func (c *TreeCRDT) SecureMerge(c2 *TreeCRDT, prvKey string) error {
@@ -750,6 +834,7 @@ func (c *TreeCRDT) SecureMerge(c2 *TreeCRDT, prvKey string) error {
 	if err != nil {
 		return fmt.Errorf("Failed to merge ABACPolicy in clone: %w", err)
 	}
+	c1Copy.dropUnprotectedProposals()
 
 	// Step 5: Verify merged tree + ABAC
 	err = c1Copy.VerifyTree()
@@ -760,7 +845,20 @@ func (c *TreeCRDT) SecureMerge(c2 *TreeCRDT, prvKey string) error {
 		return fmt.Errorf("Failed to verify remote CRDT tree before merge: %w", err)
 	}
 
-	// Step 6: Apply merge to live tree
+	// Step 6: Check the merged document against the attached schemas
+	proceed, err := c.checkMergeSchemas(c1Copy, c2)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("Remote CRDT tree does not match the schema")
+		return fmt.Errorf("Remote CRDT tree does not match the schema: %w", err)
+	}
+	if !proceed {
+		return nil
+	}
+
+	// Step 7: Apply merge to live tree, the subscribers are notified once it has committed
+	before := c.eventSnapshot()
 	err = c.merge(c2, true, prvKey)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -769,7 +867,7 @@ func (c *TreeCRDT) SecureMerge(c2 *TreeCRDT, prvKey string) error {
 		return fmt.Errorf("Failed to apply merge to live CRDT tree: %w", err)
 	}
 
-	// Step 7: Apply ABACPolicy merge to live tree
+	// Step 8: Apply ABACPolicy merge to live tree
 	err = c.ABACPolicy.Merge(c2.ABACPolicy)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -777,13 +875,30 @@ func (c *TreeCRDT) SecureMerge(c2 *TreeCRDT, prvKey string) error {
 		}).Error("Failed to merge ABACPolicy to live tree")
 		return fmt.Errorf("Failed to merge ABACPolicy to live tree: %w", err)
 	}
+	c.dropUnprotectedProposals()
+
+	c.notifyMerged(before)
 
 	return nil
 }
 
 func (c *TreeCRDT) merge(c2 *TreeCRDT, secure bool, prvKey string) error {
 	force := false
+
+	c.muteEvents = true
+	defer func() { c.muteEvents = false }()
+
+	if err := c.mergeKeys(c2); err != nil {
+		return fmt.Errorf("Failed to merge key rotations: %w", err)
+	}
+	if err := c.mergeDevices(c2); err != nil {
+		return fmt.Errorf("Failed to merge device links: %w", err)
+	}
+	if err := c.mergeSchemas(c2); err != nil {
+		return fmt.Errorf("Failed to merge schema attachments: %w", err)
+	}
 	promotions := make(map[NodeID]NodeID) // fromNodeID -> arrayNodeID
+	var proposals []NodeID                // Remote nodes with proposed changes, merged once the edges are in place
 
 	for id, remote := range c2.Nodes {
 		local, exists := c.Nodes[id]
@@ -815,11 +930,21 @@ func (c *TreeCRDT) merge(c2 *TreeCRDT, secure bool, prvKey string) error {
 
 		mergedClock := mergeClocks(local.Clock, remote.Clock)
 		mergedOwner := lowestClientID(local.Owner, remote.Owner)
+		localOwner, localNounce, localSignature := local.Owner, local.Nounce, local.Signature
+
+		if remote.Approved != nil || remote.Pending != nil {
+			proposals = append(proposals, id)
+		}
 
 		if remote.IsLiteral {
 			err := local.setLiteralWithVersion(remote.LiteralValue, remote.Owner, remote.Clock[remote.Owner])
-			local.Nounce = remote.Nounce
-			local.Signature = remote.Signature
+			if err == nil {
+				local.IsDeleted = remote.IsDeleted // The winning write may be a deletion
+				local.Nounce = remote.Nounce
+				local.Signature = remote.Signature
+				localOwner, localNounce, localSignature = local.Owner, local.Nounce, local.Signature
+			}
+			local.invalidateHash()
 			if err != nil {
 				log.WithFields(log.Fields{
 					"NodeID": remote.ID,
@@ -829,6 +954,7 @@ func (c *TreeCRDT) merge(c2 *TreeCRDT, secure bool, prvKey string) error {
 			}
 		}
 
+		var leftSiblings map[NodeID]NodeID // Remote array item -> item to its left
 		for _, re := range remote.Edges {
 			if _, exists := c.Nodes[re.From]; !exists {
 				c.cloneNodeFromRemote(c2, re.From)
@@ -942,20 +1068,19 @@ func (c *TreeCRDT) merge(c2 *TreeCRDT, secure bool, prvKey string) error {
 			}
 
 			if fromNode.IsArray {
-				// Sort remote parent's edges to find left sibling
-				remoteParent := c2.Nodes[re.From]
-				sortEdgesByLSEQ(remoteParent.Edges)
-
-				var siblingID NodeID
-				var sibling *NodeCRDT = nil
-
-				for i, edge := range remoteParent.Edges {
-					if edge.To == re.To && i > 0 {
-						siblingID = remoteParent.Edges[i-1].To
-						break
+				// Sort remote parent's edges once to find left siblings
+				if leftSiblings == nil {
+					remoteParent := c2.Nodes[re.From]
+					sortEdgesByLSEQ(remoteParent.Edges)
+					leftSiblings = make(map[NodeID]NodeID, len(remoteParent.Edges))
+					for i := 1; i < len(remoteParent.Edges); i++ {
+						leftSiblings[remoteParent.Edges[i].To] = remoteParent.Edges[i-1].To
 					}
 				}
 
+				siblingID := leftSiblings[re.To]
+				var sibling *NodeCRDT = nil
+
 				if siblingID != "" {
 					var exists bool
 					sibling, exists = c.Nodes[siblingID]
@@ -1034,8 +1159,28 @@ func (c *TreeCRDT) merge(c2 *TreeCRDT, secure bool, prvKey string) error {
 			}
 		}
 
+		// The owner of a literal is the writer of the winning value, a deleted map or array is owned by the
+		// identity that deleted it, other nodes get the lowest owner. The signature must always be the one
+		// made by the owner.
+		if local.IsLiteral || (local.IsDeleted && !remote.IsDeleted) {
+			mergedOwner = localOwner
+		} else if remote.IsDeleted && !local.IsDeleted {
+			local.IsDeleted = true
+			mergedOwner = remote.Owner
+			localOwner = "" // The local signature does not cover the deletion
+		}
 		local.Clock = mergedClock
 		local.Owner = mergedOwner
+		if mergedOwner == localOwner {
+			local.Nounce, local.Signature = localNounce, localSignature
+		} else if mergedOwner == remote.Owner {
+			local.Nounce, local.Signature = remote.Nounce, remote.Signature
+		}
+		local.invalidateHash()
+	}
+
+	for _, id := range proposals {
+		c.mergeProposals(c.Nodes[id], c2.Nodes[id])
 	}
 
 	c.normalize()
@@ -1067,12 +1212,8 @@ func (c *TreeCRDT) cloneNodeFromRemote(c2 *TreeCRDT, id NodeID) {
 }
 
 func (c *TreeCRDT) edgeExists(node *NodeCRDT, to NodeID) bool {
-	for _, e := range node.Edges {
-		if e.To == to {
-			return true
-		}
-	}
-	return false
+	edge, ok := c.parentEdge(to)
+	return ok && edge.From == node.ID
 }
 
 func cloneNodeWithoutEdges(n *NodeCRDT, crdt *TreeCRDT) *NodeCRDT {
@@ -1096,6 +1237,7 @@ func (c *TreeCRDT) normalize() {
 	for _, node := range c.Nodes {
 		sortEdgesByLSEQ(node.Edges)
 	}
+	c.edgeIndex = nil // Edges with the same label may have been reordered
 }
 
 func (c *TreeCRDT) validAttachment(from, to NodeID) error {
@@ -1103,33 +1245,22 @@ func (c *TreeCRDT) validAttachment(from, to NodeID) error {
 		return fmt.Errorf("cannot attach node %s to itself", from)
 	}
 
-	// 1. Check for cycle
-	visited := make(map[NodeID]bool)
-	var dfs func(NodeID) bool
-	dfs = func(id NodeID) bool {
-		if id == from {
-			return true
+	// 1. Check for cycle, `from` must not be `to` or one of its descendants
+	current := from
+	for steps := 0; steps <= len(c.Nodes); steps++ {
+		if current == to {
+			return fmt.Errorf("adding edge from %s to %s would create a cycle", from, to)
 		}
-		visited[id] = true
-		node := c.Nodes[id]
-		for _, edge := range node.Edges {
-			if !visited[edge.To] && dfs(edge.To) {
-				return true
-			}
+		edge, ok := c.parentEdge(current)
+		if !ok {
+			break
 		}
-		return false
-	}
-	if dfs(to) {
-		return fmt.Errorf("adding edge from %s to %s would create a cycle", from, to)
+		current = edge.From
 	}
 
 	// 2. Check if `to` already has a parent
-	for _, parent := range c.Nodes {
-		for _, edge := range parent.Edges {
-			if edge.To == to {
-				return fmt.Errorf("node %s already has a parent", to)
-			}
-		}
+	if _, ok := c.parentEdge(to); ok {
+		return fmt.Errorf("node %s already has a parent", to)
 	}
 
 	return nil
@@ -1271,22 +1402,7 @@ func (c *TreeCRDT) VerifyTree() error {
 		return fmt.Errorf("VerifyTree: tree structure invalid: %w", err)
 	}
 
-	// Step 2: For each node → verify signature and ABAC
-	for id, node := range c.Nodes {
-		if node.Signature == "" {
-			return fmt.Errorf("VerifyTree: node %s has no signature", id)
-		}
-		recoveredID, err := node.Verify()
-		if err != nil {
-			return fmt.Errorf("VerifyTree: signature verification failed for node %s: %w", id, err)
-		}
-
-		// 2.2 Check ABACPolicy for ActionModify
-		if !c.ABACPolicy.IsAllowed(recoveredID, ActionModify, id) {
-			return fmt.Errorf("VerifyTree: ABAC violation: client %s is not allowed to modify node %s", recoveredID, id)
-		}
-	}
-
+	// Step 2: Verify the ABAC policy once, the rules are then evaluated without re-verifying it for every node
 	_, err := c.ABACPolicy.Verify()
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -1295,30 +1411,62 @@ func (c *TreeCRDT) VerifyTree() error {
 		return fmt.Errorf("VerifyTree: failed to compute ABAC policy hash: %w", err)
 	}
 
-	return nil
+	if err := c.verifyKeys(); err != nil {
+		return fmt.Errorf("VerifyTree: %w", err)
+	}
+	if err := c.verifyDevices(); err != nil {
+		return fmt.Errorf("VerifyTree: %w", err)
+	}
+	if err := c.verifySchemas(); err != nil {
+		return fmt.Errorf("VerifyTree: %w", err)
+	}
+
+	parents := c.parentMap()
+	isDescendant := func(root NodeID, target NodeID) bool {
+		return isDescendantInParentMap(parents, root, target)
+	}
+
+	// Step 3: For each node → verify signature and ABAC, spread over a worker pool
+	return c.forEachNodeParallel(func(node *NodeCRDT) error {
+		if node.Signature == "" {
+			return fmt.Errorf("VerifyTree: node %s has no signature", node.ID)
+		}
+		recoveredID, err := node.verifyCached(c.sigCache)
+		if err != nil {
+			return fmt.Errorf("VerifyTree: signature verification failed for node %s: %w", node.ID, err)
+		}
+		if err := c.verifyRetiredKey(node, recoveredID); err != nil {
+			return fmt.Errorf("VerifyTree: %w", err)
+		}
+
+		// 3.1 Check ABACPolicy for ActionModify
+		if !c.ABACPolicy.isAllowed(recoveredID, ActionModify, node.ID, isDescendant) {
+			return fmt.Errorf("VerifyTree: ABAC violation: client %s is not allowed to modify node %s", recoveredID, node.ID)
+		}
+
+		// 3.2 Check approvals of protected literals
+		if err := c.verifyProposedChanges(node, isDescendant); err != nil {
+			return fmt.Errorf("VerifyTree: approval verification failed for node %s: %w", node.ID, err)
+		}
+
+		return nil
+	})
 }
 
 func (t *TreeCRDT) isDescendant(root NodeID, target NodeID) bool {
 	if root == target {
 		return true
 	}
-	visited := make(map[NodeID]bool)
-	var dfs func(NodeID) bool
-	dfs = func(n NodeID) bool {
-		if visited[n] {
-			return false
-		}
-		visited[n] = true
-		node, ok := t.Nodes[n]
+	current := target
+	for steps := 0; steps <= len(t.Nodes); steps++ {
+		edge, ok := t.parentEdge(current)
 		if !ok {
 			return false
 		}
-		for _, edge := range node.Edges {
-			if edge.To == target || dfs(edge.To) {
-				return true
-			}
+		if edge.From == root {
+			return true
 		}
-		return false
+		current = edge.From
 	}
-	return dfs(root)
+	return false
 }
@@ -287,6 +287,24 @@ func TestTreeCRDTRemoveEdgeWithVersion(t *testing.T) {
 	}
 }
 
+func TestTreeCRDTRemoveEdgeUnlinksChild(t *testing.T) {
+	c := newTreeCRDT()
+	clientID := ClientID("client1")
+
+	parent := c.CreateAttachedNode("parent", Map, c.Root.ID, clientID)
+	child := c.CreateAttachedNode("child", Literal, parent.ID, clientID)
+
+	err := c.RemoveEdge(parent.ID, child.ID, clientID)
+	assert.Nil(t, err, "RemoveEdge should not return error")
+	assert.Equal(t, 0, len(parent.Edges), "Expected 0 edges after removal")
+	assert.Equal(t, NodeID(""), child.ParentID, "The child should be unlinked")
+	assert.Equal(t, c.Root.ID, parent.ParentID, "The parent should keep its own parent")
+
+	err = c.RemoveEdge(parent.ID, "missing", clientID)
+	assert.NotNil(t, err, "RemoveEdge to a missing node should return error")
+	assert.Contains(t, err.Error(), "missing")
+}
+
 func TestTreeCRDTRemoveIndexInArray(t *testing.T) {
 	clientID := ClientID(random.GenerateRandomID())
 
@@ -0,0 +1,237 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// valueIndex is a secondary index over the items of an array or map, keyed by the literal at a field of
+// every item. It is updated incrementally when edges or literals change, and rebuilt on the next lookup
+// after changes it cannot follow, like Load or a change of the path to the collection.
+type valueIndex struct {
+	name       string
+	path       string
+	field      []string
+	collection NodeID            // The array or map at path, empty if the path does not resolve
+	chain      map[NodeID]bool   // The nodes from the root down to the collection, or as far as the path resolves
+	items      map[NodeID]string // Item -> key
+	keys       map[string]map[NodeID]bool
+	stale      bool
+}
+
+// CreateIndex declares a secondary index over the items of the array or map at the JSON pointer path, keyed by
+// the literal at the relative JSON pointer field of every item, e.g. "/name", or "" for arrays of literals.
+// The path does not have to exist yet. Indexes are local to the tree and are not saved.
+func (c *TreeCRDT) CreateIndex(name, path, field string) error {
+	if name == "" {
+		return fmt.Errorf("index name must not be empty")
+	}
+	if _, ok := c.valueIndexes[name]; ok {
+		return fmt.Errorf("index %s already exists", name)
+	}
+	if path == "/" {
+		path = ""
+	}
+	if _, err := splitJSONPointer(path); err != nil {
+		return err
+	}
+	tokens, err := splitJSONPointer(field)
+	if err != nil {
+		return err
+	}
+
+	ix := &valueIndex{name: name, path: path, field: tokens}
+	c.rebuildValueIndex(ix)
+	if c.valueIndexes == nil {
+		c.valueIndexes = make(map[string]*valueIndex)
+	}
+	c.valueIndexes[name] = ix
+
+	log.WithFields(log.Fields{"Index": name, "Path": path, "Field": field, "Items": len(ix.items)}).Debug("Index created")
+
+	return nil
+}
+
+// DropIndex removes a secondary index
+func (c *TreeCRDT) DropIndex(name string) {
+	delete(c.valueIndexes, name)
+}
+
+// FindByIndex returns the items whose indexed field equals value, in collection order
+func (c *TreeCRDT) FindByIndex(name string, value interface{}) ([]*NodeCRDT, error) {
+	ix, ok := c.valueIndexes[name]
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", name)
+	}
+	key, err := indexKey(value)
+	if err != nil {
+		return nil, err
+	}
+	if ix.stale {
+		c.rebuildValueIndex(ix)
+	}
+
+	edges := make([]*EdgeCRDT, 0, len(ix.keys[key]))
+	for id := range ix.keys[key] {
+		if edge, ok := c.parentEdge(id); ok {
+			edges = append(edges, edge)
+		}
+	}
+	if collection, ok := c.Nodes[ix.collection]; ok && collection.IsArray {
+		sortEdgesByLSEQ(edges)
+	} else {
+		sort.Slice(edges, func(i, j int) bool { return edges[i].Label < edges[j].Label })
+	}
+
+	nodes := make([]*NodeCRDT, 0, len(edges))
+	for _, edge := range edges {
+		nodes = append(nodes, c.Nodes[edge.To])
+	}
+	return nodes, nil
+}
+
+// indexKey returns the key of a literal, numbers are normalized like literals in the tree
+func indexKey(value interface{}) (string, error) {
+	key, err := json.Marshal(normalizeNumber(value))
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal index value: %w", err)
+	}
+	return string(key), nil
+}
+
+func (c *TreeCRDT) rebuildValueIndex(ix *valueIndex) {
+	ix.collection = ""
+	ix.chain = map[NodeID]bool{c.Root.ID: true}
+	ix.items = make(map[NodeID]string)
+	ix.keys = make(map[string]map[NodeID]bool)
+	ix.stale = false
+
+	tokens, _ := splitJSONPointer(ix.path)
+	p := &jsonPatcher{tree: c}
+	node, err := p.document()
+	if err != nil || node == nil {
+		return
+	}
+	ix.chain[node.ID] = true
+	for _, token := range tokens {
+		child, ok, err := p.child(node, token)
+		if err != nil || !ok {
+			return
+		}
+		node = child
+		ix.chain[node.ID] = true
+	}
+	if node.IsDeleted || !(node.IsArray || node.IsMap) {
+		return
+	}
+
+	ix.collection = node.ID
+	for _, edge := range node.Edges {
+		c.indexItem(ix, edge.To)
+	}
+}
+
+// indexItem updates the key of an item of the collection
+func (c *TreeCRDT) indexItem(ix *valueIndex, item NodeID) {
+	c.unindexItem(ix, item)
+
+	node, ok := c.Nodes[item]
+	if !ok || node.IsDeleted {
+		return
+	}
+	p := &jsonPatcher{tree: c}
+	for _, token := range ix.field {
+		child, ok, err := p.child(node, token)
+		if err != nil || !ok {
+			return
+		}
+		node = child
+	}
+	if !node.IsLiteral {
+		return
+	}
+	value, err := c.decryptLiteral(node.effectiveValue())
+	if err != nil {
+		return
+	}
+	key, err := indexKey(value)
+	if err != nil {
+		return
+	}
+
+	ix.items[item] = key
+	if ix.keys[key] == nil {
+		ix.keys[key] = make(map[NodeID]bool)
+	}
+	ix.keys[key][item] = true
+}
+
+func (c *TreeCRDT) unindexItem(ix *valueIndex, item NodeID) {
+	key, ok := ix.items[item]
+	if !ok {
+		return
+	}
+	delete(ix.items, item)
+	delete(ix.keys[key], item)
+	if len(ix.keys[key]) == 0 {
+		delete(ix.keys, key)
+	}
+}
+
+// indexTouched updates the item that contains a changed node
+func (c *TreeCRDT) indexTouched(ix *valueIndex, id NodeID) {
+	if ix.collection == "" {
+		return
+	}
+	for steps := 0; steps <= len(c.Nodes); steps++ {
+		edge, ok := c.parentEdge(id)
+		if !ok {
+			return
+		}
+		if edge.From == ix.collection {
+			c.indexItem(ix, id)
+			return
+		}
+		id = edge.From
+	}
+}
+
+// valueIndexEdge updates the secondary indexes after an edge was added or removed
+func (c *TreeCRDT) valueIndexEdge(edge *EdgeCRDT, added bool) {
+	for _, ix := range c.valueIndexes {
+		switch {
+		case ix.stale:
+		case edge.From == ix.collection && added:
+			c.indexItem(ix, edge.To)
+		case edge.From == ix.collection:
+			c.unindexItem(ix, edge.To)
+		case ix.chain[edge.From]:
+			ix.stale = true // The path may resolve to another collection
+		default:
+			c.indexTouched(ix, edge.From)
+		}
+	}
+}
+
+// valueIndexNode updates the secondary indexes after a literal was set or a node was deleted
+func (c *TreeCRDT) valueIndexNode(id NodeID) {
+	for _, ix := range c.valueIndexes {
+		switch {
+		case ix.stale:
+		case ix.chain[id]:
+			ix.stale = true
+		default:
+			c.indexTouched(ix, id)
+		}
+	}
+}
+
+// invalidateValueIndexes rebuilds the secondary indexes on the next lookup
+func (c *TreeCRDT) invalidateValueIndexes() {
+	for _, ix := range c.valueIndexes {
+		ix.stale = true
+	}
+}
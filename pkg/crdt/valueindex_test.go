@@ -0,0 +1,127 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const registryJSON = `{"services": [
+	{"name": "api", "port": 8080},
+	{"name": "db", "port": 5432},
+	{"name": "cache", "port": 6379}
+]}`
+
+func findNames(t *testing.T, c *TreeCRDT, index string, value interface{}) []NodeID {
+	nodes, err := c.FindByIndex(index, value)
+	assert.Nil(t, err)
+	ids := make([]NodeID, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+func TestFindByIndex(t *testing.T) {
+	identity, err := crypto.CreateIdendityFromString("d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6")
+	assert.Nil(t, err)
+
+	c := newTreeCRDT()
+	_, err = c.ImportJSON([]byte(registryJSON), "client1")
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.CreateIndex("byName", "/services", "/name"))
+	assert.Nil(t, c.CreateIndex("byPort", "/services", "/port"))
+	assert.NotNil(t, c.CreateIndex("byName", "/services", "/name"), "Index names are unique")
+
+	db, err := c.GetNodeByPath("/services/1")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{db.ID}, findNames(t, c, "byName", "db"))
+	assert.Equal(t, []NodeID{db.ID}, findNames(t, c, "byPort", 5432))
+	assert.Empty(t, findNames(t, c, "byName", "queue"))
+
+	_, err = c.FindByIndex("missing", "db")
+	assert.NotNil(t, err)
+
+	// The indexes follow changes of the tree
+	err = c.ApplyJSONPatch([]byte(`[
+		{"op": "replace", "path": "/services/1/name", "value": "postgres"},
+		{"op": "add", "path": "/services/-", "value": {"name": "queue", "port": 5672}},
+		{"op": "add", "path": "/services/0", "value": {"name": "db", "port": 3306}},
+		{"op": "remove", "path": "/services/3"}
+	]`), identity)
+	assert.Nil(t, err)
+	assert.False(t, c.valueIndexes["byName"].stale, "Changes to items are indexed incrementally")
+
+	assert.Equal(t, []NodeID{db.ID}, findNames(t, c, "byName", "postgres"))
+	mysql, err := c.GetNodeByPath("/services/0")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{mysql.ID}, findNames(t, c, "byName", "db"))
+	queue, err := c.GetNodeByPath("/services/3")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{queue.ID}, findNames(t, c, "byName", "queue"))
+	assert.Empty(t, findNames(t, c, "byName", "cache"))
+	assert.Empty(t, findNames(t, c, "byPort", 6379))
+
+	// Items with the same value are returned in array order
+	err = c.ApplyJSONPatch([]byte(`[{"op": "replace", "path": "/services/3/name", "value": "db"}]`), identity)
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{mysql.ID, queue.ID}, findNames(t, c, "byName", "db"))
+
+	c.DropIndex("byName")
+	_, err = c.FindByIndex("byName", "db")
+	assert.NotNil(t, err)
+}
+
+func TestFindByIndexMap(t *testing.T) {
+	c := newTreeCRDT()
+	assert.Nil(t, c.CreateIndex("byKind", "/devices", "/kind"), "The path does not have to exist yet")
+
+	_, err := c.ImportJSON([]byte(`{"devices": {"d1": {"kind": "hvac"}, "d2": {"kind": "light"}, "d3": {"kind": "hvac"}}}`), "client1")
+	assert.Nil(t, err)
+
+	d1, err := c.GetNodeByPath("/devices/d1")
+	assert.Nil(t, err)
+	d3, err := c.GetNodeByPath("/devices/d3")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{d1.ID, d3.ID}, findNames(t, c, "byKind", "hvac"))
+}
+
+func TestFindByIndexMergeAndLoad(t *testing.T) {
+	identity, err := crypto.CreateIdendityFromString("d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6")
+	assert.Nil(t, err)
+
+	c1 := newTreeCRDT()
+	_, err = c1.ImportJSON([]byte(registryJSON), "client1")
+	assert.Nil(t, err)
+	c2, err := c1.Clone()
+	assert.Nil(t, err)
+	assert.Nil(t, c1.CreateIndex("byName", "/services", "/name"))
+
+	err = c2.ApplyJSONPatch([]byte(`[{"op": "add", "path": "/services/-", "value": {"name": "queue", "port": 5672}}]`), identity)
+	assert.Nil(t, err)
+	assert.Nil(t, c1.Merge(c2))
+	assert.False(t, c1.valueIndexes["byName"].stale, "Merged items are indexed incrementally")
+
+	queue, err := c1.GetNodeByPath("/services/3")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{queue.ID}, findNames(t, c1, "byName", "queue"))
+
+	saved, err := c1.Save()
+	assert.Nil(t, err)
+	other := newTreeCRDT()
+	_, err = other.ImportJSON([]byte(`{"services": [{"name": "queue"}]}`), "client1")
+	assert.Nil(t, err)
+	saved2, err := other.Save()
+	assert.Nil(t, err)
+
+	// The index is rebuilt when another tree is loaded
+	assert.Nil(t, c1.Load(saved2))
+	queue2, err := c1.GetNodeByPath("/services/0")
+	assert.Nil(t, err)
+	assert.Equal(t, []NodeID{queue2.ID}, findNames(t, c1, "byName", "queue"))
+
+	assert.Nil(t, c1.Load(saved))
+	assert.Equal(t, []NodeID{queue.ID}, findNames(t, c1, "byName", "queue"))
+}
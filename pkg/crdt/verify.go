@@ -0,0 +1,132 @@
+package crdt
+
+import (
+	"runtime"
+	"sync"
+)
+
+// signatureCache remembers which node signatures have already been verified. An entry is only a hit
+// if both the digest and the signature are unchanged, so a node that was modified after it was
+// verified is always verified again. Entries are facts about the signed data and not about the tree,
+// so a cache can safely be shared between a tree and its clones.
+type signatureCache struct {
+	mutex   sync.RWMutex
+	entries map[NodeID]verifiedSignature
+}
+
+type verifiedSignature struct {
+	digest      string
+	signature   string
+	recoveredID string
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{entries: make(map[NodeID]verifiedSignature)}
+}
+
+func (sc *signatureCache) lookup(id NodeID, digest string, signature string) (string, bool) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	entry, ok := sc.entries[id]
+	if !ok || entry.digest != digest || entry.signature != signature {
+		return "", false
+	}
+	return entry.recoveredID, true
+}
+
+func (sc *signatureCache) store(id NodeID, digest string, signature string, recoveredID string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.entries[id] = verifiedSignature{digest: digest, signature: signature, recoveredID: recoveredID}
+}
+
+// verifyCached works like Verify but skips the signature recovery if the node has already been verified
+func (n *NodeCRDT) verifyCached(cache *signatureCache) (string, error) {
+	if cache == nil {
+		return n.Verify()
+	}
+
+	digest, err := n.ComputeDigest()
+	if err != nil {
+		return "", err
+	}
+
+	if recoveredID, ok := cache.lookup(n.ID, digest.String(), n.Signature); ok {
+		return recoveredID, nil
+	}
+
+	recoveredID, err := n.Verify()
+	if err != nil {
+		return "", err
+	}
+	cache.store(n.ID, digest.String(), n.Signature, recoveredID)
+
+	return recoveredID, nil
+}
+
+// forEachNodeParallel runs fn for all nodes on a pool of workers and returns the first error
+func (c *TreeCRDT) forEachNodeParallel(fn func(node *NodeCRDT) error) error {
+	workers := runtime.NumCPU()
+	nodes := make(chan *NodeCRDT, workers)
+	done := make(chan struct{})
+
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range nodes {
+				if err := fn(node); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, node := range c.Nodes {
+		select {
+		case nodes <- node:
+		case <-done:
+			break feed
+		}
+	}
+	close(nodes)
+	wg.Wait()
+
+	return firstErr
+}
+
+// parentMap maps every node to its parent based on the edges of the tree
+func (c *TreeCRDT) parentMap() map[NodeID]NodeID {
+	parents := make(map[NodeID]NodeID, len(c.Nodes))
+	for _, node := range c.Nodes {
+		for _, edge := range node.Edges {
+			parents[edge.To] = node.ID
+		}
+	}
+	return parents
+}
+
+func isDescendantInParentMap(parents map[NodeID]NodeID, root NodeID, target NodeID) bool {
+	current := target
+	for steps := 0; steps <= len(parents); steps++ {
+		if current == root {
+			return true
+		}
+		parent, ok := parents[current]
+		if !ok {
+			return false
+		}
+		current = parent
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package crdt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eislab-cps/synctree/internal/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureTreeVerifyTreeCache(t *testing.T) {
+	prvKey := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+
+	items := make([]string, 0, 200)
+	for i := 0; i < 200; i++ {
+		items = append(items, fmt.Sprintf(`{"id": "item_%d", "value": %d}`, i, i))
+	}
+	json := []byte("[" + strings.Join(items, ",") + "]")
+
+	c, err := NewSecureTree(prvKey)
+	assert.Nil(t, err)
+
+	_, err = c.ImportJSON(json, prvKey)
+	assert.Nil(t, err, "ImportJSON should not return an error")
+
+	tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+
+	err = c.VerifyTree()
+	assert.Nil(t, err, "VerifyTree should not return an error")
+	assert.Equal(t, len(tree.Nodes), len(tree.sigCache.entries), "All verified nodes should be cached")
+
+	t.Run("Detect tampered literal after caching", func(t *testing.T) {
+		node, err := tree.GetNodeByPath("/42/id")
+		assert.Nil(t, err)
+
+		original := node.LiteralValue
+		node.LiteralValue = "tampered"
+		err = c.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should detect a modified literal with an old signature")
+
+		node.LiteralValue = original
+		err = c.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should succeed after restoring the literal")
+	})
+
+	t.Run("Detect tampered signature after caching", func(t *testing.T) {
+		node, err := tree.GetNodeByPath("/7/value")
+		assert.Nil(t, err)
+
+		original := node.Signature
+		node.Signature = "e713a1bb015fecabb5a084b0fe6d6e7271fca6f79525a634183cfdb175fe69241f4da161779d8e6b761200e1cf93766010a19072fa778f9643363e2cfadd640900"
+		err = c.VerifyTree()
+		assert.NotNil(t, err, "VerifyTree should detect an invalid signature")
+
+		node.Signature = original
+		err = c.VerifyTree()
+		assert.Nil(t, err, "VerifyTree should succeed after restoring the signature")
+	})
+
+	t.Run("Share cache with clones", func(t *testing.T) {
+		clone, err := tree.Clone()
+		assert.Nil(t, err)
+		assert.Same(t, tree.sigCache, clone.sigCache, "Clone should share the signature cache")
+	})
+}
+
+func TestSecureTreeVerifyTreeABACViolation(t *testing.T) {
+	prvKey1 := "d6eb959e9aec2e6fdc44b5862b269e987b8a4d6f2baca542d8acaa97ee5e74f6"
+	prvKey2 := "ed26531bac1838e519c2c6562ac717b22aac041730f0d753d3ad35b76b5f4924"
+
+	identity2, err := crypto.CreateIdendityFromString(prvKey2)
+	assert.Nil(t, err)
+
+	c, err := NewSecureTree(prvKey1)
+	assert.Nil(t, err)
+	_, err = c.ImportJSON([]byte(`{"a": {"b": "1"}, "c": "2"}`), prvKey1)
+	assert.Nil(t, err)
+
+	// identity2 is allowed to modify the subtree /a, but not the rest of the tree
+	aNode, err := c.GetNodeByPath("/a")
+	assert.Nil(t, err)
+	err = c.ABAC().Allow(identity2.ID(), ActionModify, aNode.ID(), true)
+	assert.Nil(t, err)
+
+	tree := c.(*AdapterSecureTreeCRDT).treeCrdt
+
+	bNode, err := tree.GetNodeByPath("/a/b")
+	assert.Nil(t, err)
+	err = bNode.SetLiteral("3", ClientID(identity2.ID()))
+	assert.Nil(t, err)
+	err = bNode.Sign(identity2)
+	assert.Nil(t, err)
+
+	err = c.VerifyTree()
+	assert.Nil(t, err, "VerifyTree should accept changes inside the recursive rule")
+
+	cNode, err := tree.GetNodeByPath("/c")
+	assert.Nil(t, err)
+	err = cNode.SetLiteral("4", ClientID(identity2.ID()))
+	assert.Nil(t, err)
+	err = cNode.Sign(identity2)
+	assert.Nil(t, err)
+
+	err = c.VerifyTree()
+	assert.NotNil(t, err, "VerifyTree should reject changes outside the recursive rule")
+	assert.Contains(t, err.Error(), "ABAC violation")
+}
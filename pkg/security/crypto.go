@@ -6,4 +6,10 @@ type Crypto interface {
 	GenerateSignature(data string, prvKey string) (string, error)
 	GenerateHash(data string) string
 	RecoverID(data string, signature string) (string, error)
+	GenerateDID(prvKey string) (string, error)
+	ResolveDID(did string) (string, error)
+	DIDToID(did string) (string, error)
+	GenerateMnemonic() (string, error)
+	MasterPrivateKey(mnemonic string, passphrase string) (string, error)
+	DeriveDevicePrivateKey(mnemonic string, passphrase string, index uint32) (string, error)
 }
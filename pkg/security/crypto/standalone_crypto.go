@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"encoding/hex"
+	"encoding/json"
 
 	"github.com/eislab-cps/synctree/internal/crypto"
 )
@@ -59,3 +60,64 @@ func (standaloneCrypto *StandaloneCrypto) RecoverID(data string, signature strin
 	hash := crypto.GenerateHashFromString(data)
 	return crypto.RecoveredID(hash, []byte(signatureString))
 }
+
+func (standaloneCrypto *StandaloneCrypto) GenerateDID(prvKey string) (string, error) {
+	idendity, err := crypto.CreateIdendityFromString(prvKey)
+	if err != nil {
+		return "", err
+	}
+
+	return idendity.DID(), nil
+}
+
+// ResolveDID returns the DID Document of a did:key identifier as JSON
+func (standaloneCrypto *StandaloneCrypto) ResolveDID(did string) (string, error) {
+	doc, err := crypto.ResolveDID(did)
+	if err != nil {
+		return "", err
+	}
+
+	docJSON, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(docJSON), nil
+}
+
+func (standaloneCrypto *StandaloneCrypto) DIDToID(did string) (string, error) {
+	return crypto.IDFromDID(did)
+}
+
+// GenerateMnemonic returns a 24 word BIP39 mnemonic for backing up a master seed
+func (standaloneCrypto *StandaloneCrypto) GenerateMnemonic() (string, error) {
+	return crypto.NewMnemonic(256)
+}
+
+func (standaloneCrypto *StandaloneCrypto) MasterPrivateKey(mnemonic string, passphrase string) (string, error) {
+	seed, err := crypto.SeedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	idendity, err := crypto.MasterIdentity(seed)
+	if err != nil {
+		return "", err
+	}
+
+	return idendity.PrivateKeyAsHex(), nil
+}
+
+func (standaloneCrypto *StandaloneCrypto) DeriveDevicePrivateKey(mnemonic string, passphrase string, index uint32) (string, error) {
+	seed, err := crypto.SeedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	idendity, err := crypto.DeriveDeviceIdentity(seed, index)
+	if err != nil {
+		return "", err
+	}
+
+	return idendity.PrivateKeyAsHex(), nil
+}
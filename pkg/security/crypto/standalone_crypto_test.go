@@ -37,3 +37,51 @@ func TestRecoverID(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEqual(t, recoveredID, id)
 }
+
+func TestDID(t *testing.T) {
+	crypto := CreateCrypto()
+
+	prvKey, err := crypto.GeneratePrivateKey()
+	assert.Nil(t, err)
+
+	id, err := crypto.GenerateID(prvKey)
+	assert.Nil(t, err)
+
+	did, err := crypto.GenerateDID(prvKey)
+	assert.Nil(t, err)
+
+	recoveredID, err := crypto.DIDToID(did)
+	assert.Nil(t, err)
+	assert.Equal(t, id, recoveredID)
+
+	doc, err := crypto.ResolveDID(did)
+	assert.Nil(t, err)
+	assert.Contains(t, doc, did)
+
+	_, err = crypto.ResolveDID("did:web:example.com")
+	assert.NotNil(t, err)
+}
+
+func TestDeriveDevicePrivateKey(t *testing.T) {
+	crypto := CreateCrypto()
+
+	mnemonic, err := crypto.GenerateMnemonic()
+	assert.Nil(t, err)
+
+	masterPrvKey, err := crypto.MasterPrivateKey(mnemonic, "")
+	assert.Nil(t, err)
+	devicePrvKey, err := crypto.DeriveDevicePrivateKey(mnemonic, "", 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, masterPrvKey, devicePrvKey)
+
+	restored, err := crypto.DeriveDevicePrivateKey(mnemonic, "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, devicePrvKey, restored, "Device keys should be restorable from the mnemonic")
+
+	other, err := crypto.DeriveDevicePrivateKey(mnemonic, "passphrase", 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, devicePrvKey, other, "The passphrase should change the derived keys")
+
+	_, err = crypto.DeriveDevicePrivateKey("not a mnemonic", "", 0)
+	assert.NotNil(t, err)
+}